@@ -19,6 +19,8 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/dedup"
+	"github.com/silverton-io/buz/pkg/enricher"
 	"github.com/silverton-io/buz/pkg/env"
 	"github.com/silverton-io/buz/pkg/handler"
 	inputcloudevents "github.com/silverton-io/buz/pkg/inputCloudevents"
@@ -28,13 +30,17 @@ import (
 	inputwebhook "github.com/silverton-io/buz/pkg/inputWebhook"
 	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/metrics"
 	"github.com/silverton-io/buz/pkg/middleware"
 	"github.com/silverton-io/buz/pkg/params"
+	"github.com/silverton-io/buz/pkg/privacy"
 	"github.com/silverton-io/buz/pkg/protocol"
 	"github.com/silverton-io/buz/pkg/registry"
 	"github.com/silverton-io/buz/pkg/sink"
 	"github.com/silverton-io/buz/pkg/stats"
 	"github.com/silverton-io/buz/pkg/tele"
+	"github.com/silverton-io/buz/pkg/tracing"
+	"github.com/silverton-io/buz/pkg/transformer"
 	"github.com/spf13/viper"
 )
 
@@ -48,16 +54,31 @@ type App struct {
 	sinks         []sink.Sink
 	collectorMeta *meta.CollectorMeta
 	stats         *stats.ProtocolStats
+	sinkStats     *stats.SinkStats
+	persister     *stats.Persister
+	transformer   *transformer.Transformer
+	enrichers     []enricher.Enricher
+	privacyPolicy *privacy.Policy
+	consent       *privacy.ConsentResolver
+	suppression   *privacy.SuppressionList
+	deduplicator  *dedup.Deduplicator
+	tracer        *tracing.Provider
 	debug         bool
 }
 
 func (a *App) handlerParams() params.Handler {
 	params := params.Handler{
-		Config:        a.config,
-		Registry:      a.registry,
-		Manifold:      a.manifold,
-		CollectorMeta: a.collectorMeta,
-		ProtocolStats: a.stats,
+		Config:          a.config,
+		Registry:        a.registry,
+		Manifold:        a.manifold,
+		CollectorMeta:   a.collectorMeta,
+		ProtocolStats:   a.stats,
+		Transformer:     a.transformer,
+		Enrichers:       a.enrichers,
+		PrivacyPolicy:   a.privacyPolicy,
+		ConsentResolver: a.consent,
+		SuppressionList: a.suppression,
+		Deduplicator:    a.deduplicator,
 	}
 	return params
 }
@@ -98,11 +119,29 @@ func (a *App) configure() {
 	a.collectorMeta = meta
 }
 
+func (a *App) initializeTracing() {
+	if !a.config.Tracing.Enabled {
+		return
+	}
+	log.Info().Msg("🟢 initializing tracing")
+	tracer, err := tracing.BuildProvider(a.config.Tracing, a.collectorMeta)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build tracer provider")
+	}
+	a.tracer = tracer
+}
+
 func (a *App) initializeStats() {
 	log.Info().Msg("🟢 initializing stats")
 	ps := stats.ProtocolStats{}
 	ps.Build()
 	a.stats = &ps
+	a.sinkStats = stats.BuildSinkStats()
+	persister, err := stats.BuildPersister(a.config.Stats.Persistence, a.collectorMeta.InstanceId.String(), a.stats)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build stats persister")
+	}
+	a.persister = persister
 }
 
 func (a *App) initializeRegistry() {
@@ -116,7 +155,7 @@ func (a *App) initializeRegistry() {
 
 func (a *App) initializeSinks() {
 	log.Info().Msg("🟢 initializing sinks")
-	sinks, err := sink.BuildAndInitializeSinks(a.config.Sinks)
+	sinks, err := sink.BuildAndInitializeSinks(a.config.Sinks, a.sinkStats)
 	if err != nil {
 		log.Fatal().Err(err).Msg("could not build and init sinks")
 	}
@@ -125,12 +164,65 @@ func (a *App) initializeSinks() {
 
 func (a *App) initializeManifold() {
 	log.Info().Msg("🟢 initializing manifold")
-	manifold := manifold.SimpleManifold{}
-	err := manifold.Initialize(&a.sinks)
+	manifold, err := manifold.BuildAndInitializeManifold(&a.sinks, a.config)
 	if err != nil {
 		log.Fatal().Stack().Err(err).Msg("could not build manifold")
 	}
-	a.manifold = &manifold
+	a.manifold = manifold
+}
+
+func (a *App) initializeTransformer() {
+	log.Info().Msg("🟢 initializing transformer")
+	transformer, err := transformer.BuildTransformer(a.config.Transforms)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build transformer")
+	}
+	a.transformer = transformer
+}
+
+func (a *App) initializeEnrichers() {
+	log.Info().Msg("🟢 initializing enrichers")
+	enrichers, err := enricher.BuildAndInitializeEnrichers(a.config.Enrichers)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build and init enrichers")
+	}
+	a.enrichers = enrichers
+}
+
+func (a *App) initializePrivacyPolicy() {
+	log.Info().Msg("🟢 initializing privacy policy")
+	policy, err := privacy.BuildPolicy(a.config.Privacy, a.registry)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build privacy policy")
+	}
+	a.privacyPolicy = policy
+}
+
+func (a *App) initializeConsentResolver() {
+	log.Info().Msg("🟢 initializing consent resolver")
+	consent, err := privacy.BuildConsentResolver(a.config.Privacy.Consent)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build consent resolver")
+	}
+	a.consent = consent
+}
+
+func (a *App) initializeSuppressionList() {
+	log.Info().Msg("🟢 initializing suppression list")
+	suppression, err := privacy.BuildSuppressionList(a.config.Privacy.Suppression)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build suppression list")
+	}
+	a.suppression = suppression
+}
+
+func (a *App) initializeDeduplicator() {
+	log.Info().Msg("🟢 initializing deduplicator")
+	deduplicator, err := dedup.BuildDeduplicator(a.config.Dedup)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("could not build deduplicator")
+	}
+	a.deduplicator = deduplicator
 }
 
 func (a *App) initializeRouter() {
@@ -145,6 +237,10 @@ func (a *App) initializeRouter() {
 func (a *App) initializeMiddleware() {
 	log.Info().Msg("🟢 initializing middleware")
 	a.engine.Use(gin.Recovery())
+	if a.config.Tracing.Enabled {
+		log.Info().Msg("🟢 initializing tracing middleware")
+		a.engine.Use(middleware.Tracing())
+	}
 	if a.config.Middleware.Timeout.Enabled {
 		log.Info().Msg("🟢 initializing request timeout middleware")
 		a.engine.Use(middleware.Timeout(a.config.Middleware.Timeout))
@@ -163,6 +259,10 @@ func (a *App) initializeMiddleware() {
 		log.Info().Msg("🟢 initializing request logger middleware")
 		a.engine.Use(middleware.RequestLogger())
 	}
+	if a.config.App.EnableMetricsRoute {
+		log.Info().Msg("🟢 initializing metrics middleware")
+		a.engine.Use(middleware.Metrics())
+	}
 	if a.config.Middleware.Yeet.Enabled {
 		log.Info().Msg("🟢 initializing yeet middleware")
 		a.engine.Use(middleware.Yeet())
@@ -175,9 +275,16 @@ func (a *App) initializeOpsRoutes() {
 	log.Info().Msg("🟢 initializing health check route")
 	a.engine.GET(constants.HEALTH_PATH, handler.HealthcheckHandler)
 	log.Info().Msg("🟢 initializing stats route")
-	a.engine.GET(constants.STATS_PATH, handler.StatsHandler(a.collectorMeta, a.stats))
+	a.engine.GET(constants.STATS_PATH, handler.StatsHandler(a.collectorMeta, a.stats, a.sinkStats, a.persister))
 	log.Info().Msg("🟢 initializing overview routes")
 	a.engine.GET(constants.ROUTE_OVERVIEW_PATH, handler.RouteOverviewHandler(*a.config))
+	if a.config.App.EnableMetricsRoute {
+		log.Info().Msg("🟢 initializing metrics route")
+		if err := metrics.RegisterProtocolStats(a.stats); err != nil {
+			log.Fatal().Stack().Err(err).Msg("could not register protocol stats metrics")
+		}
+		a.engine.GET(constants.METRICS_PATH, metrics.Handler())
+	}
 	if a.config.App.EnableConfigRoute {
 		log.Info().Msg("🟢 initializing config overview")
 		a.engine.GET(constants.CONFIG_OVERVIEW_PATH, handler.ConfigOverviewHandler(*a.config))
@@ -195,8 +302,19 @@ func (a *App) initializeSchemaCacheRoutes() {
 	}
 }
 
+func (a *App) initializeAdminRoutes() {
+	if a.suppression != nil && a.config.Privacy.Suppression.Admin.Enabled {
+		path := a.config.Privacy.Suppression.Admin.Path
+		if path == "" {
+			path = privacy.DEFAULT_SUPPRESSION_ADMIN_PATH
+		}
+		log.Info().Msg("🟢 initializing suppression admin route")
+		a.engine.POST(path, privacy.SuppressionHandler(a.suppression, a.config.Privacy.Suppression.Admin.Token))
+	}
+}
+
 func (a *App) initializeSnowplowRoutes() {
-	identityMiddleware := middleware.Identity(a.config.Identity)
+	identityMiddleware := middleware.Identity(a.config.Identity, a.consent)
 	if a.config.Inputs.Snowplow.Enabled {
 		handlerParams := a.handlerParams()
 		log.Info().Msg("🟢 initializing snowplow routes")
@@ -269,14 +387,22 @@ func (a *App) initializeSquawkboxRoutes() {
 func (a *App) Initialize() {
 	log.Info().Msg("🟢 initializing app")
 	a.configure()
+	a.initializeTracing()
 	a.initializeStats()
 	a.initializeSinks()
 	a.initializeManifold()
 	a.initializeRegistry()
+	a.initializeTransformer()
+	a.initializeEnrichers()
+	a.initializePrivacyPolicy()
+	a.initializeConsentResolver()
+	a.initializeSuppressionList()
+	a.initializeDeduplicator()
 	a.initializeRouter()
 	a.initializeMiddleware()
 	a.initializeOpsRoutes()
 	a.initializeSchemaCacheRoutes()
+	a.initializeAdminRoutes()
 	a.initializeSnowplowRoutes()
 	a.initializeSelfDescribingRoutes()
 	a.initializeCloudeventsRoutes()
@@ -285,10 +411,56 @@ func (a *App) Initialize() {
 	a.initializeSquawkboxRoutes()
 }
 
+func (a *App) shutdownManifold() {
+	log.Info().Msg("🟢 shutting down manifold...")
+	if err := a.manifold.Shutdown(); err != nil {
+		log.Error().Stack().Err(err).Msg("🔴 could not cleanly shut down manifold")
+	}
+}
+
+func (a *App) shutdownSinks() {
+	log.Info().Msg("🟢 shutting down sinks...")
+	sink.CloseSinks(a.sinks)
+}
+
+func (a *App) shutdownEnrichers() {
+	log.Info().Msg("🟢 shutting down enrichers...")
+	enricher.CloseEnrichers(a.enrichers)
+}
+
+func (a *App) shutdownSuppressionList() {
+	log.Info().Msg("🟢 shutting down suppression list...")
+	a.suppression.Close()
+}
+
+func (a *App) shutdownDeduplicator() {
+	log.Info().Msg("🟢 shutting down deduplicator...")
+	a.deduplicator.Close()
+}
+
+func (a *App) shutdownStats() {
+	log.Info().Msg("🟢 shutting down stats persister...")
+	a.persister.Close()
+}
+
+func (a *App) shutdownTracing() {
+	log.Info().Msg("🟢 shutting down tracing...")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a.tracer.Shutdown(ctx)
+}
+
 func (a *App) serverlessMode() {
 	log.Debug().Msg("🟡 Running Buz in serverless mode")
 	log.Info().Msg("🐝🐝🐝 buz is running 🐝🐝🐝")
 	err := gateway.ListenAndServe(":3000", a.engine)
+	a.shutdownManifold()
+	a.shutdownSinks()
+	a.shutdownEnrichers()
+	a.shutdownSuppressionList()
+	a.shutdownDeduplicator()
+	a.shutdownStats()
+	a.shutdownTracing()
 	tele.Sis(a.collectorMeta)
 	if err != nil {
 		log.Fatal().Err(err)
@@ -317,6 +489,13 @@ func (a *App) standardMode() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal().Stack().Err(err).Msg("server forced to shutdown")
 	}
+	a.shutdownManifold()
+	a.shutdownSinks()
+	a.shutdownEnrichers()
+	a.shutdownSuppressionList()
+	a.shutdownDeduplicator()
+	a.shutdownStats()
+	a.shutdownTracing()
 	tele.Sis(a.collectorMeta)
 }
 
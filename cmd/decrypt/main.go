@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+// Decrypt envelope fields which were encrypted by a privacy policy.
+// Envelopes are read from stdin as newline-delimited json, and written to stdout.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/env"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/privacy"
+	"github.com/spf13/viper"
+)
+
+func main() {
+	defaultConf := os.Getenv(env.BUZ_CONFIG_PATH)
+	if defaultConf == "" {
+		defaultConf = "config.yml"
+	}
+	conf := flag.String("config", defaultConf, "The buz config containing the privacy keyring")
+	flag.Parse()
+
+	viper.SetConfigFile(*conf)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "could not read config: "+err.Error())
+		os.Exit(1)
+	}
+	c := config.Config{}
+	if err := viper.Unmarshal(&c); err != nil {
+		fmt.Fprintln(os.Stderr, "could not unmarshal config: "+err.Error())
+		os.Exit(1)
+	}
+	keyring, err := privacy.BuildKeyring(c.Privacy.Keyring)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not build keyring: "+err.Error())
+		os.Exit(1)
+	}
+	if keyring == nil {
+		fmt.Fprintln(os.Stderr, "no keyring - is privacy.keyring configured?")
+		os.Exit(1)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	out := json.NewEncoder(os.Stdout)
+	for scanner.Scan() {
+		var e envelope.Envelope
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			fmt.Fprintln(os.Stderr, "skipping invalid envelope: "+err.Error())
+			continue
+		}
+		decrypted, err := keyring.DecryptEnvelope(e)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "could not decrypt envelope "+e.EventMeta.Uuid.String()+": "+err.Error())
+			os.Exit(1)
+		}
+		if err := out.Encode(decrypted); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -5,6 +5,8 @@
 package annotator
 
 import (
+	"context"
+
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/registry"
@@ -28,14 +30,14 @@ func getMetadataFromSchema(schema []byte) envelope.EventMeta {
 	}
 }
 
-func Annotate(envelopes []envelope.Envelope, registry *registry.Registry) []envelope.Envelope {
+func Annotate(ctx context.Context, envelopes []envelope.Envelope, registry *registry.Registry) []envelope.Envelope {
 	var e []envelope.Envelope
 	for _, envelope := range envelopes {
 		log.Debug().Msg("🟡 annotating event")
 		// NOTE - this has the potential to be confusing in the case that
 		// schema-level validation is disabled.
 		// Payload validation is still executed in that case but the outcome is disregarded.
-		isValid, validationError, schemaContents := validator.ValidatePayload(envelope.EventMeta.Schema, envelope.Payload, registry)
+		isValid, validationError, schemaContents := validator.ValidatePayload(ctx, envelope.EventMeta.Schema, envelope.Payload, registry)
 		m := getMetadataFromSchema(schemaContents)
 		if m.Namespace != "" {
 			envelope.EventMeta.Namespace = m.Namespace
@@ -5,11 +5,12 @@
 package config
 
 type App struct {
-	Version           string `json:"version"`
-	Name              string `json:"name"`
-	Env               string `json:"env"`
-	Port              string `json:"port"`
-	TrackerDomain     string `json:"trackerDomain"`
-	EnableConfigRoute bool   `json:"enableConfigRoute"`
-	Serverless        bool   `json:"serverless"`
+	Version            string `json:"version"`
+	Name               string `json:"name"`
+	Env                string `json:"env"`
+	Port               string `json:"port"`
+	TrackerDomain      string `json:"trackerDomain"`
+	EnableConfigRoute  bool   `json:"enableConfigRoute"`
+	EnableMetricsRoute bool   `json:"enableMetricsRoute"`
+	Serverless         bool   `json:"serverless"`
 }
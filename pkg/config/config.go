@@ -10,7 +10,12 @@ type Config struct {
 	Inputs     `json:"inputs"`
 	Registry   `json:"registry"`
 	Manifold   `json:"manifold,omitempty"`
-	Sinks      []Sink `json:"sinks"`
+	Sinks      []Sink      `json:"sinks"`
+	Transforms []Transform `json:"transforms,omitempty"`
+	Enrichers  []Enricher  `json:"enrichers,omitempty"`
+	Dedup      `json:"dedup"`
+	Tracing    `json:"tracing"`
+	Stats      `json:"stats"`
 	Squawkbox  `json:"squawkBox"`
 	Privacy    `json:"privacy"`
 	Tele       `json:"tele"`
@@ -0,0 +1,19 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+type Dedup struct {
+	Enabled      bool   `json:"enabled"`
+	Path         string `json:"path,omitempty"` // Payload path of the event id, for events without a client-provided id
+	Action       string `json:"action"`         // drop or flag
+	TtlSeconds   int    `json:"ttlSeconds"`
+	Store        string `json:"store"`                  // memory or redis
+	MaxSizeBytes int    `json:"maxSizeBytes,omitempty"` // Memory
+	// Redis
+	RedisAddr      string `json:"redisAddr,omitempty"`
+	RedisPassword  string `json:"-"`
+	RedisDb        int    `json:"redisDb,omitempty"`
+	RedisKeyPrefix string `json:"redisKeyPrefix,omitempty"`
+}
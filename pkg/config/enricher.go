@@ -0,0 +1,26 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+type Enricher struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"` // Script, geoip database, or referer database overrides
+	// Script
+	MaxSteps  uint64 `json:"maxSteps,omitempty"`
+	TimeoutMs int    `json:"timeoutMs,omitempty"`
+	// Useragent
+	RouteBotsToInvalid bool `json:"routeBotsToInvalid,omitempty"`
+	// Campaign
+	InternalDomains []string `json:"internalDomains,omitempty"`
+	// Session
+	TimeoutSeconds   int    `json:"timeoutSeconds,omitempty"`
+	RetentionSeconds int    `json:"retentionSeconds,omitempty"`
+	Store            string `json:"store,omitempty"`
+	RedisAddr        string `json:"redisAddr,omitempty"`
+	RedisPassword    string `json:"-"`
+	RedisDb          int    `json:"redisDb,omitempty"`
+	RedisKeyPrefix   string `json:"redisKeyPrefix,omitempty"`
+}
@@ -4,5 +4,26 @@
 
 package config
 
+type Buffer struct {
+	MaxSize         int `json:"maxSize"`
+	BatchSize       int `json:"batchSize"`
+	FlushIntervalMs int `json:"flushIntervalMs"`
+}
+
+type Wal struct {
+	Enabled           bool   `json:"enabled"`
+	Path              string `json:"path"`
+	SegmentSizeBytes  int64  `json:"segmentSizeBytes"`
+	MaxSizeBytes      int64  `json:"maxSizeBytes"`
+	Fsync             string `json:"fsync"`
+	FsyncIntervalMs   int    `json:"fsyncIntervalMs"`
+	RetainSegments    int    `json:"retainSegments"`
+	MaxReplayAttempts int    `json:"maxReplayAttempts"`
+	DeadLetter        string `json:"deadLetter,omitempty"`
+}
+
 type Manifold struct {
+	Type   string `json:"type"`
+	Buffer `json:"buffer"`
+	Wal    `json:"wal"`
 }
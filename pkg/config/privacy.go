@@ -5,7 +5,15 @@
 package config
 
 type Privacy struct {
-	Anonymize `json:"anonymize"`
+	// Deprecated: anonymize flags md5 fields in place - use rules instead
+	Anonymize         `json:"anonymize"`
+	Salt              string       `json:"-"`
+	Keys              []PrivacyKey `json:"keys"`
+	Keyring           []KeyringKey `json:"keyring"` // Data keys for encrypt rules
+	Rules             []PiiRule    `json:"rules"`
+	SchemaAnnotations bool         `json:"schemaAnnotations"`
+	Consent           Consent      `json:"consent"`
+	Suppression       Suppression  `json:"suppression"`
 }
 
 type Anonymize struct {
@@ -21,3 +29,47 @@ type Device struct {
 type User struct {
 	Id bool `json:"id"`
 }
+
+type PrivacyKey struct {
+	Id         string `json:"id"`
+	Secret     string `json:"-"`
+	ActiveFrom string `json:"activeFrom,omitempty"` // RFC3339
+}
+
+type KeyringKey struct {
+	Id         string `json:"id"`
+	Key        string `json:"-"`                    // Base64-encoded 128, 192, or 256-bit AES key
+	ActiveFrom string `json:"activeFrom,omitempty"` // RFC3339
+}
+
+type PiiRule struct {
+	Schema string `json:"schema,omitempty"`
+	Path   string `json:"path"`
+	Action string `json:"action"`
+}
+
+type Consent struct {
+	Enabled  bool     `json:"enabled"`
+	Header   string   `json:"header,omitempty"` // Header containing comma-separated granted categories
+	Cookie   string   `json:"cookie,omitempty"` // Cookie containing comma-separated granted categories
+	Schema   string   `json:"schema,omitempty"` // Glob of the context schema containing consent
+	Path     string   `json:"path,omitempty"`   // Path of the granted categories within that context
+	Collect  []string `json:"collect"`          // Categories required to collect events
+	Identify []string `json:"identify"`         // Categories required to keep identifiers
+	Default  string   `json:"default"`          // Decision when consent is absent
+}
+
+type Suppression struct {
+	Enabled        bool    `json:"enabled"`
+	Backend        Backend `json:"backend"`        // Registry-style backend containing the suppression list
+	Object         string  `json:"object"`         // Suppression list object within the backend
+	RefreshSeconds int     `json:"refreshSeconds"` // How often to reload the list, or never if zero
+	Admin          `json:"admin"`
+}
+
+type Admin struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+	Token   string `json:"-"`       // Bearer token required by the admin route
+	Journal string `json:"journal"` // File admin additions are appended to and reloaded from
+}
@@ -4,15 +4,67 @@
 
 package config
 
+type Retry struct {
+	Enabled           bool    `json:"enabled"`
+	MaxAttempts       int     `json:"maxAttempts"`
+	InitialBackoffMs  int     `json:"initialBackoffMs"`
+	MaxBackoffMs      int     `json:"maxBackoffMs"`
+	Jitter            float64 `json:"jitter"`
+	BreakerThreshold  int     `json:"breakerThreshold"`
+	BreakerCooldownMs int     `json:"breakerCooldownMs"`
+}
+
+type Route struct {
+	Protocol  string `json:"protocol,omitempty"`
+	Vendor    string `json:"vendor,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Schema    string `json:"schema,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Value     string `json:"value,omitempty"`
+}
+
+type Routing struct {
+	Include []Route `json:"include,omitempty"`
+	Exclude []Route `json:"exclude,omitempty"`
+}
+
+type Sampling struct {
+	Enabled   bool    `json:"enabled"`
+	Rate      float64 `json:"rate"`
+	Key       string  `json:"key"`
+	Namespace string  `json:"namespace,omitempty"`
+}
+
+type RateCap struct {
+	Namespace       string  `json:"namespace"`
+	EventsPerSecond float64 `json:"eventsPerSecond"`
+}
+
 type Sink struct {
 	Name             string   `json:"name"`
 	Type             string   `json:"type"`
 	DeliveryRequired bool     `json:"deliveryRequired"`
 	Project          string   `json:"project,omitempty"`
 	KafkaBrokers     []string `json:"kakfaBrokers,omitempty"`
-	// Kafka, Pubsub
+	// Kafka, Pubsub, Pulsar
 	ValidTopic   string `json:"validTopic,omitempty"`
 	InvalidTopic string `json:"invalidTopic,omitempty"`
+	// Pulsar
+	PulsarUrl        string `json:"pulsarUrl,omitempty"`
+	PulsarToken      string `json:"-"`
+	Compression      string `json:"compression,omitempty"`      // none, lz4, zlib, or zstd
+	DisableBatching  bool   `json:"disableBatching,omitempty"`  // Send messages individually
+	BatchMaxMessages uint   `json:"batchMaxMessages,omitempty"` // Max messages per batch
+	BatchMaxDelayMs  int    `json:"batchMaxDelayMs,omitempty"`  // Max time messages wait to be batched
+	// RabbitMQ
+	AmqpUrl         string `json:"-"`
+	ValidExchange   string `json:"validExchange,omitempty"`
+	InvalidExchange string `json:"invalidExchange,omitempty"`
+	ExchangeType    string `json:"exchangeType,omitempty"` // Defaults to topic
+	RoutingKey      string `json:"routingKey,omitempty"`   // Template such as {vendor}.{namespace}.{version}
+	ValidQueue      string `json:"validQueue,omitempty"`   // Optional - declared and bound to the valid exchange
+	InvalidQueue    string `json:"invalidQueue,omitempty"` // Optional - declared and bound to the invalid exchange
 	// Kinesis
 	ValidStream   string `json:"validStream,omitempty"`
 	InvalidStream string `json:"invalidStream,omitempty"`
@@ -65,9 +117,35 @@ type Sink struct {
 	ClickhouseDbName string `json:"-"`
 	ClickhouseUser   string `json:"-"`
 	ClickhousePass   string `json:"-"`
-	// Database
+	// Redshift Database
+	RedshiftHost    string `json:"-"`
+	RedshiftPort    uint16 `json:"-"`
+	RedshiftDbName  string `json:"-"`
+	RedshiftUser    string `json:"-"`
+	RedshiftPass    string `json:"-"`
+	RedshiftIamRole string `json:"-"` // Required - assumed by COPY to read staged objects
+	// Object staging (Redshift)
+	StagingBucket          string `json:"stagingBucket,omitempty"`
+	StagingPrefix          string `json:"stagingPrefix,omitempty"`
+	StagingEndpoint        string `json:"stagingEndpoint,omitempty"` // Only for s3-compatible stores, such as http://localhost:9000
+	StagingRegion          string `json:"stagingRegion,omitempty"`
+	StagingAccessKeyId     string `json:"-"` // Optional - otherwise the default aws credential chain
+	StagingSecretAccessKey string `json:"-"`
+	FlushSize              int    `json:"flushSize,omitempty"`       // Max envelopes per staged object
+	FlushIntervalMs        int    `json:"flushIntervalMs,omitempty"` // Max time envelopes wait to be staged
+	// Azure Synapse / SQL DW Database
+	AzureDwHost   string `json:"-"`
+	AzureDwPort   uint16 `json:"-"`
+	AzureDwDbName string `json:"-"`
+	AzureDwUser   string `json:"-"`
+	AzureDwPass   string `json:"-"`
+	// Database, Bigquery
 	ValidTable   string `json:"validTable,omitempty"`
 	InvalidTable string `json:"invalidTable,omitempty"`
+	// Bigquery
+	Dataset                 string `json:"dataset,omitempty"`
+	BigqueryEndpoint        string `json:"bigqueryEndpoint,omitempty"`        // Emulator http endpoint, such as http://localhost:9050
+	BigqueryStorageEndpoint string `json:"bigqueryStorageEndpoint,omitempty"` // Emulator grpc endpoint, such as localhost:9060
 	// Pubnub
 	ValidChannel   string `json:"validChannel,omitempty"`
 	InvalidChannel string `json:"invalidChannel,omitempty"`
@@ -86,4 +164,10 @@ type Sink struct {
 	// Amplitude
 	AmplitudeApiKey string `json:"-"`
 	AmplitudeRegion string `json:"amplitudeRegion,omitempty"`
+	// Delivery
+	Retry      `json:"retry,omitempty"`
+	DeadLetter string `json:"deadLetter,omitempty"`
+	Routing    `json:"routing,omitempty"`
+	Sampling   `json:"sampling,omitempty"`
+	RateCaps   []RateCap `json:"rateCaps,omitempty"`
 }
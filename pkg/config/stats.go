@@ -0,0 +1,25 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+type StatsPersistence struct {
+	Enabled         bool   `json:"enabled"`
+	Backend         string `json:"backend"`                  // file, postgres, mysql, or timescale
+	Instance        string `json:"instance,omitempty"`       // Stable instance name, so counts survive restarts. Defaults to the instance id.
+	SnapshotSeconds int    `json:"snapshotSeconds"`          // How often stats are snapshotted
+	RetentionHours  int    `json:"retentionHours,omitempty"` // How long per-minute buckets and stale snapshots are kept
+	// File
+	Path string `json:"path,omitempty"` // Directory shared by every instance
+	// Database
+	DbHost string `json:"-"`
+	DbPort uint16 `json:"-"`
+	DbName string `json:"-"`
+	DbUser string `json:"-"`
+	DbPass string `json:"-"`
+}
+
+type Stats struct {
+	Persistence StatsPersistence `json:"persistence"`
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+type Tracing struct {
+	Enabled     bool     `json:"enabled"`
+	Exporter    string   `json:"exporter"`              // otlp or file
+	Endpoint    string   `json:"endpoint,omitempty"`    // otlp - host:port of the collector's http receiver
+	Insecure    bool     `json:"insecure,omitempty"`    // otlp
+	Path        string   `json:"path,omitempty"`        // file
+	SampleRatio *float64 `json:"sampleRatio,omitempty"` // Ratio of new traces to sample, defaults to 1 when unset
+	ServiceName string   `json:"serviceName,omitempty"`
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package config
+
+type Transform struct {
+	Schema string          `json:"schema"`
+	Rules  []TransformRule `json:"rules"`
+}
+
+type TransformRule struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	To    string      `json:"to,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	Type  string      `json:"type,omitempty"`
+}
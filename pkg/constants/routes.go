@@ -5,6 +5,7 @@ const (
 	HEALTH_PATH                     = "/health"
 	ROUTE_OVERVIEW_PATH             = "/routes"
 	CONFIG_OVERVIEW_PATH            = "/config"
+	METRICS_PATH                    = "/metrics"
 	SNOWPLOW_STANDARD_GET_PATH      = "/i"
 	SNOWPLOW_STANDARD_POST_PATH     = "/com.snowplowanalytics.snowplow/tp2"
 	SNOWPLOW_STANDARD_REDIRECT_PATH = "/r/tp2"
@@ -8,6 +8,7 @@ import (
 
 func TestInternalRoutes(t *testing.T) {
 	assert.Equal(t, "/stats", STATS_PATH)
+	assert.Equal(t, "/metrics", METRICS_PATH)
 	assert.Equal(t, "/health", HEALTH_PATH)
 	assert.Equal(t, "/routes", ROUTE_OVERVIEW_PATH)
 	assert.Equal(t, "/config", CONFIG_OVERVIEW_PATH)
@@ -12,6 +12,7 @@ const (
 	MONGODB       = "mongodb"
 	ELASTICSEARCH = "elasticsearch"
 	TIMESCALE     = "timescale"
+	AZUREDW       = "azuredw"
 )
 
 type ConnectionParams struct {
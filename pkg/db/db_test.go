@@ -14,4 +14,5 @@ func TestDbConstants(t *testing.T) {
 	assert.Equal(t, "mongodb", MONGODB)
 	assert.Equal(t, "elasticsearch", ELASTICSEARCH)
 	assert.Equal(t, "timescale", TIMESCALE)
+	assert.Equal(t, "azuredw", AZUREDW)
 }
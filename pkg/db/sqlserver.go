@@ -0,0 +1,21 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package db
+
+import (
+	"net/url"
+	"strconv"
+)
+
+func GenerateSqlServerDsn(params ConnectionParams) string {
+	port := strconv.FormatUint(uint64(params.Port), 10)
+	u := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(params.User, params.Pass),
+		Host:     params.Host + ":" + port,
+		RawQuery: url.Values{"database": {params.Db}}.Encode(),
+	}
+	return u.String()
+}
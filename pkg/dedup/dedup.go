@@ -0,0 +1,135 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dedup
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/silverton-io/buz/pkg/util"
+)
+
+const (
+	DROP string = "drop"
+	FLAG string = "flag"
+)
+
+// Deduplicates envelopes by their client-provided event id within a ttl window.
+//
+// The event id is the Snowplow `eid` or Cloudevents `id` when present,
+// falling back to the configured payload path. Envelopes without an event id
+// are never considered duplicates.
+type Deduplicator struct {
+	path   string
+	action string
+	ttl    time.Duration
+	store  Store
+}
+
+func BuildDeduplicator(conf config.Dedup) (*Deduplicator, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	action := conf.Action
+	switch action {
+	case DROP, FLAG:
+	case "":
+		action = DROP
+	default:
+		return nil, errors.New("unsupported dedup action: " + conf.Action)
+	}
+	ttlSeconds := conf.TtlSeconds
+	if ttlSeconds <= 0 {
+		ttlSeconds = DEFAULT_DEDUP_TTL_SECONDS
+	}
+	store, err := BuildStore(conf)
+	if err != nil {
+		return nil, err
+	}
+	d := Deduplicator{path: conf.Path, action: action, ttl: time.Duration(ttlSeconds) * time.Second, store: store}
+	return &d, nil
+}
+
+// The event id of an envelope, scoped to its protocol.
+func (d *Deduplicator) eventId(e *envelope.Envelope) string {
+	if id := e.Pipeline.Source.EventId; id != nil && *id != "" {
+		return e.EventMeta.Protocol + ":" + *id
+	}
+	if d.path == "" {
+		return ""
+	}
+	if v, ok := util.GetPath(e.Payload, d.path); ok {
+		if id := util.PathString(v); id != "" {
+			return e.EventMeta.Protocol + ":" + id
+		}
+	}
+	return ""
+}
+
+func (d *Deduplicator) Close() {
+	if d == nil {
+		return
+	}
+	if err := d.store.Close(); err != nil {
+		log.Error().Err(err).Msg("🔴 could not close dedup store")
+	}
+}
+
+// Drop or flag envelopes whose event id was already seen within the window.
+// Store errors never drop envelopes.
+func Dedup(envelopes []envelope.Envelope, d *Deduplicator, ps *stats.ProtocolStats) []envelope.Envelope {
+	if d == nil {
+		return envelopes
+	}
+	ctx := context.Background()
+	var envs []envelope.Envelope
+	for _, e := range envelopes {
+		id := d.eventId(&e)
+		if id == "" {
+			envs = append(envs, e)
+			continue
+		}
+		seen, err := d.store.Seen(ctx, id, d.ttl)
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not check dedup store - keeping envelope")
+		}
+		if seen {
+			ps.IncrementDuplicate(&e.EventMeta, 1)
+			if d.action == DROP {
+				continue
+			}
+			if e.Annotations == nil {
+				e.Annotations = &envelope.Annotations{}
+			}
+			e.Annotations.Duplicate = &envelope.Duplicate{EventId: id}
+		}
+		envs = append(envs, e)
+	}
+	return envs
+}
+
+// Forget the event ids of envelopes which could not be distributed,
+// so that client retries are not mistaken for duplicates.
+func Release(envelopes []envelope.Envelope, d *Deduplicator) {
+	if d == nil {
+		return
+	}
+	ctx := context.Background()
+	for _, e := range envelopes {
+		if e.Annotations != nil && e.Annotations.Duplicate != nil {
+			continue
+		}
+		if id := d.eventId(&e); id != "" {
+			if err := d.store.Forget(ctx, id); err != nil {
+				log.Error().Err(err).Msg("🔴 could not release event id from dedup store")
+			}
+		}
+	}
+}
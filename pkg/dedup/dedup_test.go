@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/event"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildEnvelope(eventId string, payloadId string) envelope.Envelope {
+	e := envelope.Envelope{
+		EventMeta: envelope.EventMeta{Protocol: protocol.SNOWPLOW, Namespace: "page_view"},
+		Payload:   event.Payload{"message": map[string]interface{}{"id": payloadId}},
+	}
+	if eventId != "" {
+		e.Pipeline.Source.EventId = &eventId
+	}
+	return e
+}
+
+func TestDedupDrop(t *testing.T) {
+	d, err := BuildDeduplicator(config.Dedup{Enabled: true, Path: "message.id"})
+	assert.Nil(t, err)
+	ps := stats.BuildProtocolStats()
+
+	first := Dedup([]envelope.Envelope{
+		buildEnvelope("a", ""),
+		buildEnvelope("a", ""),
+		buildEnvelope("", "m1"),
+		buildEnvelope("", ""),
+	}, d, ps)
+	assert.Equal(t, 3, len(first))
+
+	retried := Dedup([]envelope.Envelope{buildEnvelope("a", "m1"), buildEnvelope("", "m1"), buildEnvelope("", "")}, d, ps)
+	assert.Equal(t, []envelope.Envelope{buildEnvelope("", "")}, retried)
+	assert.Equal(t, int64(3), ps.Duplicates[protocol.SNOWPLOW]["page_view"])
+}
+
+func TestDedupFlagAndRelease(t *testing.T) {
+	d, _ := BuildDeduplicator(config.Dedup{Enabled: true, Action: FLAG})
+	ps := stats.BuildProtocolStats()
+
+	envs := Dedup([]envelope.Envelope{buildEnvelope("a", ""), buildEnvelope("a", "")}, d, ps)
+	assert.Equal(t, 2, len(envs))
+	assert.Nil(t, envs[0].Annotations)
+	assert.Equal(t, &envelope.Duplicate{EventId: protocol.SNOWPLOW + ":a"}, envs[1].Annotations.Duplicate)
+
+	// Envelopes which could not be distributed are not duplicates when retried
+	Release(envs, d)
+	retried := Dedup([]envelope.Envelope{buildEnvelope("a", "")}, d, ps)
+	assert.Nil(t, retried[0].Annotations)
+}
+
+func TestRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	d, err := BuildDeduplicator(config.Dedup{Enabled: true, Store: REDIS_STORE, RedisAddr: mr.Addr(), TtlSeconds: 60})
+	assert.Nil(t, err)
+	defer d.Close()
+	ps := stats.BuildProtocolStats()
+
+	assert.Equal(t, 1, len(Dedup([]envelope.Envelope{buildEnvelope("a", ""), buildEnvelope("a", "")}, d, ps)))
+	assert.True(t, mr.Exists(DEFAULT_KEY_PREFIX+protocol.SNOWPLOW+":a"))
+	mr.FastForward(61 * time.Second)
+	assert.Equal(t, 1, len(Dedup([]envelope.Envelope{buildEnvelope("a", "")}, d, ps)))
+
+	assert.Nil(t, d.store.Forget(context.Background(), protocol.SNOWPLOW+":a"))
+	assert.False(t, mr.Exists(DEFAULT_KEY_PREFIX+protocol.SNOWPLOW+":a"))
+}
+
+func TestInvalidDeduplicators(t *testing.T) {
+	d, err := BuildDeduplicator(config.Dedup{})
+	assert.Nil(t, d)
+	assert.Nil(t, err)
+	_, err = BuildDeduplicator(config.Dedup{Enabled: true, Action: "merge"})
+	assert.NotNil(t, err)
+	_, err = BuildDeduplicator(config.Dedup{Enabled: true, Store: "memcached"})
+	assert.NotNil(t, err)
+}
+
+func TestDedupEscapedPath(t *testing.T) {
+	d, err := BuildDeduplicator(config.Dedup{Enabled: true, Path: `message\.id`})
+	assert.Nil(t, err)
+	e := buildEnvelope("", "")
+	e.Payload = event.Payload{"message.id": float64(42)}
+	assert.Equal(t, protocol.SNOWPLOW+":42", d.eventId(&e))
+}
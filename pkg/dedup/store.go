@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package dedup
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/go-redis/redis/v8"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	MEMORY_STORE              string = "memory"
+	REDIS_STORE               string = "redis"
+	DEFAULT_KEY_PREFIX        string = "buz:dedup:"
+	DEFAULT_MAX_SIZE_BYTES    int    = 64 * 1024 * 1024
+	DEFAULT_DEDUP_TTL_SECONDS int    = 60 * 60
+)
+
+// A Store remembers event ids for the dedup window.
+type Store interface {
+	// Seen records an event id, and reports whether it was already recorded within the ttl.
+	Seen(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	// Forget an event id, so that it is no longer considered a duplicate.
+	Forget(ctx context.Context, id string) error
+	Close() error
+}
+
+func BuildStore(conf config.Dedup) (Store, error) {
+	switch conf.Store {
+	case MEMORY_STORE, "":
+		maxSizeBytes := conf.MaxSizeBytes
+		if maxSizeBytes <= 0 {
+			maxSizeBytes = DEFAULT_MAX_SIZE_BYTES
+		}
+		store := MemoryStore{cache: freecache.NewCache(maxSizeBytes)}
+		return &store, nil
+	case REDIS_STORE:
+		prefix := conf.RedisKeyPrefix
+		if prefix == "" {
+			prefix = DEFAULT_KEY_PREFIX
+		}
+		client := redis.NewClient(&redis.Options{Addr: conf.RedisAddr, Password: conf.RedisPassword, DB: conf.RedisDb})
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		store := RedisStore{client: client, prefix: prefix}
+		return &store, nil
+	default:
+		return nil, errors.New("unsupported dedup store: " + conf.Store)
+	}
+}
+
+// An in-process store, bounded in size.
+// When full, the oldest ids are evicted before their ttl expires.
+type MemoryStore struct {
+	cache *freecache.Cache
+}
+
+func (s *MemoryStore) Seen(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	seconds := int(ttl.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	prev, err := s.cache.GetOrSet([]byte(id), []byte{1}, seconds)
+	if err != nil {
+		return false, err
+	}
+	return prev != nil, nil
+}
+
+func (s *MemoryStore) Forget(ctx context.Context, id string) error {
+	s.cache.Del([]byte(id))
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// A store backed by Redis (or anything speaking the Redis protocol),
+// which can be shared by every collector instance.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *RedisStore) Seen(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	set, err := s.client.SetNX(ctx, s.prefix+id, 1, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return !set, nil
+}
+
+func (s *RedisStore) Forget(ctx context.Context, id string) error {
+	return s.client.Del(ctx, s.prefix+id).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
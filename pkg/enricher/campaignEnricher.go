@@ -0,0 +1,201 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const (
+	REFERER_MEDIUM_SEARCH   string = "search"
+	REFERER_MEDIUM_SOCIAL   string = "social"
+	REFERER_MEDIUM_EMAIL    string = "email"
+	REFERER_MEDIUM_INTERNAL string = "internal"
+	REFERER_MEDIUM_UNKNOWN  string = "unknown"
+)
+
+//go:embed referers.json
+var bundledReferers []byte
+
+// Click ids appended to landing page urls by ad networks, and the network which appends them
+var clickIds = []struct {
+	name    string
+	network string
+}{
+	{"gclid", "Google"},
+	{"gbraid", "Google"},
+	{"wbraid", "Google"},
+	{"dclid", "Google"},
+	{"msclkid", "Microsoft"},
+	{"fbclid", "Facebook"},
+	{"ttclid", "TikTok"},
+	{"twclid", "Twitter"},
+	{"li_fat_id", "LinkedIn"},
+	{"epik", "Pinterest"},
+	{"yclid", "Yandex"},
+}
+
+// A referer database, keyed by medium and then by source.
+type refererDatabase map[string]map[string]struct {
+	Domains    []string `json:"domains"`
+	Parameters []string `json:"parameters,omitempty"`
+}
+
+type referer struct {
+	medium     string
+	source     string
+	parameters []string
+}
+
+// An enricher which parses campaign parameters and click ids from `Web.Page.Query`,
+// and classifies `Web.Referrer.Host` into a medium and source.
+//
+// Referers are classified using a bundled database. Entries from the
+// database at the configured path are merged over the bundled database.
+type CampaignEnricher struct {
+	name            string
+	referers        map[string]referer
+	internalDomains []string
+}
+
+func (e *CampaignEnricher) Name() string {
+	return e.name
+}
+
+func (e *CampaignEnricher) Type() string {
+	return CAMPAIGN
+}
+
+func (e *CampaignEnricher) index(db refererDatabase) {
+	for medium, sources := range db {
+		for source, r := range sources {
+			for _, domain := range r.Domains {
+				e.referers[strings.ToLower(domain)] = referer{medium: medium, source: source, parameters: r.Parameters}
+			}
+		}
+	}
+}
+
+func (e *CampaignEnricher) Initialize(conf config.Enricher) error {
+	e.name, e.referers = conf.Name, make(map[string]referer)
+	for _, d := range conf.InternalDomains {
+		e.internalDomains = append(e.internalDomains, strings.ToLower(d))
+	}
+	var bundled refererDatabase
+	if err := json.Unmarshal(bundledReferers, &bundled); err != nil {
+		return err
+	}
+	e.index(bundled)
+	if conf.Path != "" {
+		contents, err := os.ReadFile(conf.Path)
+		if err != nil {
+			return err
+		}
+		var overrides refererDatabase
+		if err := json.Unmarshal(contents, &overrides); err != nil {
+			return fmt.Errorf("invalid referer database %s: %w", conf.Path, err)
+		}
+		e.index(overrides)
+	}
+	return nil
+}
+
+// Look up a host, falling back to its parent domains.
+func (e *CampaignEnricher) lookup(host string) (referer, bool) {
+	host = strings.TrimPrefix(host, "www.")
+	for {
+		if r, ok := e.referers[host]; ok {
+			return r, true
+		}
+		i := strings.Index(host, ".")
+		if i < 0 || !strings.Contains(host[i+1:], ".") {
+			return referer{}, false
+		}
+		host = host[i+1:]
+	}
+}
+
+func (e *CampaignEnricher) isInternal(host string, pageHost string) bool {
+	if host == pageHost {
+		return true
+	}
+	for _, d := range e.internalDomains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func queryParam(query *map[string]interface{}, key string) *string {
+	if query == nil {
+		return nil
+	}
+	if v, ok := (*query)[key].(string); ok && v != "" {
+		return &v
+	}
+	return nil
+}
+
+func setIfNil(field **string, value *string) {
+	if *field == nil {
+		*field = value
+	}
+}
+
+func (e *CampaignEnricher) Enrich(env *envelope.Envelope) error {
+	if env.Web == nil {
+		return nil
+	}
+	page, refr := &env.Web.Page, &env.Web.Referrer
+
+	setIfNil(&page.Medium, queryParam(page.Query, "utm_medium"))
+	setIfNil(&page.Source, queryParam(page.Query, "utm_source"))
+	setIfNil(&page.Term, queryParam(page.Query, "utm_term"))
+	setIfNil(&page.Content, queryParam(page.Query, "utm_content"))
+	setIfNil(&page.Campaign, queryParam(page.Query, "utm_campaign"))
+	for _, c := range clickIds {
+		if id := queryParam(page.Query, c.name); id != nil {
+			if env.Enrichments == nil {
+				env.Enrichments = &envelope.Enrichments{}
+			}
+			env.Enrichments.ClickId = &envelope.ClickId{Name: c.name, Id: *id, Network: c.network}
+			break
+		}
+	}
+
+	host := strings.ToLower(refr.Host)
+	if host == "" {
+		return nil
+	}
+	var medium string
+	switch r, ok := e.lookup(host); {
+	case e.isInternal(host, strings.ToLower(page.Host)):
+		medium = REFERER_MEDIUM_INTERNAL
+	case ok:
+		medium = r.medium
+		source := r.source
+		refr.Source = &source
+		for _, p := range r.parameters {
+			if term := queryParam(refr.Query, p); term != nil {
+				refr.Term = term
+				break
+			}
+		}
+	default:
+		medium = REFERER_MEDIUM_UNKNOWN
+	}
+	refr.Medium = &medium
+	return nil
+}
+
+func (e *CampaignEnricher) Close() {}
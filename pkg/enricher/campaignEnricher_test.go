@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildWebEnvelope(pageQuery map[string]interface{}, refrHost string, refrQuery map[string]interface{}) envelope.Envelope {
+	return envelope.Envelope{
+		Web: &envelope.Web{
+			Page:     envelope.PageAttrs{Host: "shop.acme.com", Query: &pageQuery},
+			Referrer: envelope.PageAttrs{Host: refrHost, Query: &refrQuery},
+		},
+	}
+}
+
+func enrichCampaign(t *testing.T, conf config.Enricher, env envelope.Envelope) envelope.Envelope {
+	e := CampaignEnricher{}
+	assert.Nil(t, e.Initialize(conf))
+	assert.Nil(t, e.Enrich(&env))
+	return env
+}
+
+func TestCampaignEnricherParsesCampaign(t *testing.T) {
+	env := enrichCampaign(t, config.Enricher{}, buildWebEnvelope(map[string]interface{}{
+		"utm_source":   "newsletter",
+		"utm_medium":   "email",
+		"utm_campaign": "spring_sale",
+		"gclid":        "abc123",
+	}, "", nil))
+	page := env.Web.Page
+	assert.Equal(t, "newsletter", *page.Source)
+	assert.Equal(t, "email", *page.Medium)
+	assert.Equal(t, "spring_sale", *page.Campaign)
+	assert.Nil(t, page.Term)
+	assert.Equal(t, envelope.ClickId{Name: "gclid", Id: "abc123", Network: "Google"}, *env.Enrichments.ClickId)
+	assert.Nil(t, env.Web.Referrer.Medium)
+}
+
+func TestCampaignEnricherClassifiesReferers(t *testing.T) {
+	var tests = []struct {
+		host   string
+		query  map[string]interface{}
+		medium string
+		source string
+		term   string
+	}{
+		{"www.google.co.uk", map[string]interface{}{"q": "anvils"}, REFERER_MEDIUM_SEARCH, "Google", "anvils"},
+		{"l.facebook.com", nil, REFERER_MEDIUM_SOCIAL, "Facebook", ""},
+		{"mail.google.com", nil, REFERER_MEDIUM_EMAIL, "Gmail", ""},
+		{"shop.acme.com", nil, REFERER_MEDIUM_INTERNAL, "", ""},
+		{"blog.acme.com", nil, REFERER_MEDIUM_INTERNAL, "", ""},
+		{"example.org", nil, REFERER_MEDIUM_UNKNOWN, "", ""},
+		{"mastodon.social", nil, REFERER_MEDIUM_SOCIAL, "Mastodon", ""},
+	}
+	conf := config.Enricher{InternalDomains: []string{"acme.com"}, Path: "testdata/referers.json"}
+	for _, tt := range tests {
+		refr := enrichCampaign(t, conf, buildWebEnvelope(nil, tt.host, tt.query)).Web.Referrer
+		assert.Equal(t, tt.medium, *refr.Medium, tt.host)
+		if tt.source == "" {
+			assert.Nil(t, refr.Source, tt.host)
+		} else {
+			assert.Equal(t, tt.source, *refr.Source, tt.host)
+		}
+		if tt.term == "" {
+			assert.Nil(t, refr.Term, tt.host)
+		} else {
+			assert.Equal(t, tt.term, *refr.Term, tt.host)
+		}
+	}
+}
+
+func TestCampaignEnricherWithoutWeb(t *testing.T) {
+	env := enrichCampaign(t, config.Enricher{}, envelope.Envelope{})
+	assert.Nil(t, env.Web)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/validator"
+)
+
+const (
+	SCRIPT    string = "script"
+	GEOIP     string = "geoip"
+	USERAGENT string = "useragent"
+	CAMPAIGN  string = "campaign"
+	SESSION   string = "session"
+)
+
+type Enricher interface {
+	Name() string
+	Type() string
+	Initialize(conf config.Enricher) error
+	Enrich(e *envelope.Envelope) error
+	Close()
+}
+
+func BuildEnricher(conf config.Enricher) (enricher Enricher, err error) {
+	switch conf.Type {
+	case SCRIPT:
+		enricher := ScriptEnricher{}
+		return &enricher, nil
+	case GEOIP:
+		enricher := GeoIpEnricher{}
+		return &enricher, nil
+	case USERAGENT:
+		enricher := UseragentEnricher{}
+		return &enricher, nil
+	case CAMPAIGN:
+		enricher := CampaignEnricher{}
+		return &enricher, nil
+	case SESSION:
+		enricher := SessionEnricher{}
+		return &enricher, nil
+	default:
+		e := errors.New("unsupported enricher: " + conf.Type)
+		log.Error().Stack().Err(e).Msg("🔴 unsupported enricher")
+		return nil, e
+	}
+}
+
+func BuildAndInitializeEnrichers(conf []config.Enricher) ([]Enricher, error) {
+	var enrichers []Enricher
+	for _, eConf := range conf {
+		enricher, err := BuildEnricher(eConf)
+		if err != nil {
+			log.Error().Err(err).Interface("name", eConf.Name).Interface("type", eConf.Type).Msg("🔴 could not build enricher")
+			return nil, err
+		}
+		if err := enricher.Initialize(eConf); err != nil {
+			log.Error().Err(err).Interface("name", eConf.Name).Interface("type", eConf.Type).Msg("🔴 could not initialize enricher")
+			return nil, err
+		}
+		log.Info().Interface("name", eConf.Name).Interface("type", eConf.Type).Msg("🟢 " + eConf.Type + " enricher initialized")
+		enrichers = append(enrichers, enricher)
+	}
+	return enrichers, nil
+}
+
+// Mark an envelope invalid, recording the reason it was invalidated.
+func Invalidate(e *envelope.Envelope, enricher Enricher, reason string) {
+	isValid := false
+	e.Validation.IsValid = &isValid
+	e.Validation.Error = &envelope.ValidationError{
+		ErrorType:       &validator.InvalidatedByEnricher.Type,
+		ErrorResolution: &validator.InvalidatedByEnricher.Resolution,
+		Errors: []envelope.PayloadValidationError{{
+			Field:       enricher.Name(),
+			Description: reason,
+			ErrorType:   enricher.Type(),
+		}},
+	}
+}
+
+// Enrich runs every enricher, in order, on each envelope.
+// An enricher which fails leaves the envelope as the previous enricher left it.
+func Enrich(envelopes []envelope.Envelope, enrichers []Enricher) []envelope.Envelope {
+	if len(enrichers) == 0 {
+		return envelopes
+	}
+	var envs []envelope.Envelope
+	for _, e := range envelopes {
+		log.Debug().Msg("🟡 enriching event")
+		for _, enricher := range enrichers {
+			enriched := e
+			if err := enricher.Enrich(&enriched); err != nil {
+				log.Error().Err(err).Interface("enricher", enricher.Name()).Interface("type", enricher.Type()).Msg("🔴 could not enrich envelope")
+				continue
+			}
+			e = enriched
+		}
+		envs = append(envs, e)
+	}
+	return envs
+}
+
+func CloseEnrichers(enrichers []Enricher) {
+	for _, enricher := range enrichers {
+		enricher.Close()
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const GEOIP_LANGUAGE string = "en"
+
+// An enricher which resolves `Device.Ip` to `Device.Location`
+// using a local MaxMind city database.
+//
+// The database is reloaded whenever the file changes, so it can be
+// updated in place (or atomically replaced) without a restart.
+type GeoIpEnricher struct {
+	name    string
+	path    string
+	mu      sync.RWMutex
+	reader  *geoip2.Reader
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+func (e *GeoIpEnricher) Name() string {
+	return e.name
+}
+
+func (e *GeoIpEnricher) Type() string {
+	return GEOIP
+}
+
+func (e *GeoIpEnricher) Initialize(conf config.Enricher) error {
+	e.name = conf.Name
+	path, err := filepath.Abs(conf.Path)
+	if err != nil {
+		return err
+	}
+	e.path = path
+	reader, err := geoip2.Open(e.path)
+	if err != nil {
+		return err
+	}
+	e.reader = reader
+	// Watch the directory rather than the file so atomic replacements are picked up
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(e.path)); err != nil {
+		watcher.Close()
+		reader.Close()
+		return err
+	}
+	e.watcher, e.done = watcher, make(chan struct{})
+	go e.watch()
+	return nil
+}
+
+func (e *GeoIpEnricher) watch() {
+	defer close(e.done)
+	for {
+		select {
+		case event, ok := <-e.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == e.path && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				e.reload()
+			}
+		case err, ok := <-e.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error().Err(err).Interface("path", e.path).Msg("🔴 geoip database watcher error")
+		}
+	}
+}
+
+// Swap in a freshly-opened database.
+// If the new database cannot be opened (such as while it is being written)
+// the current database continues to be used.
+func (e *GeoIpEnricher) reload() {
+	reader, err := geoip2.Open(e.path)
+	if err != nil {
+		log.Error().Err(err).Interface("path", e.path).Msg("🔴 could not reload geoip database - continuing with the current database")
+		return
+	}
+	e.mu.Lock()
+	previous := e.reader
+	e.reader = reader
+	e.mu.Unlock()
+	previous.Close()
+	log.Info().Interface("path", e.path).Msg("🟢 geoip database reloaded")
+}
+
+func (e *GeoIpEnricher) Enrich(env *envelope.Envelope) error {
+	ip := net.ParseIP(env.Device.Ip)
+	if ip == nil {
+		return nil
+	}
+	e.mu.RLock()
+	record, err := e.reader.City(ip)
+	e.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if record.Country.IsoCode == "" && record.Location.Latitude == 0 && record.Location.Longitude == 0 {
+		// Not in the database
+		return nil
+	}
+	location := envelope.Location{}
+	if record.Country.IsoCode != "" {
+		location.Country = &record.Country.IsoCode
+	}
+	if len(record.Subdivisions) > 0 && record.Subdivisions[0].IsoCode != "" {
+		location.Region = &record.Subdivisions[0].IsoCode
+	}
+	if city, ok := record.City.Names[GEOIP_LANGUAGE]; ok {
+		location.City = &city
+	}
+	if record.Location.MetroCode != 0 {
+		dma := strconv.FormatUint(uint64(record.Location.MetroCode), 10)
+		location.Dma = &dma
+	}
+	location.Latitude, location.Longitude = &record.Location.Latitude, &record.Location.Longitude
+	env.Device.Location = &location
+	return nil
+}
+
+func (e *GeoIpEnricher) Close() {
+	e.watcher.Close()
+	<-e.done
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reader.Close()
+}
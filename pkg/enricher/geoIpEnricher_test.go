@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+const testGeoIpDatabase = "testdata/GeoIP2-City-Test.mmdb"
+
+func TestGeoIpEnricher(t *testing.T) {
+	e := GeoIpEnricher{}
+	assert.Nil(t, e.Initialize(config.Enricher{Name: "geo", Path: testGeoIpDatabase}))
+	defer e.Close()
+
+	london := envelope.Envelope{Device: envelope.Device{Ip: "81.2.69.142"}}
+	assert.Nil(t, e.Enrich(&london))
+	assert.Equal(t, "GB", *london.Device.Location.Country)
+	assert.Equal(t, "ENG", *london.Device.Location.Region)
+	assert.Equal(t, "London", *london.Device.Location.City)
+	assert.Nil(t, london.Device.Location.Dma)
+	assert.Equal(t, 51.5142, *london.Device.Location.Latitude)
+
+	milton := envelope.Envelope{Device: envelope.Device{Ip: "216.160.83.56"}}
+	assert.Nil(t, e.Enrich(&milton))
+	assert.Equal(t, "819", *milton.Device.Location.Dma)
+
+	for _, ip := range []string{"10.0.0.1", "", "not-an-ip"} {
+		unknown := envelope.Envelope{Device: envelope.Device{Ip: ip}}
+		assert.Nil(t, e.Enrich(&unknown))
+		assert.Nil(t, unknown.Device.Location)
+	}
+}
+
+func TestGeoIpEnricherReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	db, _ := os.ReadFile(testGeoIpDatabase)
+	assert.Nil(t, os.WriteFile(path, db, 0644))
+
+	e := GeoIpEnricher{}
+	assert.Nil(t, e.Initialize(config.Enricher{Name: "geo", Path: path}))
+	defer e.Close()
+	e.mu.RLock()
+	original := e.reader
+	e.mu.RUnlock()
+
+	// A corrupt database is ignored
+	assert.Nil(t, os.WriteFile(path+".tmp", []byte("garbage"), 0644))
+	assert.Nil(t, os.Rename(path+".tmp", path))
+	time.Sleep(100 * time.Millisecond)
+	e.mu.RLock()
+	assert.Equal(t, original, e.reader)
+	e.mu.RUnlock()
+
+	// A valid replacement is swapped in
+	assert.Nil(t, os.WriteFile(path+".tmp", db, 0644))
+	assert.Nil(t, os.Rename(path+".tmp", path))
+	assert.Eventually(t, func() bool {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		return e.reader != original
+	}, 2*time.Second, 10*time.Millisecond)
+
+	london := envelope.Envelope{Device: envelope.Device{Ip: "81.2.69.142"}}
+	assert.Nil(t, e.Enrich(&london))
+	assert.Equal(t, "GB", *london.Device.Location.Country)
+}
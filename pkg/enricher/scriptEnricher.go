@@ -0,0 +1,196 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+)
+
+const (
+	SCRIPT_ENTRYPOINT          string = "process"
+	DEFAULT_SCRIPT_MAX_STEPS   uint64 = 100000
+	DEFAULT_SCRIPT_TIMEOUT_MS  int    = 50
+	scriptResultThreadLocalKey string = "result"
+)
+
+// Everything a script asked for via its builtins while processing a single envelope.
+type scriptResult struct {
+	enrichments map[string]interface{}
+	annotations map[string]interface{}
+	invalid     *string
+}
+
+// An enricher which runs a user-supplied Starlark script on every envelope.
+//
+// The script must define `process(envelope)`, which receives the envelope as a dict.
+// Fields can be rewritten by mutating the dict (or returning a new one), and the
+// following builtins are available:
+//
+//	enrich(key, value)   - add a custom enrichment
+//	annotate(key, value) - add a custom annotation
+//	invalidate(reason)   - mark the envelope invalid
+//
+// Every call is bounded by a step budget and a wall-clock timeout.
+type ScriptEnricher struct {
+	name     string
+	process  starlark.Callable
+	maxSteps uint64
+	timeout  time.Duration
+}
+
+func (e *ScriptEnricher) Name() string {
+	return e.name
+}
+
+func (e *ScriptEnricher) Type() string {
+	return SCRIPT
+}
+
+func builtinResult(thread *starlark.Thread) *scriptResult {
+	return thread.Local(scriptResultThreadLocalKey).(*scriptResult)
+}
+
+func toGo(v starlark.Value) (interface{}, error) {
+	encoded, err := starlark.Call(&starlark.Thread{}, starlarkjson.Module.Members["encode"], starlark.Tuple{v}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var i interface{}
+	err = json.Unmarshal([]byte(encoded.(starlark.String)), &i)
+	return i, err
+}
+
+func toStarlark(thread *starlark.Thread, b []byte) (starlark.Value, error) {
+	return starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(b)}, nil)
+}
+
+func setter(name string, target func(r *scriptResult) map[string]interface{}) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		var value starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &key, &value); err != nil {
+			return nil, err
+		}
+		v, err := toGo(value)
+		if err != nil {
+			return nil, err
+		}
+		target(builtinResult(thread))[key] = v
+		return starlark.None, nil
+	})
+}
+
+var scriptBuiltins = starlark.StringDict{
+	"json":     starlarkjson.Module,
+	"enrich":   setter("enrich", func(r *scriptResult) map[string]interface{} { return r.enrichments }),
+	"annotate": setter("annotate", func(r *scriptResult) map[string]interface{} { return r.annotations }),
+	"invalidate": starlark.NewBuiltin("invalidate", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var reason string
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &reason); err != nil {
+			return nil, err
+		}
+		builtinResult(thread).invalid = &reason
+		return starlark.None, nil
+	}),
+}
+
+func (e *ScriptEnricher) Initialize(conf config.Enricher) error {
+	e.name = conf.Name
+	e.maxSteps, e.timeout = conf.MaxSteps, time.Duration(conf.TimeoutMs)*time.Millisecond
+	if e.maxSteps == 0 {
+		e.maxSteps = DEFAULT_SCRIPT_MAX_STEPS
+	}
+	if e.timeout <= 0 {
+		e.timeout = time.Duration(DEFAULT_SCRIPT_TIMEOUT_MS) * time.Millisecond
+	}
+	src, err := os.ReadFile(conf.Path)
+	if err != nil {
+		return err
+	}
+	thread := &starlark.Thread{Name: conf.Name}
+	thread.SetMaxExecutionSteps(e.maxSteps)
+	globals, err := starlark.ExecFile(thread, conf.Path, src, scriptBuiltins)
+	if err != nil {
+		return err
+	}
+	process, ok := globals[SCRIPT_ENTRYPOINT].(starlark.Callable)
+	if !ok {
+		return errors.New("script must define a " + SCRIPT_ENTRYPOINT + "(envelope) function")
+	}
+	// Globals are frozen by ExecFile, so the script can safely run concurrently
+	e.process = process
+	return nil
+}
+
+func (e *ScriptEnricher) Enrich(env *envelope.Envelope) error {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	result := &scriptResult{enrichments: make(map[string]interface{}), annotations: make(map[string]interface{})}
+	thread := &starlark.Thread{Name: e.name}
+	thread.SetLocal(scriptResultThreadLocalKey, result)
+	thread.SetMaxExecutionSteps(e.maxSteps)
+	timer := time.AfterFunc(e.timeout, func() { thread.Cancel("script timed out") })
+	defer timer.Stop()
+
+	arg, err := toStarlark(thread, b)
+	if err != nil {
+		return err
+	}
+	returned, err := starlark.Call(thread, e.process, starlark.Tuple{arg}, nil)
+	if err != nil {
+		return err
+	}
+	if returned == starlark.None {
+		returned = arg
+	}
+	processed, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{returned}, nil)
+	if err != nil {
+		return err
+	}
+	var enriched envelope.Envelope
+	if err := json.Unmarshal([]byte(processed.(starlark.String)), &enriched); err != nil {
+		return err
+	}
+
+	if len(result.enrichments) > 0 {
+		if enriched.Enrichments == nil {
+			enriched.Enrichments = &envelope.Enrichments{}
+		}
+		if enriched.Enrichments.Custom == nil {
+			enriched.Enrichments.Custom = make(map[string]interface{})
+		}
+		for k, v := range result.enrichments {
+			enriched.Enrichments.Custom[k] = v
+		}
+	}
+	if len(result.annotations) > 0 {
+		if enriched.Annotations == nil {
+			enriched.Annotations = &envelope.Annotations{}
+		}
+		if enriched.Annotations.Custom == nil {
+			enriched.Annotations.Custom = make(map[string]interface{})
+		}
+		for k, v := range result.annotations {
+			enriched.Annotations.Custom[k] = v
+		}
+	}
+	if result.invalid != nil {
+		Invalidate(&enriched, e, *result.invalid)
+	}
+	*env = enriched
+	return nil
+}
+
+func (e *ScriptEnricher) Close() {}
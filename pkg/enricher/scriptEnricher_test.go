@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildEnvelope(payload event.Payload) envelope.Envelope {
+	valid := true
+	return envelope.Envelope{
+		Validation: envelope.Validation{IsValid: &valid},
+		Payload:    payload,
+	}
+}
+
+func TestScriptEnricher(t *testing.T) {
+	enrichers, err := BuildAndInitializeEnrichers([]config.Enricher{{Name: "orders", Type: SCRIPT, Path: "testdata/enrich.star"}})
+	assert.Nil(t, err)
+
+	envelopes := Enrich([]envelope.Envelope{
+		buildEnvelope(event.Payload{"orderId": "a", "price": 60, "qty": 2}),
+		buildEnvelope(event.Payload{"price": 1, "qty": 1}),
+	}, enrichers)
+
+	gold := envelopes[0]
+	assert.Equal(t, float64(120), gold.Payload["total"])
+	assert.Equal(t, "gold", gold.Enrichments.Custom["tier"])
+	assert.Equal(t, "enrich.star", gold.Annotations.Custom["script"])
+	assert.True(t, *gold.Validation.IsValid)
+
+	invalid := envelopes[1]
+	assert.Equal(t, "standard", invalid.Enrichments.Custom["tier"])
+	assert.False(t, *invalid.Validation.IsValid)
+	assert.Equal(t, "missing order id", invalid.Validation.Error.Errors[0].Description)
+	assert.Equal(t, "orders", invalid.Validation.Error.Errors[0].Field)
+}
+
+func TestScriptEnricherBudget(t *testing.T) {
+	enrichers, err := BuildAndInitializeEnrichers([]config.Enricher{{Name: "loop", Type: SCRIPT, Path: "testdata/loop.star", MaxSteps: 1000}})
+	assert.Nil(t, err)
+	e := buildEnvelope(event.Payload{"a": "b"})
+	assert.NotNil(t, enrichers[0].Enrich(&e))
+
+	// Envelopes the script could not process are passed through untouched
+	envelopes := Enrich([]envelope.Envelope{e}, enrichers)
+	assert.Equal(t, e, envelopes[0])
+}
+
+func TestBuildEnricher(t *testing.T) {
+	_, err := BuildEnricher(config.Enricher{Type: "unknown"})
+	assert.NotNil(t, err)
+	_, err = BuildAndInitializeEnrichers([]config.Enricher{{Type: SCRIPT, Path: "testdata/missing.star"}})
+	assert.NotNil(t, err)
+}
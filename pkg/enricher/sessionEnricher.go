@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"context"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const (
+	DEFAULT_SESSION_TIMEOUT_SECONDS   int = 1800
+	DEFAULT_SESSION_RETENTION_SECONDS int = 60 * 60 * 24 * 30
+)
+
+// An enricher which assigns `Session.Id` and `Session.Idx` per `Device.Id`.
+// A device's session ends after the configured period of inactivity,
+// and `Session.Idx` counts the device's sessions.
+//
+// Envelopes which already have a session (such as those from Snowplow trackers)
+// or which have no device id are left as-is.
+type SessionEnricher struct {
+	name    string
+	timeout time.Duration
+	store   SessionStore
+}
+
+func (e *SessionEnricher) Name() string {
+	return e.name
+}
+
+func (e *SessionEnricher) Type() string {
+	return SESSION
+}
+
+func (e *SessionEnricher) Initialize(conf config.Enricher) error {
+	timeoutSeconds, retentionSeconds := conf.TimeoutSeconds, conf.RetentionSeconds
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = DEFAULT_SESSION_TIMEOUT_SECONDS
+	}
+	if retentionSeconds <= 0 {
+		retentionSeconds = DEFAULT_SESSION_RETENTION_SECONDS
+	}
+	store, err := BuildSessionStore(conf, time.Duration(retentionSeconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	e.name, e.timeout, e.store = conf.Name, time.Duration(timeoutSeconds)*time.Second, store
+	return nil
+}
+
+func (e *SessionEnricher) Enrich(env *envelope.Envelope) error {
+	if env.Session != nil || env.Device.Id == "" {
+		return nil
+	}
+	at := env.Pipeline.Collector.Tstamp
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	id, idx, err := e.store.Touch(context.Background(), env.Device.Id, at, e.timeout)
+	if err != nil {
+		return err
+	}
+	env.Session = &envelope.Session{Id: &id, Idx: &idx}
+	return nil
+}
+
+func (e *SessionEnricher) Close() {
+	e.store.Close()
+}
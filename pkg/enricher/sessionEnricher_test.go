@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildSessionEnvelope(deviceId string, at time.Time) envelope.Envelope {
+	e := envelope.Envelope{Device: envelope.Device{Id: deviceId}}
+	e.Pipeline.Collector.Tstamp = at
+	return e
+}
+
+func assertSessionization(t *testing.T, e *SessionEnricher) {
+	start := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	session := func(deviceId string, at time.Time) *envelope.Session {
+		env := buildSessionEnvelope(deviceId, at)
+		assert.Nil(t, e.Enrich(&env))
+		return env.Session
+	}
+
+	first := session("a", start)
+	assert.Equal(t, int64(1), *first.Idx)
+	// Activity within the timeout continues the session
+	second := session("a", start.Add(20*time.Minute))
+	assert.Equal(t, *first.Id, *second.Id)
+	assert.Equal(t, int64(1), *second.Idx)
+	// Devices are sessionized independently
+	other := session("b", start.Add(21*time.Minute))
+	assert.NotEqual(t, *first.Id, *other.Id)
+	assert.Equal(t, int64(1), *other.Idx)
+	// Inactivity starts a new session
+	third := session("a", start.Add(60*time.Minute))
+	assert.NotEqual(t, *first.Id, *third.Id)
+	assert.Equal(t, int64(2), *third.Idx)
+}
+
+func TestSessionEnricherMemoryStore(t *testing.T) {
+	e := SessionEnricher{}
+	assert.Nil(t, e.Initialize(config.Enricher{Name: "session", TimeoutSeconds: 30 * 60}))
+	defer e.Close()
+	assertSessionization(t, &e)
+}
+
+func TestSessionEnricherRedisStore(t *testing.T) {
+	r := miniredis.RunT(t)
+	e := SessionEnricher{}
+	assert.Nil(t, e.Initialize(config.Enricher{Name: "session", TimeoutSeconds: 30 * 60, Store: REDIS_SESSION_STORE, RedisAddr: r.Addr()}))
+	defer e.Close()
+	assertSessionization(t, &e)
+	assert.True(t, r.Exists(DEFAULT_SESSION_KEY_PREFIX+"a"))
+}
+
+func TestSessionEnricherSkipsExistingSessions(t *testing.T) {
+	e := SessionEnricher{}
+	assert.Nil(t, e.Initialize(config.Enricher{}))
+	id := "snowplow-session"
+	env := buildSessionEnvelope("a", time.Now())
+	env.Session = &envelope.Session{Id: &id}
+	assert.Nil(t, e.Enrich(&env))
+	assert.Equal(t, "snowplow-session", *env.Session.Id)
+
+	anonymous := buildSessionEnvelope("", time.Now())
+	assert.Nil(t, e.Enrich(&anonymous))
+	assert.Nil(t, anonymous.Session)
+}
+
+func TestMemorySessionStoreSweeps(t *testing.T) {
+	s := NewMemorySessionStore(time.Hour)
+	start := time.Now()
+	_, _, _ = s.Touch(context.Background(), "a", start, time.Minute)
+	_, _, _ = s.Touch(context.Background(), "b", start.Add(30*time.Minute), time.Minute)
+	_, _, _ = s.Touch(context.Background(), "c", start.Add(61*time.Minute), time.Minute)
+	assert.NotContains(t, s.sessions, "a")
+	// Swept shortly after expiring rather than a full retention period after the last sweep
+	_, _, _ = s.Touch(context.Background(), "d", start.Add(92*time.Minute), time.Minute)
+	assert.NotContains(t, s.sessions, "b")
+	assert.Contains(t, s.sessions, "c")
+}
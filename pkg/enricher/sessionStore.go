@@ -0,0 +1,158 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	MEMORY_SESSION_STORE       string = "memory"
+	REDIS_SESSION_STORE        string = "redis"
+	DEFAULT_SESSION_KEY_PREFIX string = "buz:session:"
+)
+
+// Inactive devices are swept on a short fixed interval rather than once per
+// retention period, so they are held for at most retention + interval.
+const SESSION_SWEEP_INTERVAL = 1 * time.Minute
+
+type sessionState struct {
+	id       string
+	idx      int64
+	lastSeen time.Time
+}
+
+// A SessionStore tracks the current session of every device.
+type SessionStore interface {
+	// Touch records activity for a device at the given time and returns its session.
+	// A new session is started if the device has no session, or if it has been
+	// inactive for longer than the timeout.
+	Touch(ctx context.Context, deviceId string, at time.Time, timeout time.Duration) (id string, idx int64, err error)
+	Close() error
+}
+
+func BuildSessionStore(conf config.Enricher, retention time.Duration) (SessionStore, error) {
+	switch conf.Store {
+	case MEMORY_SESSION_STORE, "":
+		store := NewMemorySessionStore(retention)
+		return store, nil
+	case REDIS_SESSION_STORE:
+		prefix := conf.RedisKeyPrefix
+		if prefix == "" {
+			prefix = DEFAULT_SESSION_KEY_PREFIX
+		}
+		client := redis.NewClient(&redis.Options{Addr: conf.RedisAddr, Password: conf.RedisPassword, DB: conf.RedisDb})
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		store := RedisSessionStore{client: client, prefix: prefix, retention: retention}
+		return &store, nil
+	default:
+		return nil, errors.New("unsupported session store: " + conf.Store)
+	}
+}
+
+// An in-process session store.
+// Sessions are not shared between collector instances, so requests from a device
+// should be routed to the same instance (or a shared store should be used).
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]*sessionState
+	retention time.Duration
+	lastSweep time.Time
+}
+
+func NewMemorySessionStore(retention time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]*sessionState), retention: retention}
+}
+
+// Forget devices which have been inactive for longer than the retention period.
+func (s *MemorySessionStore) sweep(now time.Time) {
+	for deviceId, state := range s.sessions {
+		if now.Sub(state.lastSeen) > s.retention {
+			delete(s.sessions, deviceId)
+		}
+	}
+	s.lastSweep = now
+}
+
+func (s *MemorySessionStore) Touch(ctx context.Context, deviceId string, at time.Time, timeout time.Duration) (string, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at.Sub(s.lastSweep) >= SESSION_SWEEP_INTERVAL {
+		s.sweep(at)
+	}
+	state, ok := s.sessions[deviceId]
+	if !ok {
+		state = &sessionState{}
+		s.sessions[deviceId] = state
+	}
+	if state.id == "" || at.Sub(state.lastSeen) > timeout {
+		state.id, state.idx = uuid.New().String(), state.idx+1
+	}
+	if at.After(state.lastSeen) {
+		state.lastSeen = at
+	}
+	return state.id, state.idx, nil
+}
+
+func (s *MemorySessionStore) Close() error {
+	return nil
+}
+
+// Atomically touch a device's session hash.
+// KEYS[1] = device key, ARGV = [now ms, timeout ms, candidate session id, retention ms]
+var touchSessionScript = redis.NewScript(`
+local last = redis.call('HGET', KEYS[1], 'last')
+local id = redis.call('HGET', KEYS[1], 'id')
+local idx = tonumber(redis.call('HGET', KEYS[1], 'idx') or '0')
+local now = tonumber(ARGV[1])
+if (not id) or (now - tonumber(last) > tonumber(ARGV[2])) then
+	id = ARGV[3]
+	idx = idx + 1
+end
+if (not last) or now > tonumber(last) then
+	last = now
+end
+redis.call('HSET', KEYS[1], 'id', id, 'idx', idx, 'last', last)
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {id, idx}
+`)
+
+// A session store backed by Redis (or anything speaking the Redis protocol),
+// which can be shared by every collector instance.
+type RedisSessionStore struct {
+	client    *redis.Client
+	prefix    string
+	retention time.Duration
+}
+
+func (s *RedisSessionStore) Touch(ctx context.Context, deviceId string, at time.Time, timeout time.Duration) (string, int64, error) {
+	result, err := touchSessionScript.Run(ctx, s.client,
+		[]string{s.prefix + deviceId},
+		at.UnixMilli(), timeout.Milliseconds(), uuid.New().String(), s.retention.Milliseconds(),
+	).Slice()
+	if err != nil {
+		return "", 0, err
+	}
+	if len(result) != 2 {
+		return "", 0, errors.New("unexpected session script result")
+	}
+	id, _ := result[0].(string)
+	idx, _ := result[1].(int64)
+	return id, idx, nil
+}
+
+func (s *RedisSessionStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mssola/user_agent"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const (
+	DEVICE_TYPE_BOT     string = "bot"
+	DEVICE_TYPE_MOBILE  string = "mobile"
+	DEVICE_TYPE_TABLET  string = "tablet"
+	DEVICE_TYPE_DESKTOP string = "desktop"
+)
+
+// Automated clients which the parser does not classify as bots on its own
+var botPattern = regexp.MustCompile(`(?i)(bot|crawl|spider|slurp|headless|lighthouse|curl/|wget/|python-requests|go-http-client|okhttp|java/|apache-httpclient|phantomjs)`)
+
+// Manufacturers keyed by a prefix of the parsed model or platform
+var manufacturers = []struct {
+	prefix       string
+	manufacturer string
+}{
+	{"iPhone", "Apple"},
+	{"iPad", "Apple"},
+	{"iPod", "Apple"},
+	{"Macintosh", "Apple"},
+	{"SM-", "Samsung"},
+	{"GT-", "Samsung"},
+	{"Pixel", "Google"},
+	{"Nexus", "Google"},
+	{"Moto", "Motorola"},
+	{"Redmi", "Xiaomi"},
+	{"Mi ", "Xiaomi"},
+	{"ONEPLUS", "OnePlus"},
+	{"HUAWEI", "Huawei"},
+	{"LG-", "LG"},
+}
+
+func manufacturer(model string) *string {
+	for _, m := range manufacturers {
+		if strings.HasPrefix(model, m.prefix) {
+			manufacturer := m.manufacturer
+			return &manufacturer
+		}
+	}
+	return nil
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// An enricher which parses `Device.Useragent` into the device's os,
+// browser, type, manufacturer, and model, and classifies bots.
+//
+// Fields which were already populated (such as those sent explicitly by
+// Snowplow trackers) are left as-is.
+type UseragentEnricher struct {
+	name               string
+	routeBotsToInvalid bool
+}
+
+func (e *UseragentEnricher) Name() string {
+	return e.name
+}
+
+func (e *UseragentEnricher) Type() string {
+	return USERAGENT
+}
+
+func (e *UseragentEnricher) Initialize(conf config.Enricher) error {
+	e.name, e.routeBotsToInvalid = conf.Name, conf.RouteBotsToInvalid
+	return nil
+}
+
+func deviceType(ua *user_agent.UserAgent, raw string, isBot bool) string {
+	switch {
+	case isBot:
+		return DEVICE_TYPE_BOT
+	case strings.Contains(raw, "iPad") || strings.Contains(raw, "Tablet") ||
+		(strings.Contains(raw, "Android") && !strings.Contains(raw, "Mobile")):
+		return DEVICE_TYPE_TABLET
+	case ua.Mobile():
+		return DEVICE_TYPE_MOBILE
+	default:
+		return DEVICE_TYPE_DESKTOP
+	}
+}
+
+func (e *UseragentEnricher) Enrich(env *envelope.Envelope) error {
+	raw := env.Device.Useragent
+	if raw == "" {
+		return nil
+	}
+	ua := user_agent.New(raw)
+	browserName, browserVersion := ua.Browser()
+	isBot := ua.Bot() || botPattern.MatchString(raw)
+
+	d := &env.Device
+	if d.Os == nil {
+		d.Os = &envelope.Os{}
+	}
+	osInfo := ua.OSInfo()
+	if d.Os.Name == nil {
+		d.Os.Name = nonEmpty(osInfo.Name)
+	}
+	if d.Os.Version == nil {
+		d.Os.Version = nonEmpty(osInfo.Version)
+	}
+	if d.Browser == nil {
+		d.Browser = &envelope.Browser{}
+	}
+	if d.Browser.Name == nil {
+		d.Browser.Name = nonEmpty(browserName)
+	}
+	if d.Browser.Version == nil {
+		d.Browser.Version = nonEmpty(browserVersion)
+	}
+	if d.Type == nil {
+		t := deviceType(ua, raw, isBot)
+		d.Type = &t
+	}
+	model := ua.Model()
+	if model == "" {
+		model = ua.Platform()
+	}
+	if d.Model == nil {
+		d.Model = nonEmpty(ua.Model())
+	}
+	if d.Manufacturer == nil {
+		d.Manufacturer = manufacturer(model)
+	}
+
+	if env.Enrichments == nil {
+		env.Enrichments = &envelope.Enrichments{}
+	}
+	env.Enrichments.Bot = &envelope.Bot{IsBot: isBot}
+	if isBot {
+		env.Enrichments.Bot.Name = nonEmpty(browserName)
+		if e.routeBotsToInvalid {
+			Invalidate(env, e, "detected bot: "+browserName)
+		}
+	}
+	return nil
+}
+
+func (e *UseragentEnricher) Close() {}
@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package enricher
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	iphoneUseragent    = "Mozilla/5.0 (iPhone; CPU iPhone OS 15_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.5 Mobile/15E148 Safari/604.1"
+	androidUseragent   = "Mozilla/5.0 (Linux; Android 12; SM-G991B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/103.0.0.0 Mobile Safari/537.36"
+	desktopUseragent   = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/103.0.0.0 Safari/537.36"
+	googlebotUseragent = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	curlUseragent      = "curl/7.79.1"
+)
+
+func enrichUseragent(t *testing.T, conf config.Enricher, useragent string) envelope.Envelope {
+	e := UseragentEnricher{}
+	assert.Nil(t, e.Initialize(conf))
+	valid := true
+	env := envelope.Envelope{
+		Device:     envelope.Device{Useragent: useragent},
+		Validation: envelope.Validation{IsValid: &valid},
+	}
+	assert.Nil(t, e.Enrich(&env))
+	return env
+}
+
+func TestUseragentEnricher(t *testing.T) {
+	iphone := enrichUseragent(t, config.Enricher{}, iphoneUseragent)
+	assert.Equal(t, "iPhone OS", *iphone.Device.Os.Name)
+	assert.Equal(t, "15.5", *iphone.Device.Os.Version)
+	assert.Equal(t, "Safari", *iphone.Device.Browser.Name)
+	assert.Equal(t, DEVICE_TYPE_MOBILE, *iphone.Device.Type)
+	assert.Equal(t, "Apple", *iphone.Device.Manufacturer)
+	assert.False(t, iphone.Enrichments.Bot.IsBot)
+
+	android := enrichUseragent(t, config.Enricher{}, androidUseragent)
+	assert.Equal(t, "Android", *android.Device.Os.Name)
+	assert.Equal(t, "Chrome", *android.Device.Browser.Name)
+	assert.Equal(t, "SM-G991B", *android.Device.Model)
+	assert.Equal(t, "Samsung", *android.Device.Manufacturer)
+
+	desktop := enrichUseragent(t, config.Enricher{}, desktopUseragent)
+	assert.Equal(t, DEVICE_TYPE_DESKTOP, *desktop.Device.Type)
+	assert.Nil(t, desktop.Device.Manufacturer)
+}
+
+func TestUseragentEnricherKeepsExplicitFields(t *testing.T) {
+	e := UseragentEnricher{}
+	name, timezone := "iOS", "Europe/London"
+	env := envelope.Envelope{Device: envelope.Device{Useragent: iphoneUseragent, Os: &envelope.Os{Name: &name, Timezone: &timezone}}}
+	assert.Nil(t, e.Enrich(&env))
+	assert.Equal(t, "iOS", *env.Device.Os.Name)
+	assert.Equal(t, "15.5", *env.Device.Os.Version)
+	assert.Equal(t, "Europe/London", *env.Device.Os.Timezone)
+}
+
+func TestUseragentEnricherBots(t *testing.T) {
+	for _, ua := range []string{googlebotUseragent, curlUseragent} {
+		bot := enrichUseragent(t, config.Enricher{}, ua)
+		assert.True(t, bot.Enrichments.Bot.IsBot, ua)
+		assert.Equal(t, DEVICE_TYPE_BOT, *bot.Device.Type)
+		assert.True(t, *bot.Validation.IsValid)
+	}
+	bot := enrichUseragent(t, config.Enricher{Name: "ua", RouteBotsToInvalid: true}, googlebotUseragent)
+	assert.Equal(t, "Googlebot", *bot.Enrichments.Bot.Name)
+	assert.False(t, *bot.Validation.IsValid)
+	assert.Equal(t, USERAGENT, bot.Validation.Error.Errors[0].ErrorType)
+
+	human := enrichUseragent(t, config.Enricher{RouteBotsToInvalid: true}, desktopUseragent)
+	assert.True(t, *human.Validation.IsValid)
+}
@@ -4,5 +4,51 @@
 
 package envelope
 
-// Reserving this for future use.
-type Annotations struct{}
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+)
+
+type Annotations struct {
+	DeadLetter *DeadLetter            `json:"deadLetter,omitempty"`
+	Pii        *Pii                   `json:"pii,omitempty"`
+	Consent    *Consent               `json:"consent,omitempty"`
+	Duplicate  *Duplicate             `json:"duplicate,omitempty"`
+	Custom     map[string]interface{} `json:"custom,omitempty"` // Set by script enrichers
+}
+
+func (a Annotations) Value() (driver.Value, error) {
+	b, err := json.Marshal(a)
+	return string(b), err
+}
+
+func (a Annotations) Scan(input interface{}) error {
+	return json.Unmarshal(input.([]byte), &a)
+}
+
+// Why an envelope was routed to a dead letter sink.
+type DeadLetter struct {
+	Sink     string    `json:"sink"`
+	SinkType string    `json:"sinkType"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Tstamp   time.Time `json:"tstamp"`
+}
+
+type Pii struct {
+	KeyId           string            `json:"keyId,omitempty"`           // The key used to hash or tokenize fields
+	EncryptionKeyId string            `json:"encryptionKeyId,omitempty"` // The keyring key used to encrypt fields
+	Actions         map[string]string `json:"actions"`                   // Actions applied, keyed by path
+}
+
+type Consent struct {
+	Decision   string   `json:"decision"`         // keep, strip, or drop
+	Source     string   `json:"source,omitempty"` // Where consent was read from, if present
+	Categories []string `json:"categories"`       // Granted categories
+}
+
+// Set when an envelope's event id was already seen within the dedup window.
+type Duplicate struct {
+	EventId string `json:"eventId"`
+}
@@ -9,7 +9,10 @@ import (
 	"github.com/silverton-io/buz/pkg/util"
 )
 
-const HTTP_HEADERS_CONTEXT string = "io.silverton/buz/internal/contexts/httpHeaders/v1.0.json"
+const (
+	HTTP_HEADERS_CONTEXT string = "io.silverton/buz/internal/contexts/httpHeaders/v1.0.json"
+	TRACE_CONTEXT        string = "io.silverton/buz/internal/contexts/trace/v1.0.json"
+)
 
 func BuildContextsFromRequest(c *gin.Context) map[string]interface{} {
 	headers := util.HttpHeadersToMap(c)
@@ -42,6 +42,8 @@ func (d Device) Scan(input interface{}) error {
 }
 
 type Browser struct {
+	Name           *string `json:"name,omitempty"`
+	Version        *string `json:"version,omitempty"`
 	Lang           *string `json:"language,omitempty"`
 	Cookies        *bool   `json:"cookies,omitempty"`
 	ColorDepth     *int64  `json:"colorDepth,omitempty"`
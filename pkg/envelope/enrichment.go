@@ -4,5 +4,33 @@
 
 package envelope
 
-// Reserving this for future use.
-type Enrichments struct{}
+import (
+	"database/sql/driver"
+	"encoding/json"
+)
+
+type Enrichments struct {
+	Bot     *Bot                   `json:"bot,omitempty"`     // Set by useragent enrichers
+	ClickId *ClickId               `json:"clickId,omitempty"` // Set by campaign enrichers
+	Custom  map[string]interface{} `json:"custom,omitempty"`  // Set by script enrichers
+}
+
+func (e Enrichments) Value() (driver.Value, error) {
+	b, err := json.Marshal(e)
+	return string(b), err
+}
+
+func (e Enrichments) Scan(input interface{}) error {
+	return json.Unmarshal(input.([]byte), &e)
+}
+
+type Bot struct {
+	IsBot bool    `json:"isBot"`
+	Name  *string `json:"name,omitempty"`
+}
+
+type ClickId struct {
+	Name    string `json:"name"`
+	Id      string `json:"id"`
+	Network string `json:"network"`
+}
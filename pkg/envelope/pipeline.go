@@ -27,6 +27,7 @@ func (p Pipeline) Scan(input interface{}) error {
 type Source struct {
 	GeneratedTstamp *time.Time `json:"generatedTstamp,omitempty"`
 	SentTstamp      *time.Time `json:"sentTstamp,omitempty"`
+	EventId         *string    `json:"eventId,omitempty"` // Client-provided event id, such as Snowplow `eid` or Cloudevents `id`
 	Name            *string    `json:"name,omitempty"`
 	Version         *string    `json:"version,omitempty"`
 }
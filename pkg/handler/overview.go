@@ -16,6 +16,7 @@ type systemPaths struct {
 	Stats          string `json:"stats"`
 	RouteOverview  string `json:"routeOverview"`
 	ConfigOverview string `json:"configOverview"`
+	Metrics        string `json:"metrics"`
 }
 
 type snowplowPaths struct {
@@ -55,6 +56,7 @@ func RouteOverviewHandler(conf config.Config) gin.HandlerFunc {
 				Stats:          constants.STATS_PATH,
 				RouteOverview:  constants.ROUTE_OVERVIEW_PATH,
 				ConfigOverview: constants.CONFIG_OVERVIEW_PATH,
+				Metrics:        constants.METRICS_PATH,
 			},
 			inputPaths{
 				Snowplow:       sp,
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/silverton-io/buz/pkg/annotator"
+	"github.com/silverton-io/buz/pkg/enricher"
 	"github.com/silverton-io/buz/pkg/envelope"
 	cloudevents "github.com/silverton-io/buz/pkg/inputCloudevents"
 	pixel "github.com/silverton-io/buz/pkg/inputPixel"
@@ -34,8 +35,10 @@ func SquawkboxHandler(h params.Handler, eventProtocol string) gin.HandlerFunc {
 		case protocol.WEBHOOK:
 			envelopes = webhook.BuildEnvelopesFromRequest(c, h.Config, h.CollectorMeta)
 		}
-		annotatedEnvelopes := annotator.Annotate(envelopes, h.Registry)
-		c.JSON(http.StatusOK, annotatedEnvelopes)
+		annotatedEnvelopes := annotator.Annotate(c.Request.Context(), envelopes, h.Registry)
+		transformedEnvelopes := h.Transformer.Transform(annotatedEnvelopes)
+		enrichedEnvelopes := enricher.Enrich(transformedEnvelopes, h.Enrichers)
+		c.JSON(http.StatusOK, enrichedEnvelopes)
 	}
 	return gin.HandlerFunc(fn)
 }
@@ -5,21 +5,54 @@
 package handler
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/response"
 	"github.com/silverton-io/buz/pkg/stats"
 )
 
+const (
+	STATS_VIEW_PARAM      string = "view"
+	STATS_NAMESPACE_PARAM string = "namespace"
+	CLUSTER_VIEW          string = "cluster"
+)
+
 type StatsResponse struct {
 	CollectorMeta *meta.CollectorMeta  `json:"collectorMeta"`
 	Stats         *stats.ProtocolStats `json:"stats"`
+	SinkStats     *stats.SinkStats     `json:"sinks"`
 }
 
-func StatsHandler(m *meta.CollectorMeta, s *stats.ProtocolStats) gin.HandlerFunc {
+type ClusterStatsResponse struct {
+	CollectorMeta *meta.CollectorMeta `json:"collectorMeta"`
+	stats.ClusterStats
+}
+
+// Stats for this instance, or with `?view=cluster` stats merged across every
+// instance along with per-minute buckets, optionally filtered by `?namespace=`.
+func StatsHandler(m *meta.CollectorMeta, s *stats.ProtocolStats, ss *stats.SinkStats, p *stats.Persister) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
+		if c.Query(STATS_VIEW_PARAM) == CLUSTER_VIEW {
+			if p == nil {
+				c.JSON(http.StatusNotFound, response.ClusterStatsUnavailable)
+				return
+			}
+			cluster, err := p.Cluster(c.Query(STATS_NAMESPACE_PARAM))
+			if err != nil {
+				log.Error().Err(err).Msg("🔴 could not load cluster stats")
+				c.JSON(http.StatusServiceUnavailable, response.ClusterStatsUnavailable)
+				return
+			}
+			c.JSON(http.StatusOK, ClusterStatsResponse{CollectorMeta: m, ClusterStats: cluster})
+			return
+		}
 		resp := StatsResponse{
 			CollectorMeta: m,
 			Stats:         s,
+			SinkStats:     ss,
 		}
 		c.JSON(200, resp)
 	}
@@ -31,10 +31,12 @@ func TestStatsHandler(t *testing.T) {
 	}
 	s := stats.ProtocolStats{}
 	s.Build()
+	ss := stats.BuildSinkStats()
+	ss.Update("primary", func(st *stats.SinkStat) { st.Attempts++ })
 	rec := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(rec)
 
-	handler := StatsHandler(&m, &s)
+	handler := StatsHandler(&m, &s, ss, nil)
 
 	handler(c)
 
@@ -50,6 +52,7 @@ func TestStatsHandler(t *testing.T) {
 	expectedResponse := StatsResponse{
 		CollectorMeta: &m,
 		Stats:         &s,
+		SinkStats:     ss,
 	}
 	expected, err := json.Marshal(expectedResponse)
 	if err != nil {
@@ -60,3 +63,15 @@ func TestStatsHandler(t *testing.T) {
 		t.Fatalf(`StatsHandler returned %v, want %v`, b, expected)
 	}
 }
+
+func TestClusterStatsHandlerWithoutPersistence(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/stats?view=cluster", nil)
+
+	StatsHandler(&meta.CollectorMeta{}, stats.BuildProtocolStats(), stats.BuildSinkStats(), nil)(c)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf(`StatsHandler returned %d, want %d`, rec.Code, http.StatusNotFound)
+	}
+}
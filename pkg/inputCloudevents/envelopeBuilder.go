@@ -37,6 +37,10 @@ func BuildEnvelopesFromRequest(c *gin.Context, conf *config.Config, m *meta.Coll
 			n.Pipeline.Source.GeneratedTstamp = cEvent.Time
 			n.Pipeline.Source.SentTstamp = cEvent.Time
 		}
+		if cEvent.Id != "" {
+			id := cEvent.Id
+			n.Pipeline.Source.EventId = &id
+		}
 		// Payload
 		n.Payload = cEvent.Data
 		envelopes = append(envelopes, n)
@@ -8,22 +8,17 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/silverton-io/buz/pkg/annotator"
 	"github.com/silverton-io/buz/pkg/params"
-	"github.com/silverton-io/buz/pkg/privacy"
+	"github.com/silverton-io/buz/pkg/pipeline"
 	"github.com/silverton-io/buz/pkg/response"
 )
 
 func Handler(h params.Handler) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		if c.ContentType() == "application/json" {
-			envelopes := BuildEnvelopesFromRequest(c, h.Config, h.CollectorMeta)
-			annotatedEnvelopes := annotator.Annotate(envelopes, h.Registry)
-			anonymizedEnvelopes := privacy.AnonymizeEnvelopes(annotatedEnvelopes, h.Config.Privacy)
-			err := h.Manifold.Distribute(anonymizedEnvelopes, h.ProtocolStats)
+			err := pipeline.Process(c, h, BuildEnvelopesFromRequest)
 			if err != nil {
-				c.Header("Retry-After", response.RETRY_AFTER_60)
-				c.JSON(http.StatusServiceUnavailable, response.ManifoldDistributionError)
+				pipeline.RespondDistributionError(c, err)
 			} else {
 				c.JSON(200, response.Ok)
 			}
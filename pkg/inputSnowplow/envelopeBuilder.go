@@ -25,6 +25,7 @@ func buildSnowplowEnvelope(c *gin.Context, conf *config.Config, e SnowplowEvent,
 	// Pipeline
 	n.Pipeline.Source.GeneratedTstamp = e.DvceCreatedTstamp
 	n.Pipeline.Source.SentTstamp = e.DvceSentTstamp
+	n.Pipeline.Source.EventId = e.EventId
 	// Device
 	if e.DomainUserid != nil {
 		n.Device.Id = *e.DomainUserid
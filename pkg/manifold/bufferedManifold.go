@@ -0,0 +1,255 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/router"
+	"github.com/silverton-io/buz/pkg/sink"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+const (
+	DEFAULT_BUFFER_MAX_SIZE          int = 10000
+	DEFAULT_BUFFER_BATCH_SIZE        int = 500
+	DEFAULT_BUFFER_FLUSH_INTERVAL_MS int = 1000
+)
+
+var (
+	ErrQueueFull = errors.New("sink queue is full")
+	// A batch routed to a sink is larger than the sink's queue can ever hold,
+	// so retrying it will never succeed.
+	ErrBatchTooLarge = errors.New("batch exceeds sink queue max size")
+)
+
+// A bounded in-memory queue in front of a single sink.
+// Envelopes are flushed to the sink whenever the queue reaches
+// the configured batch size, or when the flush interval elapses.
+type sinkQueue struct {
+	mu            sync.Mutex
+	sink          sink.Sink
+	envelopes     []envelope.Envelope
+	maxSize       int
+	batchSize     int
+	flushInterval time.Duration
+	flush         chan struct{}
+	shutdown      chan struct{}
+	done          chan struct{}
+}
+
+func (q *sinkQueue) available() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.maxSize - len(q.envelopes)
+}
+
+func (q *sinkQueue) enqueue(envelopes []envelope.Envelope) {
+	q.mu.Lock()
+	q.envelopes = append(q.envelopes, envelopes...)
+	full := len(q.envelopes) >= q.batchSize
+	q.mu.Unlock()
+	if full {
+		select {
+		case q.flush <- struct{}{}:
+		default: // A flush is already pending
+		}
+	}
+}
+
+// Take up to one batch of envelopes off the front of the queue.
+func (q *sinkQueue) take() []envelope.Envelope {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.envelopes)
+	if n > q.batchSize {
+		n = q.batchSize
+	}
+	batch := make([]envelope.Envelope, n)
+	copy(batch, q.envelopes[:n])
+	q.envelopes = q.envelopes[n:]
+	return batch
+}
+
+func (q *sinkQueue) publish(batch []envelope.Envelope) {
+	var validEnvelopes []envelope.Envelope
+	var invalidEnvelopes []envelope.Envelope
+	for _, e := range batch {
+		if *e.Validation.IsValid {
+			validEnvelopes = append(validEnvelopes, e)
+		} else {
+			invalidEnvelopes = append(invalidEnvelopes, e)
+		}
+	}
+	s := q.sink
+	ctx := context.Background()
+	if len(validEnvelopes) > 0 {
+		log.Debug().Interface("sinkId", s.Id()).Interface("sinkName", s.Name()).Interface("sinkType", s.Type()).Int("count", len(validEnvelopes)).Msg("🟡 flushing valid envelopes to sink")
+		if err := s.BatchPublishValid(ctx, validEnvelopes); err != nil {
+			log.Error().Err(err).Interface("sinkId", s.Id()).Interface("sinkName", s.Name()).Interface("deliveryRequired", s.DeliveryRequired()).Interface("sinkType", s.Type()).Msg("🔴 could not flush valid envelopes to sink")
+		}
+	}
+	if len(invalidEnvelopes) > 0 {
+		log.Debug().Interface("sinkId", s.Id()).Interface("sinkName", s.Name()).Interface("sinkType", s.Type()).Int("count", len(invalidEnvelopes)).Msg("🟡 flushing invalid envelopes to sink")
+		if err := s.BatchPublishInvalid(ctx, invalidEnvelopes); err != nil {
+			log.Error().Err(err).Interface("sinkId", s.Id()).Interface("sinkName", s.Name()).Interface("deliveryRequired", s.DeliveryRequired()).Interface("sinkType", s.Type()).Msg("🔴 could not flush invalid envelopes to sink")
+		}
+	}
+}
+
+// Publish everything currently queued, one batch at a time.
+func (q *sinkQueue) drain() {
+	for {
+		batch := q.take()
+		if len(batch) == 0 {
+			return
+		}
+		q.publish(batch)
+	}
+}
+
+func (q *sinkQueue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.flush:
+			q.drain()
+		case <-ticker.C:
+			q.drain()
+		case <-q.shutdown:
+			q.drain()
+			return
+		}
+	}
+}
+
+// A manifold which buffers envelopes in a bounded queue per sink
+// and flushes them asynchronously.
+// Requests are acknowledged as soon as envelopes are queued, so a slow
+// sink no longer adds latency to every request. Backpressure is only
+// applied (via ErrQueueFull) when a sink's queue cannot hold the incoming envelopes.
+// Batches larger than a queue's max size are rejected outright (via ErrBatchTooLarge).
+//
+// NOTE! Since envelopes are acknowledged before they reach the sink,
+// `deliveryRequired` cannot fail the request when using this manifold.
+type BufferedManifold struct {
+	mu       sync.Mutex
+	queues   []*sinkQueue
+	routers  map[string]*router.Router
+	samplers map[string]*router.Sampler
+}
+
+func (m *BufferedManifold) Initialize(sinks *[]sink.Sink, conf *config.Config) error {
+	maxSize, batchSize, flushIntervalMs := conf.Manifold.Buffer.MaxSize, conf.Manifold.Buffer.BatchSize, conf.Manifold.Buffer.FlushIntervalMs
+	if maxSize <= 0 {
+		maxSize = DEFAULT_BUFFER_MAX_SIZE
+	}
+	if batchSize <= 0 {
+		batchSize = DEFAULT_BUFFER_BATCH_SIZE
+	}
+	if flushIntervalMs <= 0 {
+		flushIntervalMs = DEFAULT_BUFFER_FLUSH_INTERVAL_MS
+	}
+	if batchSize > maxSize {
+		return errors.New("buffer batchSize cannot exceed buffer maxSize")
+	}
+	routers, err := router.BuildRouters(conf.Sinks)
+	if err != nil {
+		return err
+	}
+	samplers, err := router.BuildSamplers(conf.Sinks)
+	if err != nil {
+		return err
+	}
+	m.routers, m.samplers = routers, samplers
+	for _, s := range distributionSinks(*sinks, conf) {
+		q := &sinkQueue{
+			sink:          s,
+			maxSize:       maxSize,
+			batchSize:     batchSize,
+			flushInterval: time.Duration(flushIntervalMs) * time.Millisecond,
+			flush:         make(chan struct{}, 1),
+			shutdown:      make(chan struct{}),
+			done:          make(chan struct{}),
+		}
+		go q.run()
+		m.queues = append(m.queues, q)
+	}
+	return nil
+}
+
+func (m *BufferedManifold) Distribute(envelopes []envelope.Envelope, s *stats.ProtocolStats) error {
+	if len(envelopes) == 0 {
+		return nil
+	}
+	// Envelopes are queued for every sink or for none of them,
+	// so a retried request never duplicates envelopes in a subset of sinks.
+	routed := make([][]envelope.Envelope, len(m.queues))
+	for i, q := range m.queues {
+		routed[i] = route(m.routers, q.sink, envelopes)
+	}
+	// Samplers are consulted under the lock, and rate cap tokens are only
+	// taken once the envelopes are queued, so rejected batches don't consume them.
+	m.mu.Lock()
+	sampled := make([][]envelope.Envelope, len(m.queues))
+	sampledOut := make([][]envelope.Envelope, len(m.queues))
+	reservations := make([]*router.Reservation, len(m.queues))
+	for i, q := range m.queues {
+		sampled[i], sampledOut[i], reservations[i] = reserve(m.samplers, q.sink, routed[i])
+	}
+	for i, q := range m.queues {
+		if len(sampled[i]) > q.maxSize {
+			m.mu.Unlock()
+			log.Warn().Interface("sinkName", q.sink.Name()).Interface("sinkType", q.sink.Type()).Int("count", len(sampled[i])).Msg("🟡 batch exceeds sink queue max size - rejecting")
+			return ErrBatchTooLarge
+		}
+	}
+	for i, q := range m.queues {
+		if q.available() < len(sampled[i]) {
+			m.mu.Unlock()
+			log.Warn().Interface("sinkName", q.sink.Name()).Interface("sinkType", q.sink.Type()).Msg("🟡 sink queue is full - applying backpressure")
+			return ErrQueueFull
+		}
+	}
+	for i, q := range m.queues {
+		if len(sampled[i]) > 0 {
+			q.enqueue(sampled[i])
+		}
+		reservations[i].Commit()
+	}
+	m.mu.Unlock()
+
+	for _, e := range envelopes {
+		if *e.Validation.IsValid {
+			s.IncrementValid(&e.EventMeta, 1)
+		} else {
+			s.IncrementInvalid(&e.EventMeta, 1)
+		}
+	}
+	for _, dropped := range sampledOut {
+		countSampledOut(dropped, s)
+	}
+	return nil
+}
+
+// Flush all queued envelopes and stop the flushers.
+func (m *BufferedManifold) Shutdown() error {
+	log.Debug().Msg("🟡 shutting down buffered manifold - flushing queues")
+	for _, q := range m.queues {
+		close(q.shutdown)
+	}
+	for _, q := range m.queues {
+		<-q.done
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/silverton-io/buz/pkg/sink"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	valid   []envelope.Envelope
+	invalid []envelope.Envelope
+	batches int
+}
+
+func (s *recordingSink) Id() *uuid.UUID {
+	id := uuid.New()
+	return &id
+}
+
+func (s *recordingSink) Name() string {
+	return "recorder"
+}
+
+func (s *recordingSink) Type() string {
+	return "recorder"
+}
+
+func (s *recordingSink) DeliveryRequired() bool {
+	return false
+}
+
+func (s *recordingSink) Initialize(conf config.Sink) error {
+	return nil
+}
+
+func (s *recordingSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.valid = append(s.valid, envelopes...)
+	s.batches++
+	return nil
+}
+
+func (s *recordingSink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invalid = append(s.invalid, envelopes...)
+	s.batches++
+	return nil
+}
+
+func (s *recordingSink) Close() {}
+
+func (s *recordingSink) counts() (valid int, invalid int, batches int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.valid), len(s.invalid), s.batches
+}
+
+func buildTestEnvelopes(n int, isValid bool) []envelope.Envelope {
+	var envelopes []envelope.Envelope
+	for i := 0; i < n; i++ {
+		v := isValid
+		envelopes = append(envelopes, envelope.Envelope{
+			EventMeta:  envelope.EventMeta{Protocol: protocol.PIXEL, Namespace: "test"},
+			Validation: envelope.Validation{IsValid: &v},
+		})
+	}
+	return envelopes
+}
+
+func buildTestBufferedManifold(t *testing.T, s sink.Sink, buffer config.Buffer) *BufferedManifold {
+	conf := config.Config{Manifold: config.Manifold{Type: BUFFERED, Buffer: buffer}}
+	m := BufferedManifold{}
+	sinks := []sink.Sink{s}
+	err := m.Initialize(&sinks, &conf)
+	assert.Nil(t, err)
+	return &m
+}
+
+func TestBuildManifold(t *testing.T) {
+	m, err := BuildManifold(config.Manifold{Type: BUFFERED})
+	assert.Nil(t, err)
+	assert.IsType(t, &BufferedManifold{}, m)
+
+	m, err = BuildManifold(config.Manifold{})
+	assert.Nil(t, err)
+	assert.IsType(t, &SimpleManifold{}, m)
+
+	_, err = BuildManifold(config.Manifold{Type: "unsupported"})
+	assert.NotNil(t, err)
+}
+
+func TestBufferedManifoldFlushesOnBatchSize(t *testing.T) {
+	s := recordingSink{}
+	m := buildTestBufferedManifold(t, &s, config.Buffer{MaxSize: 100, BatchSize: 5, FlushIntervalMs: 60000})
+	ps := stats.BuildProtocolStats()
+
+	err := m.Distribute(buildTestEnvelopes(5, true), ps)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		valid, _, _ := s.counts()
+		return valid == 5
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(5), ps.Valid[protocol.PIXEL]["test"])
+	assert.Nil(t, m.Shutdown())
+}
+
+func TestBufferedManifoldFlushesOnInterval(t *testing.T) {
+	s := recordingSink{}
+	m := buildTestBufferedManifold(t, &s, config.Buffer{MaxSize: 100, BatchSize: 50, FlushIntervalMs: 10})
+
+	err := m.Distribute(append(buildTestEnvelopes(2, true), buildTestEnvelopes(1, false)...), stats.BuildProtocolStats())
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		valid, invalid, _ := s.counts()
+		return valid == 2 && invalid == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Nil(t, m.Shutdown())
+}
+
+func TestBufferedManifoldBackpressure(t *testing.T) {
+	s := recordingSink{}
+	m := buildTestBufferedManifold(t, &s, config.Buffer{MaxSize: 10, BatchSize: 10, FlushIntervalMs: 60000})
+	ps := stats.BuildProtocolStats()
+
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(8, true), ps))
+	err := m.Distribute(buildTestEnvelopes(3, true), ps)
+	assert.ErrorIs(t, err, ErrQueueFull)
+	assert.Equal(t, int64(8), ps.Valid[protocol.PIXEL]["test"])
+
+	assert.Nil(t, m.Shutdown())
+	valid, _, _ := s.counts()
+	assert.Equal(t, 8, valid)
+}
+
+func TestBufferedManifoldBatchTooLarge(t *testing.T) {
+	s := recordingSink{}
+	m := buildTestBufferedManifold(t, &s, config.Buffer{MaxSize: 10, BatchSize: 10, FlushIntervalMs: 60000})
+	ps := stats.BuildProtocolStats()
+
+	// An empty queue still cannot hold it, so it is not backpressure
+	err := m.Distribute(buildTestEnvelopes(11, true), ps)
+	assert.ErrorIs(t, err, ErrBatchTooLarge)
+	assert.NotErrorIs(t, err, ErrQueueFull)
+	assert.Equal(t, int64(0), ps.Valid[protocol.PIXEL]["test"])
+	assert.Nil(t, m.Shutdown())
+	valid, _, _ := s.counts()
+	assert.Equal(t, 0, valid)
+}
+
+func TestBufferedManifoldRateCapsOnlyConsumedWhenQueued(t *testing.T) {
+	s := recordingSink{}
+	conf := config.Config{
+		Manifold: config.Manifold{Type: BUFFERED, Buffer: config.Buffer{MaxSize: 10, BatchSize: 10, FlushIntervalMs: 60000}},
+		Sinks:    []config.Sink{{Name: s.Name(), RateCaps: []config.RateCap{{Namespace: "capped", EventsPerSecond: 10}}}},
+	}
+	m := BufferedManifold{}
+	sinks := []sink.Sink{&s}
+	assert.Nil(t, m.Initialize(&sinks, &conf))
+	defer m.Shutdown()
+	ps := stats.BuildProtocolStats()
+	capped := func(n int) []envelope.Envelope {
+		envs := buildTestEnvelopes(n, true)
+		for i := range envs {
+			envs[i].Namespace = "capped"
+		}
+		return envs
+	}
+
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(8, true), ps))
+	assert.ErrorIs(t, m.Distribute(capped(5), ps), ErrQueueFull)
+	// The rejected batch took none of the cap's tokens
+	kept, _ := m.samplers[s.Name()].Sample(capped(10))
+	assert.Len(t, kept, 10)
+}
+
+func TestBufferedManifoldShutdownDrains(t *testing.T) {
+	s := recordingSink{}
+	m := buildTestBufferedManifold(t, &s, config.Buffer{MaxSize: 100, BatchSize: 4, FlushIntervalMs: 60000})
+
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(3, true), stats.BuildProtocolStats()))
+	assert.Nil(t, m.Shutdown())
+	valid, _, batches := s.counts()
+	assert.Equal(t, 3, valid)
+	assert.Equal(t, 1, batches)
+}
+
+func TestBufferedManifoldBatchSizeExceedsMaxSize(t *testing.T) {
+	conf := config.Config{Manifold: config.Manifold{Type: BUFFERED, Buffer: config.Buffer{MaxSize: 5, BatchSize: 10}}}
+	m := BufferedManifold{}
+	sinks := []sink.Sink{&recordingSink{}}
+	assert.NotNil(t, m.Initialize(&sinks, &conf))
+}
@@ -5,12 +5,127 @@
 package manifold
 
 import (
+	"errors"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/router"
 	"github.com/silverton-io/buz/pkg/sink"
 	"github.com/silverton-io/buz/pkg/stats"
 )
 
+const (
+	SIMPLE   string = "simple"
+	BUFFERED string = "buffered"
+)
+
 type Manifold interface {
-	Initialize(sinks *[]sink.Sink) error
+	Initialize(sinks *[]sink.Sink, conf *config.Config) error
 	Distribute(e []envelope.Envelope, s *stats.ProtocolStats) error
+	Shutdown() error
+}
+
+func BuildManifold(conf config.Manifold) (manifold Manifold, err error) {
+	switch conf.Type {
+	case SIMPLE, "":
+		manifold = &SimpleManifold{}
+	case BUFFERED:
+		manifold = &BufferedManifold{}
+	default:
+		e := errors.New("unsupported manifold: " + conf.Type)
+		log.Error().Stack().Err(e).Msg("🔴 unsupported manifold")
+		return nil, e
+	}
+	if conf.Wal.Enabled {
+		if conf.Type == BUFFERED {
+			// The buffered manifold accepts envelopes before they reach sinks,
+			// so replayed envelopes would be checkpointed while only in memory.
+			e := errors.New("the wal cannot be used with the buffered manifold")
+			log.Error().Err(e).Msg("🔴 unsupported manifold")
+			return nil, e
+		}
+		// Durably log envelopes before handing them to the configured manifold
+		return &WalManifold{manifold: manifold}, nil
+	}
+	return manifold, nil
+}
+
+func BuildAndInitializeManifold(sinks *[]sink.Sink, conf *config.Config) (Manifold, error) {
+	manifold, err := BuildManifold(conf.Manifold)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not build manifold")
+		return nil, err
+	}
+	if (conf.Manifold.Type == SIMPLE || conf.Manifold.Type == "") && !conf.Manifold.Wal.Enabled {
+		for _, sConf := range conf.Sinks {
+			if sConf.Retry.Enabled {
+				log.Warn().Interface("sinkName", sConf.Name).Msg("🟡 sink retries run within client requests when using the simple manifold - use the buffered manifold instead")
+			}
+		}
+	}
+	err = manifold.Initialize(sinks, conf)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not initialize manifold")
+		return nil, err
+	}
+	log.Info().Msg("🟢 " + conf.Manifold.Type + " manifold initialized")
+	return manifold, nil
+}
+
+// The sinks which routed envelopes are distributed to.
+// Dead letter targets (of a sink or of the wal) only receive batches
+// which could not be published elsewhere, never normal traffic.
+func distributionSinks(sinks []sink.Sink, conf *config.Config) []sink.Sink {
+	targets := make(map[string]struct{})
+	for _, sConf := range conf.Sinks {
+		if sConf.DeadLetter != "" {
+			targets[sConf.DeadLetter] = struct{}{}
+		}
+	}
+	if conf.Manifold.Wal.DeadLetter != "" {
+		targets[conf.Manifold.Wal.DeadLetter] = struct{}{}
+	}
+	var distributed []sink.Sink
+	for _, s := range sinks {
+		if _, ok := targets[s.Name()]; ok {
+			log.Debug().Interface("sinkName", s.Name()).Msg("🟡 sink is a dead letter target - not distributing envelopes to it")
+			continue
+		}
+		distributed = append(distributed, s)
+	}
+	return distributed
+}
+
+// Route envelopes using the sink's router.
+// Sinks without a configured router receive every envelope.
+func route(routers map[string]*router.Router, s sink.Sink, envelopes []envelope.Envelope) []envelope.Envelope {
+	if r, ok := routers[s.Name()]; ok {
+		return r.Route(envelopes)
+	}
+	return envelopes
+}
+
+// Sample envelopes using the sink's sampler.
+// Returns the envelopes to publish and those which were sampled out.
+func sample(samplers map[string]*router.Sampler, s sink.Sink, envelopes []envelope.Envelope) (kept []envelope.Envelope, dropped []envelope.Envelope) {
+	if sampler, ok := samplers[s.Name()]; ok {
+		return sampler.Sample(envelopes)
+	}
+	return envelopes, nil
+}
+
+// Sample envelopes using the sink's sampler without taking rate cap tokens.
+// The returned reservation (if any) must be committed once the envelopes are accepted.
+func reserve(samplers map[string]*router.Sampler, s sink.Sink, envelopes []envelope.Envelope) (kept []envelope.Envelope, dropped []envelope.Envelope, r *router.Reservation) {
+	if sampler, ok := samplers[s.Name()]; ok {
+		return sampler.Reserve(envelopes)
+	}
+	return envelopes, nil, nil
+}
+
+func countSampledOut(dropped []envelope.Envelope, s *stats.ProtocolStats) {
+	for _, e := range dropped {
+		s.IncrementSampledOut(&e.EventMeta, 1)
+	}
 }
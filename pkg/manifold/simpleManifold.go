@@ -8,7 +8,9 @@ import (
 	"context"
 
 	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/router"
 	"github.com/silverton-io/buz/pkg/sink"
 	"github.com/silverton-io/buz/pkg/stats"
 )
@@ -17,11 +19,22 @@ import (
 // This manifold requires buffering at the client level for substantial event volumes.
 // Otherwise there is a change it will overload the configured sink(s).
 type SimpleManifold struct {
-	sinks *[]sink.Sink
+	sinks    *[]sink.Sink
+	routers  map[string]*router.Router
+	samplers map[string]*router.Sampler
 }
 
-func (m *SimpleManifold) Initialize(sinks *[]sink.Sink) error {
-	m.sinks = sinks
+func (m *SimpleManifold) Initialize(sinks *[]sink.Sink, conf *config.Config) error {
+	routers, err := router.BuildRouters(conf.Sinks)
+	if err != nil {
+		return err
+	}
+	samplers, err := router.BuildSamplers(conf.Sinks)
+	if err != nil {
+		return err
+	}
+	distributed := distributionSinks(*sinks, conf)
+	m.sinks, m.routers, m.samplers = &distributed, routers, samplers
 	return nil
 }
 
@@ -40,8 +53,13 @@ func (m *SimpleManifold) Distribute(envelopes []envelope.Envelope, s *stats.Prot
 		}
 	}
 
+	ps := s
 	for _, s := range *m.sinks {
 		ctx := context.Background()
+		validEnvelopes, sampledValid := sample(m.samplers, s, route(m.routers, s, validEnvelopes))
+		invalidEnvelopes, sampledInvalid := sample(m.samplers, s, route(m.routers, s, invalidEnvelopes))
+		countSampledOut(sampledValid, ps)
+		countSampledOut(sampledInvalid, ps)
 		if len(validEnvelopes) > 0 {
 			log.Debug().Interface("sinkId", s.Id()).Interface("sinkName", s.Name()).Interface("deliveryRequired", s.DeliveryRequired()).Interface("sinkType", s.Type()).Msg("🟡 purging valid envelopes to sink")
 			publishErr := s.BatchPublishValid(ctx, validEnvelopes)
@@ -65,3 +83,8 @@ func (m *SimpleManifold) Distribute(envelopes []envelope.Envelope, s *stats.Prot
 	}
 	return nil
 }
+
+func (m *SimpleManifold) Shutdown() error {
+	log.Debug().Msg("🟡 shutting down simple manifold")
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/silverton-io/buz/pkg/sink"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimpleManifoldRoutes(t *testing.T) {
+	s := recordingSink{}
+	conf := config.Config{
+		Sinks: []config.Sink{{
+			Name:    s.Name(),
+			Routing: config.Routing{Exclude: []config.Route{{Protocol: protocol.PIXEL}}},
+		}},
+	}
+	sinks := []sink.Sink{&s}
+	m := SimpleManifold{}
+	assert.Nil(t, m.Initialize(&sinks, &conf))
+
+	ps := stats.BuildProtocolStats()
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(2, true), ps))
+	valid, _, _ := s.counts()
+	assert.Equal(t, 0, valid)
+	// Routed-away envelopes are still counted
+	assert.Equal(t, int64(2), ps.Valid[protocol.PIXEL]["test"])
+}
+
+func TestSimpleManifoldCountsSampledOut(t *testing.T) {
+	s := recordingSink{}
+	conf := config.Config{
+		Sinks: []config.Sink{{
+			Name:     s.Name(),
+			Sampling: config.Sampling{Enabled: true, Rate: 0},
+		}},
+	}
+	sinks := []sink.Sink{&s}
+	m := SimpleManifold{}
+	assert.Nil(t, m.Initialize(&sinks, &conf))
+
+	ps := stats.BuildProtocolStats()
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(3, true), ps))
+	valid, _, _ := s.counts()
+	assert.Equal(t, 0, valid)
+	assert.Equal(t, int64(3), ps.SampledOut[protocol.PIXEL]["test"])
+}
+
+func TestSimpleManifoldSkipsDeadLetterTargets(t *testing.T) {
+	s, target := rejectingSink{}, recordingSink{}
+	conf := config.Config{
+		Sinks: []config.Sink{{Name: s.Name(), DeadLetter: target.Name()}, {Name: target.Name()}},
+	}
+	sinks := []sink.Sink{sink.NewDeadLetterSink(&s, &target), &target}
+	m := SimpleManifold{}
+	assert.Nil(t, m.Initialize(&sinks, &conf))
+
+	// Only the failed batch reaches the target, not another routed copy of it
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(2, true), stats.BuildProtocolStats()))
+	valid, _, batches := target.counts()
+	assert.Equal(t, 2, valid)
+	assert.Equal(t, 1, batches)
+	assert.NotNil(t, target.valid[0].Annotations.DeadLetter)
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/sink"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/silverton-io/buz/pkg/wal"
+)
+
+const (
+	WAL_REPLAY_POLL_INTERVAL   = 1 * time.Second
+	WAL_CHECKPOINT_INTERVAL    = 1 * time.Second
+	WAL_REPLAY_INITIAL_BACKOFF = 100 * time.Millisecond
+	WAL_REPLAY_MAX_BACKOFF     = 30 * time.Second
+	WAL_DEAD_LETTER_TIMEOUT    = 30 * time.Second
+
+	DEFAULT_WAL_MAX_REPLAY_ATTEMPTS int    = 10
+	WAL                             string = "wal"
+)
+
+// A manifold which durably appends envelopes to an on-disk write-ahead log
+// before acknowledging them. A replayer goroutine drains the log to the
+// wrapped manifold, retrying until the wrapped manifold accepts each batch,
+// and resumes from the last checkpoint after a restart.
+//
+// When a dead letter sink is configured, a batch which is still rejected after
+// the max number of replay attempts is published to it and skipped, so it
+// doesn't block replay of everything behind it. Otherwise batches are retried
+// until they succeed, unless max replay attempts is explicitly set.
+//
+// NOTE! Delivery is at-least-once. Batches replayed after the last
+// checkpoint are distributed again after a crash.
+type WalManifold struct {
+	manifold    Manifold
+	log         *wal.Log
+	reader      *wal.Reader
+	maxAttempts int
+	deadLetter  sink.Sink
+	stats       atomic.Pointer[stats.ProtocolStats]
+	shutdown    chan struct{}
+	done        chan struct{}
+}
+
+func (m *WalManifold) Initialize(sinks *[]sink.Sink, conf *config.Config) error {
+	if err := m.manifold.Initialize(sinks, conf); err != nil {
+		return err
+	}
+	m.maxAttempts = conf.Manifold.Wal.MaxReplayAttempts
+	if name := conf.Manifold.Wal.DeadLetter; name != "" {
+		if m.maxAttempts <= 0 {
+			m.maxAttempts = DEFAULT_WAL_MAX_REPLAY_ATTEMPTS
+		}
+		for _, s := range *sinks {
+			if s.Name() == name {
+				m.deadLetter = s
+			}
+		}
+		if m.deadLetter == nil {
+			return errors.New("invalid wal dead letter sink: " + name)
+		}
+	}
+	l, err := wal.Open(conf.Manifold.Wal)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not open wal")
+		return err
+	}
+	checkpoint, err := l.Checkpoint()
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not read wal checkpoint")
+		return err
+	}
+	log.Info().Interface("checkpoint", checkpoint).Msg("🟢 resuming wal replay from checkpoint")
+	m.log, m.reader = l, l.NewReader(checkpoint)
+	m.shutdown, m.done = make(chan struct{}), make(chan struct{})
+	go m.replay()
+	return nil
+}
+
+func (m *WalManifold) Distribute(envelopes []envelope.Envelope, s *stats.ProtocolStats) error {
+	if len(envelopes) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(envelopes)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not marshal envelopes for wal")
+		return err
+	}
+	if err := m.log.Append(b); err != nil {
+		log.Error().Err(err).Msg("🔴 could not append envelopes to wal")
+		return err
+	}
+	m.stats.Store(s)
+	for _, e := range envelopes {
+		if *e.Validation.IsValid {
+			s.IncrementValid(&e.EventMeta, 1)
+		} else {
+			s.IncrementInvalid(&e.EventMeta, 1)
+		}
+	}
+	return nil
+}
+
+func (m *WalManifold) commit() {
+	if err := m.log.Commit(m.reader.Position()); err != nil {
+		log.Error().Err(err).Msg("🔴 could not commit wal checkpoint")
+	}
+}
+
+// Distribute a batch to the wrapped manifold, backing off until it succeeds
+// or the max number of attempts (if any) is reached, at which point it is dead-lettered.
+// Backpressure is not a failed attempt. Returns false if the manifold is shut down first.
+func (m *WalManifold) distributeWithBackoff(envelopes []envelope.Envelope) bool {
+	backoff := WAL_REPLAY_INITIAL_BACKOFF
+	attempt := 0
+	for {
+		// Envelopes are counted when they are appended to the log,
+		// so replayed envelopes are counted separately.
+		replayStats := stats.BuildProtocolStats()
+		err := m.manifold.Distribute(envelopes, replayStats)
+		if err == nil {
+			m.recordSampledOut(replayStats)
+			return true
+		}
+		if !errors.Is(err, ErrQueueFull) {
+			attempt++
+		}
+		if m.maxAttempts > 0 && attempt >= m.maxAttempts {
+			m.deadLetterBatch(envelopes, err, attempt)
+			return true
+		}
+		log.Error().Err(err).Int("attempt", attempt).Dur("backoff", backoff).Msg("🔴 could not replay wal batch - retrying")
+		select {
+		case <-time.After(backoff):
+		case <-m.shutdown:
+			return false
+		}
+		backoff *= 2
+		if backoff > WAL_REPLAY_MAX_BACKOFF {
+			backoff = WAL_REPLAY_MAX_BACKOFF
+		}
+	}
+}
+
+// Publish a batch which could not be replayed to the dead letter sink.
+// Without one (or if it fails too) the batch is dropped.
+func (m *WalManifold) deadLetterBatch(envelopes []envelope.Envelope, replayErr error, attempts int) {
+	if m.deadLetter == nil {
+		log.Error().Err(replayErr).Int("attempts", attempts).Int("count", len(envelopes)).Msg("🔴 could not replay wal batch - dropping it")
+		return
+	}
+	deadLettered := sink.AnnotateDeadLetter(envelopes, envelope.DeadLetter{
+		Sink:     WAL,
+		SinkType: WAL,
+		Error:    replayErr.Error(),
+		Attempts: attempts,
+		Tstamp:   time.Now().UTC(),
+	})
+	var valid, invalid []envelope.Envelope
+	for _, e := range deadLettered {
+		if *e.Validation.IsValid {
+			valid = append(valid, e)
+		} else {
+			invalid = append(invalid, e)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), WAL_DEAD_LETTER_TIMEOUT)
+	defer cancel()
+	var err error
+	if len(valid) > 0 {
+		err = m.deadLetter.BatchPublishValid(ctx, valid)
+	}
+	if err == nil && len(invalid) > 0 {
+		err = m.deadLetter.BatchPublishInvalid(ctx, invalid)
+	}
+	if err != nil {
+		log.Error().Err(err).Interface("deadLetterSinkName", m.deadLetter.Name()).Int("count", len(envelopes)).Msg("🔴 could not dead-letter wal batch - dropping it")
+		return
+	}
+	log.Warn().Err(replayErr).Interface("deadLetterSinkName", m.deadLetter.Name()).Int("count", len(envelopes)).Msg("🟡 dead-lettered wal batch")
+}
+
+// Sampling happens in the wrapped manifold, so sampled-out counts
+// of a replayed batch are carried over to the collector's stats.
+func (m *WalManifold) recordSampledOut(replayStats *stats.ProtocolStats) {
+	s := m.stats.Load()
+	if s == nil {
+		return
+	}
+	for protocol, namespaces := range replayStats.SampledOut {
+		for namespace, count := range namespaces {
+			s.IncrementSampledOut(&envelope.EventMeta{Protocol: protocol, Namespace: namespace}, count)
+		}
+	}
+}
+
+func (m *WalManifold) replay() {
+	defer close(m.done)
+	defer m.reader.Close()
+	lastCommit := time.Now()
+	for {
+		select {
+		case <-m.shutdown:
+			m.commit()
+			return
+		default:
+		}
+		data, err := m.reader.Next()
+		if errors.Is(err, wal.ErrNoRecord) {
+			// Caught up - persist progress and wait for more
+			m.commit()
+			lastCommit = time.Now()
+			select {
+			case <-m.log.Appended():
+			case <-time.After(WAL_REPLAY_POLL_INTERVAL):
+			case <-m.shutdown:
+				return
+			}
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not read from wal")
+			select {
+			case <-time.After(WAL_REPLAY_POLL_INTERVAL):
+			case <-m.shutdown:
+				return
+			}
+			continue
+		}
+		var envelopes []envelope.Envelope
+		if err := json.Unmarshal(data, &envelopes); err != nil {
+			log.Error().Err(err).Interface("position", m.reader.Position()).Msg("🔴 could not unmarshal wal record - skipping")
+			continue
+		}
+		if !m.distributeWithBackoff(envelopes) {
+			// The batch was not distributed, so it must not be checkpointed.
+			return
+		}
+		if time.Since(lastCommit) > WAL_CHECKPOINT_INTERVAL {
+			m.commit()
+			lastCommit = time.Now()
+		}
+	}
+}
+
+// Stop replaying, checkpoint progress, and shut down the wrapped manifold.
+// Anything not yet replayed is replayed on the next start.
+func (m *WalManifold) Shutdown() error {
+	log.Debug().Msg("🟡 shutting down wal manifold")
+	close(m.shutdown)
+	<-m.done
+	walErr := m.log.Close()
+	if err := m.manifold.Shutdown(); err != nil {
+		return err
+	}
+	return walErr
+}
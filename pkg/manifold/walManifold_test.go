@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package manifold
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/silverton-io/buz/pkg/sink"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTestWalManifold(t *testing.T, s sink.Sink, dir string) Manifold {
+	conf := config.Config{Manifold: config.Manifold{Wal: config.Wal{Enabled: true, Path: dir, Fsync: "always"}}}
+	sinks := []sink.Sink{s}
+	m, err := BuildAndInitializeManifold(&sinks, &conf)
+	assert.Nil(t, err)
+	assert.IsType(t, &WalManifold{}, m)
+	return m
+}
+
+func TestWalManifoldReplaysToSinks(t *testing.T) {
+	s := recordingSink{}
+	m := buildTestWalManifold(t, &s, t.TempDir())
+	ps := stats.BuildProtocolStats()
+
+	err := m.Distribute(append(buildTestEnvelopes(2, true), buildTestEnvelopes(1, false)...), ps)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), ps.Valid[protocol.PIXEL]["test"])
+	assert.Equal(t, int64(1), ps.Invalid[protocol.PIXEL]["test"])
+	assert.Eventually(t, func() bool {
+		valid, invalid, _ := s.counts()
+		return valid == 2 && invalid == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Nil(t, m.Shutdown())
+}
+
+func TestWalManifoldResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	first := recordingSink{}
+	m := buildTestWalManifold(t, &first, dir)
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(3, true), stats.BuildProtocolStats()))
+	assert.Eventually(t, func() bool {
+		valid, _, _ := first.counts()
+		return valid == 3
+	}, time.Second, 5*time.Millisecond)
+	assert.Nil(t, m.Shutdown())
+
+	// Replayed envelopes are checkpointed and are not replayed again
+	second := recordingSink{}
+	m = buildTestWalManifold(t, &second, dir)
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(1, true), stats.BuildProtocolStats()))
+	assert.Eventually(t, func() bool {
+		valid, _, _ := second.counts()
+		return valid == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Nil(t, m.Shutdown())
+	valid, _, _ := second.counts()
+	assert.Equal(t, 1, valid)
+}
+
+// A required sink which rejects every batch.
+type rejectingSink struct {
+	recordingSink
+}
+
+func (s *rejectingSink) Name() string {
+	return "rejecter"
+}
+
+func (s *rejectingSink) DeliveryRequired() bool {
+	return true
+}
+
+func (s *rejectingSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	return errors.New("rejected")
+}
+
+func TestWalManifoldDeadLettersPoisonBatches(t *testing.T) {
+	rejecter, deadLetter := rejectingSink{}, recordingSink{}
+	conf := config.Config{Manifold: config.Manifold{Wal: config.Wal{
+		Enabled:           true,
+		Path:              t.TempDir(),
+		Fsync:             "always",
+		MaxReplayAttempts: 2,
+		DeadLetter:        deadLetter.Name(),
+	}}}
+	sinks := []sink.Sink{&rejecter, &deadLetter}
+	m, err := BuildAndInitializeManifold(&sinks, &conf)
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.Distribute(buildTestEnvelopes(2, true), stats.BuildProtocolStats()))
+	assert.Eventually(t, func() bool {
+		deadLetter.mu.Lock()
+		defer deadLetter.mu.Unlock()
+		for _, e := range deadLetter.valid {
+			if e.Annotations == nil || e.Annotations.DeadLetter == nil {
+				return false
+			}
+		}
+		return len(deadLetter.valid) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+
+	deadLetter.mu.Lock()
+	assert.Equal(t, 2, deadLetter.valid[0].Annotations.DeadLetter.Attempts)
+	deadLetter.mu.Unlock()
+	assert.Nil(t, m.Shutdown())
+}
+
+func TestWalManifoldRequiresValidDeadLetterSink(t *testing.T) {
+	conf := config.Config{Manifold: config.Manifold{Wal: config.Wal{Enabled: true, Path: t.TempDir(), DeadLetter: "missing"}}}
+	sinks := []sink.Sink{&recordingSink{}}
+	_, err := BuildAndInitializeManifold(&sinks, &conf)
+	assert.NotNil(t, err)
+}
+
+func TestWalManifoldRetriesUntilSuccessWithoutDeadLetterSink(t *testing.T) {
+	m := buildTestWalManifold(t, &rejectingSink{}, t.TempDir())
+	assert.Equal(t, 0, m.(*WalManifold).maxAttempts)
+	assert.Nil(t, m.Shutdown())
+}
+
+func TestWalManifoldRejectsBufferedManifold(t *testing.T) {
+	_, err := BuildManifold(config.Manifold{Type: BUFFERED, Wal: config.Wal{Enabled: true}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+const NAMESPACE string = "buz"
+
+const (
+	SUCCESS string = "success"
+	FAILURE string = "failure"
+	HIT     string = "hit"
+	MISS    string = "miss"
+	VALID   string = "valid"
+	INVALID string = "invalid"
+)
+
+// The registry every buz metric is registered with, served at the metrics route.
+var Registry = prometheus.NewRegistry()
+
+var (
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "Request latency by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	SinkPublishDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "sink_publish_duration_seconds",
+		Help:      "Batch publish latency by sink, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"sink", "sink_type", "validity"})
+	SinkPublishes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "sink_publishes_total",
+		Help:      "Batch publishes by sink and result.",
+	}, []string{"sink", "sink_type", "validity", "result"})
+	SinkBatchSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "sink_batch_size",
+		Help:      "Envelopes per batch publish by sink.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"sink", "sink_type", "validity"})
+	SchemaCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "schema_cache_requests_total",
+		Help:      "Schema cache lookups by result.",
+	}, []string{"result"})
+	RegistryBackendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "registry_backend_errors_total",
+		Help:      "Errors fetching schemas from the registry backend.",
+	}, []string{"backend"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestDuration,
+		SinkPublishDuration,
+		SinkPublishes,
+		SinkBatchSize,
+		SchemaCacheRequests,
+		RegistryBackendErrors,
+	)
+}
+
+var envelopesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(NAMESPACE, "", "envelopes_total"),
+	"Envelopes by outcome, protocol, and namespace.",
+	[]string{"outcome", "protocol", "namespace"}, nil,
+)
+
+// Exposes protocol stats as counters, so that `/stats` and `/metrics` always agree.
+type protocolStatsCollector struct {
+	ps *stats.ProtocolStats
+}
+
+func (c protocolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- envelopesDesc
+}
+
+func (c protocolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for outcome, protocols := range c.ps.Snapshot() {
+		for protocol, namespaces := range protocols {
+			for namespace, count := range namespaces {
+				ch <- prometheus.MustNewConstMetric(envelopesDesc, prometheus.CounterValue, float64(count), outcome, protocol, namespace)
+			}
+		}
+	}
+}
+
+func RegisterProtocolStats(ps *stats.ProtocolStats) error {
+	return Registry.Register(protocolStatsCollector{ps: ps})
+}
+
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	ps := stats.BuildProtocolStats()
+	ps.IncrementValid(&envelope.EventMeta{Protocol: protocol.SNOWPLOW, Namespace: "page_view"}, 3)
+	ps.IncrementInvalid(&envelope.EventMeta{Protocol: protocol.WEBHOOK, Namespace: "hook"}, 1)
+	assert.Nil(t, RegisterProtocolStats(ps))
+	SchemaCacheRequests.WithLabelValues(HIT).Inc()
+	SinkPublishes.WithLabelValues("primary", "blackhole", VALID, SUCCESS).Inc()
+
+	r := gin.New()
+	r.GET("/metrics", Handler())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body, _ := io.ReadAll(w.Body)
+	assert.Contains(t, string(body), `buz_envelopes_total{namespace="page_view",outcome="valid",protocol="snowplow"} 3`)
+	assert.Contains(t, string(body), `buz_envelopes_total{namespace="hook",outcome="invalid",protocol="webhook"} 1`)
+	assert.Contains(t, string(body), `buz_schema_cache_requests_total{result="hit"} 1`)
+	assert.Contains(t, string(body), `buz_sink_publishes_total{result="success",sink="primary",sink_type="blackhole",validity="valid"} 1`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
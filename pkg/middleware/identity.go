@@ -11,10 +11,16 @@ import (
 	"github.com/google/uuid"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/constants"
+	"github.com/silverton-io/buz/pkg/privacy"
 )
 
-func Identity(conf config.Identity) gin.HandlerFunc {
+func Identity(conf config.Identity, consent *privacy.ConsentResolver) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if !consent.AllowsIdentity(c.Request) {
+			// Without consent the fallback identity is used and no cookie is set
+			c.Next()
+			return
+		}
 		identityCookieValue, _ := c.Cookie(conf.Cookie.Name)
 		switch conf.Cookie.SameSite {
 		case "None":
@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/privacy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityConsent(t *testing.T) {
+	u := "/test"
+	conf := config.Identity{Cookie: config.IdentityCookie{Enabled: true, Name: "nuid", TtlDays: 365, Path: "/"}}
+	consent, _ := privacy.BuildConsentResolver(config.Consent{
+		Enabled:  true,
+		Header:   "X-Consent",
+		Identify: []string{"analytics"},
+	})
+	r := gin.New()
+	r.Use(Identity(conf, consent))
+	r.GET(u, testHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	t.Run("consent absent", func(t *testing.T) {
+		resp, _ := http.Get(ts.URL + u)
+		assert.Empty(t, resp.Cookies())
+	})
+
+	t.Run("consent granted", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+u, nil)
+		req.Header.Set("X-Consent", "necessary,analytics")
+		resp, _ := http.DefaultClient.Do(req)
+		assert.Equal(t, 1, len(resp.Cookies()))
+		assert.Equal(t, "nuid", resp.Cookies()[0].Name)
+	})
+}
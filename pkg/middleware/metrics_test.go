@@ -0,0 +1,31 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/silverton-io/buz/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	r := gin.New()
+	r.Use(Metrics())
+	r.GET("/test/:id", testHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, u := range []string{"/test/1", "/test/2", "/missing"} {
+		resp, _ := http.Get(ts.URL + u)
+		resp.Body.Close()
+	}
+	// Both ids share the route template, and unmatched routes share one series
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.RequestDuration))
+}
@@ -6,16 +6,26 @@ package params
 
 import (
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/dedup"
+	"github.com/silverton-io/buz/pkg/enricher"
 	"github.com/silverton-io/buz/pkg/manifold"
 	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/privacy"
 	"github.com/silverton-io/buz/pkg/registry"
 	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/silverton-io/buz/pkg/transformer"
 )
 
 type Handler struct {
-	Config        *config.Config
-	Registry      *registry.Registry
-	Manifold      manifold.Manifold
-	CollectorMeta *meta.CollectorMeta
-	ProtocolStats *stats.ProtocolStats
+	Config          *config.Config
+	Registry        *registry.Registry
+	Manifold        manifold.Manifold
+	CollectorMeta   *meta.CollectorMeta
+	ProtocolStats   *stats.ProtocolStats
+	Transformer     *transformer.Transformer
+	Enrichers       []enricher.Enricher
+	PrivacyPolicy   *privacy.Policy
+	ConsentResolver *privacy.ConsentResolver
+	SuppressionList *privacy.SuppressionList
+	Deduplicator    *dedup.Deduplicator
 }
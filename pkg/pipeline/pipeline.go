@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/annotator"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/dedup"
+	"github.com/silverton-io/buz/pkg/enricher"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/manifold"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/silverton-io/buz/pkg/params"
+	"github.com/silverton-io/buz/pkg/privacy"
+	"github.com/silverton-io/buz/pkg/response"
+	"github.com/silverton-io/buz/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Builds envelopes from an incoming request.
+type Builder func(c *gin.Context, conf *config.Config, m *meta.CollectorMeta) []envelope.Envelope
+
+// Run a single traced stage of the pipeline.
+func stage(ctx context.Context, name string, envelopes []envelope.Envelope, fn func(ctx context.Context) []envelope.Envelope) []envelope.Envelope {
+	ctx, span := tracing.Start(ctx, name, trace.WithAttributes(attribute.Int("buz.envelopes", len(envelopes))))
+	defer span.End()
+	processed := fn(ctx)
+	span.SetAttributes(attribute.Int("buz.envelopes.out", len(processed)))
+	return processed
+}
+
+// Build envelopes from the request, run them through every stage of the pipeline,
+// and distribute them to sinks.
+//
+// If distribution fails, deduplicated event ids are released so that a retried
+// request is not considered a duplicate.
+func Process(c *gin.Context, h params.Handler, build Builder) error {
+	ctx := c.Request.Context()
+	envelopes := stage(ctx, tracing.BUILD_ENVELOPES, nil, func(ctx context.Context) []envelope.Envelope {
+		return tracing.Propagate(h.Config.Tracing, c.Request, build(c, h.Config, h.CollectorMeta))
+	})
+	permittedEnvelopes := stage(ctx, tracing.SUPPRESS, envelopes, func(ctx context.Context) []envelope.Envelope {
+		return privacy.Suppress(envelopes, h.SuppressionList, h.ProtocolStats)
+	})
+	consentedEnvelopes := stage(ctx, tracing.CONSENT, permittedEnvelopes, func(ctx context.Context) []envelope.Envelope {
+		return privacy.ApplyConsent(c.Request, permittedEnvelopes, h.ConsentResolver)
+	})
+	annotatedEnvelopes := stage(ctx, tracing.ANNOTATE, consentedEnvelopes, func(ctx context.Context) []envelope.Envelope {
+		return annotator.Annotate(ctx, consentedEnvelopes, h.Registry)
+	})
+	transformedEnvelopes := stage(ctx, tracing.TRANSFORM, annotatedEnvelopes, func(ctx context.Context) []envelope.Envelope {
+		return h.Transformer.Transform(annotatedEnvelopes)
+	})
+	enrichedEnvelopes := stage(ctx, tracing.ENRICH, transformedEnvelopes, func(ctx context.Context) []envelope.Envelope {
+		return enricher.Enrich(transformedEnvelopes, h.Enrichers)
+	})
+	anonymizedEnvelopes := stage(ctx, tracing.ANONYMIZE, enrichedEnvelopes, func(ctx context.Context) []envelope.Envelope {
+		return privacy.AnonymizeEnvelopes(enrichedEnvelopes, h.PrivacyPolicy)
+	})
+	dedupedEnvelopes := stage(ctx, tracing.DEDUP, anonymizedEnvelopes, func(ctx context.Context) []envelope.Envelope {
+		return dedup.Dedup(anonymizedEnvelopes, h.Deduplicator, h.ProtocolStats)
+	})
+	_, span := tracing.Start(ctx, tracing.DISTRIBUTE, trace.WithAttributes(attribute.Int("buz.envelopes", len(dedupedEnvelopes))))
+	err := h.Manifold.Distribute(dedupedEnvelopes, h.ProtocolStats)
+	tracing.RecordError(span, err)
+	span.End()
+	if err != nil {
+		dedup.Release(dedupedEnvelopes, h.Deduplicator)
+	}
+	return err
+}
+
+// Respond to a request whose envelopes could not be distributed.
+// Batches which can never be accepted are rejected without asking the client to retry.
+func RespondDistributionError(c *gin.Context, err error) {
+	if errors.Is(err, manifold.ErrBatchTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, response.BatchTooLarge)
+		return
+	}
+	c.Header("Retry-After", response.RETRY_AFTER_60)
+	c.JSON(http.StatusServiceUnavailable, response.ManifoldDistributionError)
+}
@@ -5,6 +5,7 @@
 package privacy
 
 import (
+	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/util"
@@ -16,21 +17,45 @@ func anonymize(val string) string {
 	return anonymizedVal
 }
 
-func AnonymizeEnvelopes(envelopes []envelope.Envelope, c config.Privacy) []envelope.Envelope {
+// Apply the deprecated anonymize flags, exactly as they always have been.
+func anonymizeLegacy(e *envelope.Envelope, c config.Anonymize) {
+	if c.Device.Ip {
+		anonymizedIp := anonymize(e.Device.Ip)
+		e.Device.Ip = anonymizedIp
+	}
+	if c.Device.Useragent {
+		anonymizedUa := anonymize(e.Device.Useragent)
+		e.Device.Useragent = anonymizedUa
+	}
+	if c.User.Id && e.User != nil && e.User.Id != nil {
+		anonymizedUserId := anonymize(*e.User.Id)
+		e.User.Id = &anonymizedUserId
+		e.User.AnonymousId = &anonymizedUserId
+	}
+}
+
+// Apply the privacy policy to every envelope.
+// Envelopes which cannot be anonymized are dropped rather than risk leaking pii.
+func AnonymizeEnvelopes(envelopes []envelope.Envelope, p *Policy) []envelope.Envelope {
+	if p == nil {
+		return envelopes
+	}
+	k := p.activeKey()
+	var dk *dataKey
+	if p.keyring != nil {
+		dk = p.keyring.active()
+	}
 	var envs []envelope.Envelope
 	for _, e := range envelopes {
-		if c.Anonymize.Device.Ip {
-			anonymizedIp := anonymize(e.Device.Ip)
-			e.Device.Ip = anonymizedIp
-		}
-		if c.Anonymize.Device.Useragent {
-			anonymizedUa := anonymize(e.Device.Useragent)
-			e.Device.Useragent = anonymizedUa
-		}
-		if c.Anonymize.User.Id && e.User.Id != nil {
-			anonymizedUserId := anonymize(*e.User.Id)
-			e.User.Id = &anonymizedUserId
-			e.User.AnonymousId = &anonymizedUserId
+		anonymizeLegacy(&e, p.legacy)
+		rules := p.rulesFor(&e)
+		if len(rules) > 0 {
+			anonymized, err := p.apply(e, rules, k, dk)
+			if err != nil {
+				log.Error().Err(err).Interface("schema", e.EventMeta.Schema).Msg("🔴 could not anonymize envelope - dropping")
+				continue
+			}
+			e = anonymized
 		}
 		envs = append(envs, e)
 	}
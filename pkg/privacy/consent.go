@@ -0,0 +1,245 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/util"
+)
+
+const (
+	KEEP  string = "keep"
+	STRIP string = "strip"
+)
+
+const (
+	CONSENT_SOURCE_CONTEXT string = "context"
+	CONSENT_SOURCE_HEADER  string = "header"
+	CONSENT_SOURCE_COOKIE  string = "cookie"
+)
+
+// Resolves consent state for incoming events, and decides whether each
+// event is kept, has its identifiers stripped, or is dropped.
+//
+// Consent state is a set of granted categories, read from (in order of precedence)
+// a context matching the configured schema, a request header, or a cookie.
+// Events are dropped unless every `collect` category is granted, and have their
+// identifiers stripped unless every `identify` category is granted.
+// When consent state is absent the configured default decision is used.
+type ConsentResolver struct {
+	header   string
+	cookie   string
+	schema   *regexp.Regexp
+	path     string
+	collect  []string
+	identify []string
+	dflt     string
+}
+
+func BuildConsentResolver(conf config.Consent) (*ConsentResolver, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	r := ConsentResolver{
+		header:   conf.Header,
+		cookie:   conf.Cookie,
+		path:     conf.Path,
+		collect:  normalizeCategories(conf.Collect),
+		identify: normalizeCategories(conf.Identify),
+		dflt:     conf.Default,
+	}
+	switch r.dflt {
+	case KEEP, STRIP, DROP:
+	case "":
+		r.dflt = STRIP
+	default:
+		return nil, errors.New("unsupported default consent decision: " + conf.Default)
+	}
+	if conf.Schema != "" {
+		if conf.Path == "" {
+			return nil, errors.New("consent schema requires a path")
+		}
+		g, err := util.CompileGlob(conf.Schema)
+		if err != nil {
+			return nil, err
+		}
+		r.schema = g
+	}
+	return &r, nil
+}
+
+func normalizeCategories(categories []string) []string {
+	var normalized []string
+	for _, c := range categories {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			normalized = append(normalized, c)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// Parse granted categories from a comma-separated string, a list of strings,
+// or a map of category to boolean.
+func parseCategories(v interface{}) ([]string, bool) {
+	switch c := v.(type) {
+	case string:
+		return normalizeCategories(strings.Split(c, ",")), true
+	case []interface{}:
+		var categories []string
+		for _, category := range c {
+			if s, ok := category.(string); ok {
+				categories = append(categories, s)
+			}
+		}
+		return normalizeCategories(categories), true
+	case map[string]interface{}:
+		var categories []string
+		for category, granted := range c {
+			if g, ok := granted.(bool); ok && g {
+				categories = append(categories, category)
+			}
+		}
+		return normalizeCategories(categories), true
+	}
+	return nil, false
+}
+
+func (r *ConsentResolver) fromRequest(req *http.Request) ([]string, string, bool) {
+	if r.header != "" {
+		if v := req.Header.Get(r.header); v != "" {
+			categories, _ := parseCategories(v)
+			return categories, CONSENT_SOURCE_HEADER, true
+		}
+	}
+	if r.cookie != "" {
+		if cookie, err := req.Cookie(r.cookie); err == nil && cookie.Value != "" {
+			v, err := url.QueryUnescape(cookie.Value)
+			if err != nil {
+				v = cookie.Value
+			}
+			categories, _ := parseCategories(v)
+			return categories, CONSENT_SOURCE_COOKIE, true
+		}
+	}
+	return nil, "", false
+}
+
+func (r *ConsentResolver) fromContexts(e *envelope.Envelope) ([]string, bool) {
+	if r.schema == nil || e.Contexts == nil {
+		return nil, false
+	}
+	for schema, ctx := range *e.Contexts {
+		if !r.schema.MatchString(schema) {
+			continue
+		}
+		m, ok := ctx.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if v, ok := util.GetPath(m, r.path); ok {
+			if categories, ok := parseCategories(v); ok {
+				return categories, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func grantsAll(granted []string, required []string) bool {
+	for _, r := range required {
+		i := sort.SearchStrings(granted, r)
+		if i == len(granted) || granted[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *ConsentResolver) decide(categories []string, found bool) string {
+	switch {
+	case !found:
+		return r.dflt
+	case !grantsAll(categories, r.collect):
+		return DROP
+	case !grantsAll(categories, r.identify):
+		return STRIP
+	default:
+		return KEEP
+	}
+}
+
+// AllowsIdentity reports whether the request's consent permits identifying the device,
+// such as by setting an identity cookie. Consent from contexts is not known until
+// envelopes are built, so only the header and cookie are considered.
+func (r *ConsentResolver) AllowsIdentity(req *http.Request) bool {
+	if r == nil {
+		return true
+	}
+	categories, _, found := r.fromRequest(req)
+	return r.decide(categories, found) == KEEP
+}
+
+// Remove everything which identifies or fingerprints a device or user.
+// Contexts can carry arbitrary identifiers so only the consent context itself is kept.
+func (r *ConsentResolver) stripIdentifiers(e *envelope.Envelope) {
+	e.Device.Ip, e.Device.Useragent, e.Device.Id = "", "", ""
+	e.Device.Idfa, e.Device.Idfv, e.Device.AdId, e.Device.AndroidId, e.Device.Name, e.Device.Token = nil, nil, nil, nil, nil, nil
+	e.User, e.Session = nil, nil
+	if e.Contexts == nil {
+		return
+	}
+	contexts := map[string]interface{}{}
+	for schema, ctx := range *e.Contexts {
+		if r.schema != nil && r.schema.MatchString(schema) {
+			contexts[schema] = ctx
+		}
+	}
+	e.Contexts = nil
+	if len(contexts) > 0 {
+		e.Contexts = &contexts
+	}
+}
+
+// Apply the consent decision to every envelope, recording it in the envelope's annotations.
+func ApplyConsent(req *http.Request, envelopes []envelope.Envelope, r *ConsentResolver) []envelope.Envelope {
+	if r == nil {
+		return envelopes
+	}
+	requestCategories, requestSource, requestFound := r.fromRequest(req)
+	var envs []envelope.Envelope
+	for _, e := range envelopes {
+		categories, source, found := requestCategories, requestSource, requestFound
+		if c, ok := r.fromContexts(&e); ok {
+			categories, source, found = c, CONSENT_SOURCE_CONTEXT, true
+		}
+		decision := r.decide(categories, found)
+		switch decision {
+		case DROP:
+			log.Debug().Interface("schema", e.EventMeta.Schema).Msg("🟡 dropping envelope without consent")
+			continue
+		case STRIP:
+			r.stripIdentifiers(&e)
+		}
+		if e.Annotations == nil {
+			e.Annotations = &envelope.Annotations{}
+		}
+		if categories == nil {
+			categories = []string{}
+		}
+		e.Annotations.Consent = &envelope.Consent{Decision: decision, Source: source, Categories: categories}
+		envs = append(envs, e)
+	}
+	return envs
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildConsentResolver(t *testing.T) *ConsentResolver {
+	r, err := BuildConsentResolver(config.Consent{
+		Enabled:  true,
+		Header:   "X-Consent",
+		Cookie:   "consent",
+		Schema:   "com.acme/consent/*",
+		Path:     "granted",
+		Collect:  []string{"Analytics"},
+		Identify: []string{"analytics", "personalization"},
+		Default:  DROP,
+	})
+	assert.Nil(t, err)
+	return r
+}
+
+func TestConsentDecisions(t *testing.T) {
+	r := buildConsentResolver(t)
+	withContext := func(granted interface{}) envelope.Envelope {
+		e := buildEnvelope()
+		contexts := map[string]interface{}{"com.acme/consent/v1.0.json": map[string]interface{}{"granted": granted}}
+		e.Contexts = &contexts
+		return e
+	}
+
+	t.Run("absent", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		assert.Empty(t, ApplyConsent(req, []envelope.Envelope{buildEnvelope()}, r))
+	})
+
+	t.Run("header keep", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-Consent", "analytics, Personalization")
+		e := ApplyConsent(req, []envelope.Envelope{buildEnvelope()}, r)[0]
+		assert.Equal(t, &envelope.Consent{Decision: KEEP, Source: CONSENT_SOURCE_HEADER, Categories: []string{"analytics", "personalization"}}, e.Annotations.Consent)
+		assert.Equal(t, "203.0.113.42", e.Device.Ip)
+	})
+
+	t.Run("cookie strip", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "consent", Value: "analytics"})
+		e := ApplyConsent(req, []envelope.Envelope{buildEnvelope()}, r)[0]
+		assert.Equal(t, STRIP, e.Annotations.Consent.Decision)
+		assert.Equal(t, CONSENT_SOURCE_COOKIE, e.Annotations.Consent.Source)
+		assert.Equal(t, "", e.Device.Ip)
+		assert.Equal(t, "", e.Device.Useragent)
+		assert.Nil(t, e.User)
+	})
+
+	t.Run("context overrides request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-Consent", "analytics,personalization")
+		envs := ApplyConsent(req, []envelope.Envelope{
+			withContext([]interface{}{"marketing"}),
+			withContext(map[string]interface{}{"analytics": true, "personalization": false}),
+			buildEnvelope(),
+		}, r)
+		assert.Equal(t, 2, len(envs))
+		assert.Equal(t, &envelope.Consent{Decision: STRIP, Source: CONSENT_SOURCE_CONTEXT, Categories: []string{"analytics"}}, envs[0].Annotations.Consent)
+		assert.Equal(t, KEEP, envs[1].Annotations.Consent.Decision)
+	})
+
+	t.Run("strip clears identifying contexts", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		e := withContext("analytics")
+		(*e.Contexts)["com.acme/identity/v1.0.json"] = map[string]interface{}{"email": "jane@example.com"}
+		e = ApplyConsent(req, []envelope.Envelope{e}, r)[0]
+		assert.Equal(t, STRIP, e.Annotations.Consent.Decision)
+		assert.Equal(t, map[string]interface{}{"com.acme/consent/v1.0.json": map[string]interface{}{"granted": "analytics"}}, *e.Contexts)
+		assert.Equal(t, "", e.Device.Useragent)
+	})
+}
+
+func TestConsentAllowsIdentity(t *testing.T) {
+	var disabled *ConsentResolver
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.True(t, disabled.AllowsIdentity(req))
+
+	r := buildConsentResolver(t)
+	assert.False(t, r.AllowsIdentity(req))
+	req.AddCookie(&http.Cookie{Name: "consent", Value: "analytics%2Cpersonalization"})
+	assert.True(t, r.AllowsIdentity(req))
+}
+
+func TestInvalidConsentResolvers(t *testing.T) {
+	r, err := BuildConsentResolver(config.Consent{})
+	assert.Nil(t, r)
+	assert.Nil(t, err)
+	_, err = BuildConsentResolver(config.Consent{Enabled: true, Default: "maybe"})
+	assert.NotNil(t, err)
+	_, err = BuildConsentResolver(config.Consent{Enabled: true, Schema: "com.acme/consent/*"})
+	assert.NotNil(t, err)
+}
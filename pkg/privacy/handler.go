@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/response"
+)
+
+const DEFAULT_SUPPRESSION_ADMIN_PATH string = "/admin/suppressions"
+
+// Identifiers to add to a suppression list.
+// Raw ids are hashed before they are stored, so callers may send either.
+type SuppressionRequest struct {
+	Ids    []string `json:"ids"`
+	Hashes []string `json:"hashes"`
+}
+
+type SuppressionResponse struct {
+	Added int `json:"added"`
+}
+
+func SuppressionHandler(l *SuppressionList, token string) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		if token == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.JSON(http.StatusUnauthorized, response.Unauthorized)
+			return
+		}
+		var req SuppressionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, response.BadRequest)
+			return
+		}
+		var hashes []string
+		for _, id := range req.Ids {
+			if id != "" {
+				hashes = append(hashes, HashIdentifier(id))
+			}
+		}
+		for _, h := range req.Hashes {
+			if !validHash(h) {
+				c.JSON(http.StatusBadRequest, response.BadRequest)
+				return
+			}
+			hashes = append(hashes, h)
+		}
+		if err := l.Add(hashes...); err != nil {
+			log.Error().Err(err).Msg("🔴 could not persist suppression list entries")
+			c.JSON(http.StatusInternalServerError, response.SuppressionNotPersisted)
+			return
+		}
+		log.Info().Int("count", len(hashes)).Msg("🟢 added suppression list entries")
+		c.JSON(http.StatusOK, SuppressionResponse{Added: len(hashes)})
+	}
+	return gin.HandlerFunc(fn)
+}
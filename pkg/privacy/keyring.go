@@ -0,0 +1,184 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/util"
+)
+
+const CIPHERTEXT_PREFIX string = "enc"
+
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+type dataKey struct {
+	id         string
+	aead       cipher.AEAD
+	activeFrom time.Time
+}
+
+func parseActiveFrom(id string, activeFrom string) (time.Time, error) {
+	if activeFrom == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, activeFrom)
+	if err != nil {
+		return t, fmt.Errorf("invalid activeFrom for key %s: %w", id, err)
+	}
+	return t, nil
+}
+
+// A keyring of AES-GCM data keys used to encrypt envelope fields.
+//
+// Fields are encrypted with the most recently activated key, and each ciphertext
+// carries the id of its key so that fields remain decryptable after rotation
+// for as long as the old key stays in the keyring.
+type Keyring struct {
+	keys []dataKey
+	now  func() time.Time
+}
+
+func BuildKeyring(conf []config.KeyringKey) (*Keyring, error) {
+	if len(conf) == 0 {
+		return nil, nil
+	}
+	k := Keyring{now: time.Now}
+	for _, c := range conf {
+		if c.Id == "" || strings.Contains(c.Id, ":") {
+			return nil, errors.New("keyring keys require an id without colons")
+		}
+		secret, err := base64.StdEncoding.DecodeString(c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("keyring key %s is not valid base64: %w", c.Id, err)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyring key %s: %w", c.Id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		activeFrom, err := parseActiveFrom(c.Id, c.ActiveFrom)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = append(k.keys, dataKey{id: c.Id, aead: aead, activeFrom: activeFrom})
+	}
+	sort.SliceStable(k.keys, func(i, j int) bool { return k.keys[i].activeFrom.Before(k.keys[j].activeFrom) })
+	return &k, nil
+}
+
+// The most recently activated key.
+func (k *Keyring) active() *dataKey {
+	now := k.now()
+	active := &k.keys[0]
+	for i := range k.keys {
+		if !k.keys[i].activeFrom.After(now) {
+			active = &k.keys[i]
+		}
+	}
+	return active
+}
+
+// Encrypt a JSON-serializable value, returning `enc:<keyId>:<ciphertext>`.
+// The key id is authenticated along with the value.
+func (k *Keyring) encrypt(v interface{}, key *dataKey) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, plaintext, []byte(key.id))
+	return CIPHERTEXT_PREFIX + ":" + key.id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) Encrypt(v interface{}) (string, error) {
+	return k.encrypt(v, k.active())
+}
+
+// Decrypt a value produced by Encrypt, using whichever key it was encrypted with.
+func (k *Keyring) Decrypt(ciphertext string) (interface{}, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != CIPHERTEXT_PREFIX {
+		return nil, ErrInvalidCiphertext
+	}
+	var key *dataKey
+	for i := range k.keys {
+		if k.keys[i].id == parts[1] {
+			key = &k.keys[i]
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown keyring key: %s", parts[1])
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonceSize := key.aead.NonceSize()
+	plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(key.id))
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	var v interface{}
+	if err := json.Unmarshal(plaintext, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// DecryptEnvelope reverses every encrypt action recorded in the envelope's annotations.
+func (k *Keyring) DecryptEnvelope(e envelope.Envelope) (envelope.Envelope, error) {
+	if e.Annotations == nil || e.Annotations.Pii == nil {
+		return e, nil
+	}
+	m, err := e.AsMap()
+	if err != nil {
+		return e, err
+	}
+	for path, action := range e.Annotations.Pii.Actions {
+		if action != ENCRYPT {
+			continue
+		}
+		v, ok := util.GetPath(m, path)
+		if !ok {
+			continue
+		}
+		ciphertext, ok := v.(string)
+		if !ok {
+			return e, ErrInvalidCiphertext
+		}
+		plaintext, err := k.Decrypt(ciphertext)
+		if err != nil {
+			return e, fmt.Errorf("could not decrypt %s: %w", path, err)
+		}
+		util.SetPath(m, path, plaintext)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return e, err
+	}
+	var decrypted envelope.Envelope
+	if err := json.Unmarshal(b, &decrypted); err != nil {
+		return e, err
+	}
+	return decrypted, nil
+}
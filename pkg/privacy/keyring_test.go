@@ -0,0 +1,105 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+var testKeyring = []config.KeyringKey{
+	{Id: "billing-1", Key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="},
+	{Id: "billing-2", Key: "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=", ActiveFrom: "2022-10-01T00:00:00Z"},
+}
+
+func TestEncryptEnvelopeFields(t *testing.T) {
+	p, err := BuildPolicy(config.Privacy{
+		Keyring: testKeyring,
+		Rules: []config.PiiRule{
+			{Schema: "com.acme/signup/*", Path: "payload.address", Action: ENCRYPT},
+			{Path: "payload.email", Action: ENCRYPT},
+		},
+	}, nil)
+	assert.Nil(t, err)
+	p.keyring.now = func() time.Time { return time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC) }
+
+	original := buildEnvelope()
+	e := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)[0]
+	assert.True(t, strings.HasPrefix(e.Payload["email"].(string), "enc:billing-1:"))
+	assert.True(t, strings.HasPrefix(e.Payload["address"].(string), "enc:billing-1:"))
+	assert.Equal(t, "billing-1", e.Annotations.Pii.EncryptionKeyId)
+	assert.Equal(t, "", e.Annotations.Pii.KeyId)
+
+	// Rotate, then decrypt fields encrypted under the old key
+	p.keyring.now = func() time.Time { return time.Date(2022, 10, 2, 0, 0, 0, 0, time.UTC) }
+	rotated := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)[0]
+	assert.Equal(t, "billing-2", rotated.Annotations.Pii.EncryptionKeyId)
+
+	keyring, _ := BuildKeyring(testKeyring)
+	for _, env := range []envelope.Envelope{e, rotated} {
+		decrypted, err := keyring.DecryptEnvelope(env)
+		assert.Nil(t, err)
+		assert.Equal(t, original.Payload, decrypted.Payload)
+	}
+}
+
+func TestEncryptStructPath(t *testing.T) {
+	// A ciphertext can't be unmarshalled into a struct, so this is rejected up front
+	// rather than dropping every envelope it applies to
+	_, err := BuildPolicy(config.Privacy{
+		Keyring: testKeyring,
+		Rules:   []config.PiiRule{{Path: "device", Action: ENCRYPT}},
+	}, nil)
+	assert.NotNil(t, err)
+	_, err = BuildPolicy(config.Privacy{
+		Keyring: testKeyring,
+		Rules:   []config.PiiRule{{Path: "pipeline.collector.tstamp", Action: ENCRYPT}},
+	}, nil)
+	assert.NotNil(t, err)
+
+	p, err := BuildPolicy(config.Privacy{
+		Keyring: testKeyring,
+		Rules:   []config.PiiRule{{Path: "device.ip", Action: ENCRYPT}},
+	}, nil)
+	assert.Nil(t, err)
+	e := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)
+	assert.Len(t, e, 1)
+	assert.True(t, strings.HasPrefix(e[0].Device.Ip, "enc:"))
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	keyring, _ := BuildKeyring(testKeyring)
+	ciphertext, err := keyring.Encrypt("4111 1111 1111 1111")
+	assert.Nil(t, err)
+	plaintext, err := keyring.Decrypt(ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "4111 1111 1111 1111", plaintext)
+
+	// Swapping the key id is detected, since it is authenticated
+	parts := strings.SplitN(ciphertext, ":", 3)
+	_, err = keyring.Decrypt(parts[0] + ":billing-1:" + parts[2])
+	assert.Equal(t, ErrInvalidCiphertext, err)
+	_, err = keyring.Decrypt("enc:billing-3:" + parts[2])
+	assert.NotNil(t, err)
+	_, err = keyring.Decrypt("plaintext")
+	assert.Equal(t, ErrInvalidCiphertext, err)
+}
+
+func TestInvalidKeyrings(t *testing.T) {
+	k, err := BuildKeyring(nil)
+	assert.Nil(t, k)
+	assert.Nil(t, err)
+	_, err = BuildKeyring([]config.KeyringKey{{Id: "short", Key: "c2hvcnQ="}})
+	assert.NotNil(t, err)
+	_, err = BuildKeyring([]config.KeyringKey{{Id: "a:b", Key: testKeyring[0].Key}})
+	assert.NotNil(t, err)
+	_, err = BuildPolicy(config.Privacy{Rules: []config.PiiRule{{Path: "payload.a", Action: ENCRYPT}}}, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/util"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	HASH     string = "hash"
+	REDACT   string = "redact"
+	TRUNCATE string = "truncate"
+	TOKENIZE string = "tokenize"
+	DROP     string = "drop"
+	ENCRYPT  string = "encrypt"
+)
+
+const (
+	REDACTED       string = "REDACTED"
+	TOKEN_PREFIX   string = "tok"
+	SCHEMA_PII_KEY string = "pii"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type key struct {
+	id         string
+	secret     []byte
+	activeFrom time.Time
+}
+
+type rule struct {
+	schema *regexp.Regexp
+	path   string
+	action string
+}
+
+func validAction(action string) bool {
+	switch action {
+	case HASH, REDACT, TRUNCATE, TOKENIZE, DROP, ENCRYPT:
+		return true
+	}
+	return false
+}
+
+// A field-level privacy policy.
+//
+// Rules target envelope paths such as `device.ip`, `payload.email`, or
+// `contexts.com\.acme/ctx/v1\.0\.json.phone`. When schema annotations are enabled,
+// payload properties annotated with `"pii": "<action>"` in their JSON schema are also applied.
+//
+// The deprecated anonymize flags keep their original behavior of md5-ing fields
+// in place, so that existing join keys are unaffected by upgrades.
+//
+// Hashing is a salted HMAC-SHA256 using the active key, so hash and tokenize rules
+// require a key and encrypt rules require a keyring. Schema annotations can't be
+// checked up front, so fields annotated with an action lacking its key are redacted.
+// Keys are rotated by
+// configuring a new key with a later `activeFrom`, and the id of the key used is
+// recorded in the envelope's annotations.
+//
+// Encrypted fields are opaque to every sink, and can be read with the keyring's
+// DecryptEnvelope by anyone holding the data key.
+type Policy struct {
+	salt              string
+	legacy            config.Anonymize
+	keys              []key
+	keyring           *Keyring
+	rules             []rule
+	schemaAnnotations bool
+	registry          *registry.Registry
+	now               func() time.Time
+}
+
+func BuildPolicy(conf config.Privacy, registry *registry.Registry) (*Policy, error) {
+	p := Policy{salt: conf.Salt, legacy: conf.Anonymize, schemaAnnotations: conf.SchemaAnnotations, registry: registry, now: time.Now}
+	for _, k := range conf.Keys {
+		if k.Id == "" || k.Secret == "" {
+			return nil, errors.New("privacy keys require an id and secret")
+		}
+		activeFrom, err := parseActiveFrom(k.Id, k.ActiveFrom)
+		if err != nil {
+			return nil, err
+		}
+		p.keys = append(p.keys, key{id: k.Id, secret: []byte(k.Secret), activeFrom: activeFrom})
+	}
+	sort.SliceStable(p.keys, func(i, j int) bool { return p.keys[i].activeFrom.Before(p.keys[j].activeFrom) })
+	keyring, err := BuildKeyring(conf.Keyring)
+	if err != nil {
+		return nil, err
+	}
+	p.keyring = keyring
+
+	for _, r := range conf.Rules {
+		if r.Path == "" || !validAction(r.Action) {
+			return nil, fmt.Errorf("invalid pii rule for path %q: unsupported action %q", r.Path, r.Action)
+		}
+		if r.Action != DROP && !stringPath(r.Path) {
+			return nil, fmt.Errorf("invalid pii rule for path %q: %s requires a string field, or a payload or contexts path", r.Path, r.Action)
+		}
+		pr := rule{path: r.Path, action: r.Action}
+		if r.Schema != "" {
+			g, err := util.CompileGlob(r.Schema)
+			if err != nil {
+				return nil, err
+			}
+			pr.schema = g
+		}
+		p.rules = append(p.rules, pr)
+	}
+	for _, r := range p.rules {
+		if (r.action == HASH || r.action == TOKENIZE) && len(p.keys) == 0 {
+			return nil, errors.New(r.action + " requires a privacy key")
+		}
+		if r.action == ENCRYPT && p.keyring == nil {
+			return nil, errors.New("encrypt requires a keyring")
+		}
+	}
+	return &p, nil
+}
+
+// The most recently activated key, or nil if no keys are configured.
+func (p *Policy) activeKey() *key {
+	now := p.now()
+	var active *key
+	for i := range p.keys {
+		if !p.keys[i].activeFrom.After(now) {
+			active = &p.keys[i]
+		}
+	}
+	if active == nil && len(p.keys) > 0 {
+		// Every key activates in the future, so use the earliest
+		active = &p.keys[0]
+	}
+	return active
+}
+
+func (p *Policy) hash(val string, k *key) string {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte(p.salt + val))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func tokenizationKey(k *key) []byte {
+	sum := sha256.Sum256(append([]byte(TOKENIZE+":"), k.secret...))
+	return sum[:]
+}
+
+// Tokens are deterministic, so equal values produce equal tokens,
+// and can be reversed with Detokenize by anyone holding the key.
+func tokenize(val string, k *key) (string, error) {
+	block, err := aes.NewCipher(tokenizationKey(k))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte(val))
+	nonce := mac.Sum(nil)[:gcm.NonceSize()]
+	sealed := gcm.Seal(nonce, nonce, []byte(val), nil)
+	return TOKEN_PREFIX + ":" + k.id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Detokenize reverses a token produced by a tokenize rule.
+func (p *Policy) Detokenize(token string) (string, error) {
+	parts := strings.SplitN(token, ":", 3)
+	if len(parts) != 3 || parts[0] != TOKEN_PREFIX {
+		return "", ErrInvalidToken
+	}
+	var k *key
+	for i := range p.keys {
+		if p.keys[i].id == parts[1] {
+			k = &p.keys[i]
+		}
+	}
+	if k == nil {
+		return "", fmt.Errorf("unknown privacy key: %s", parts[1])
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	block, err := aes.NewCipher(tokenizationKey(k))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", ErrInvalidToken
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	return string(plaintext), nil
+}
+
+// Truncate an IPv4 address to its /24 or an IPv6 address to its /48.
+func truncateIp(val string) (string, bool) {
+	ip := net.ParseIP(val)
+	if ip == nil {
+		return "", false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String(), true
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String(), true
+}
+
+// The field of a struct type with the given json name, including promoted fields.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == "" && f.Anonymous {
+			if promoted, ok := jsonField(f.Type, name); ok {
+				return promoted, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Whether a string can be written at the path of an envelope. This is true of string
+// fields and of anything within a free-form map, such as the payload or contexts.
+func stringPath(path string) bool {
+	t := reflect.TypeOf(envelope.Envelope{})
+	for _, key := range util.SplitPath(path) {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Map:
+			if t.Elem().Kind() == reflect.Interface {
+				return true
+			}
+			t = t.Elem()
+		case reflect.Struct:
+			f, ok := jsonField(t, key)
+			if !ok {
+				return false
+			}
+			t = f.Type
+		default:
+			return false
+		}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.String
+}
+
+func stringify(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Collect payload rules from `pii` annotations in a JSON schema.
+func schemaRules(schema []byte) []rule {
+	var rules []rule
+	var walk func(properties gjson.Result, prefix string)
+	walk = func(properties gjson.Result, prefix string) {
+		properties.ForEach(func(name, property gjson.Result) bool {
+			path := prefix + "." + strings.ReplaceAll(name.String(), ".", `\.`)
+			if action := property.Get(SCHEMA_PII_KEY).String(); validAction(action) {
+				rules = append(rules, rule{path: path, action: action})
+			}
+			if nested := property.Get("properties"); nested.Exists() {
+				walk(nested, path)
+			}
+			return true
+		})
+	}
+	walk(gjson.GetBytes(schema, "properties"), "payload")
+	return rules
+}
+
+func (p *Policy) rulesFor(e *envelope.Envelope) []rule {
+	var rules []rule
+	for _, r := range p.rules {
+		if r.schema == nil || r.schema.MatchString(e.EventMeta.Schema) {
+			rules = append(rules, r)
+		}
+	}
+	if p.schemaAnnotations && p.registry != nil && e.EventMeta.Schema != "" {
+		if exists, schema := p.registry.Get(e.EventMeta.Schema); exists {
+			rules = append(rules, schemaRules(schema)...)
+		}
+	}
+	return rules
+}
+
+func (p *Policy) apply(e envelope.Envelope, rules []rule, k *key, dk *dataKey) (envelope.Envelope, error) {
+	m, err := e.AsMap()
+	if err != nil {
+		return e, err
+	}
+	actions := make(map[string]string)
+	for _, r := range rules {
+		v, ok := util.GetPath(m, r.path)
+		if !ok || v == nil {
+			continue
+		}
+		if ((r.action == HASH || r.action == TOKENIZE) && k == nil) || (r.action == ENCRYPT && dk == nil) {
+			log.Error().Interface("schema", e.EventMeta.Schema).Str("path", r.path).Msg("🔴 no key is configured to " + r.action + " field - redacting it")
+			util.SetPath(m, r.path, REDACTED)
+			actions[r.path] = REDACT
+			continue
+		}
+		switch r.action {
+		case HASH:
+			util.SetPath(m, r.path, p.hash(stringify(v), k))
+		case REDACT:
+			util.SetPath(m, r.path, REDACTED)
+		case TRUNCATE:
+			truncated, ok := truncateIp(stringify(v))
+			if !ok {
+				// Never let something which isn't an ip through untouched
+				truncated = REDACTED
+			}
+			util.SetPath(m, r.path, truncated)
+		case TOKENIZE:
+			token, err := tokenize(stringify(v), k)
+			if err != nil {
+				return e, err
+			}
+			util.SetPath(m, r.path, token)
+		case DROP:
+			util.DeletePath(m, r.path)
+		case ENCRYPT:
+			ciphertext, err := p.keyring.encrypt(v, dk)
+			if err != nil {
+				return e, err
+			}
+			util.SetPath(m, r.path, ciphertext)
+		}
+		actions[r.path] = r.action
+	}
+	if len(actions) == 0 {
+		return e, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return e, err
+	}
+	var anonymized envelope.Envelope
+	if err := json.Unmarshal(b, &anonymized); err != nil {
+		return e, err
+	}
+	if anonymized.Annotations == nil {
+		anonymized.Annotations = &envelope.Annotations{}
+	}
+	anonymized.Annotations.Pii = &envelope.Pii{Actions: actions}
+	for _, action := range actions {
+		switch {
+		case (action == HASH || action == TOKENIZE) && k != nil:
+			anonymized.Annotations.Pii.KeyId = k.id
+		case action == ENCRYPT:
+			anonymized.Annotations.Pii.EncryptionKeyId = dk.id
+		}
+	}
+	return anonymized, nil
+}
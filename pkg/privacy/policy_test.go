@@ -0,0 +1,169 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/event"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSchema = "com.acme/signup/v1.0.json"
+
+func buildEnvelope() envelope.Envelope {
+	userId := "user-1"
+	contexts := map[string]interface{}{
+		"com.acme/ctx/v1.0.json": map[string]interface{}{"phone": "555-0100"},
+	}
+	return envelope.Envelope{
+		EventMeta: envelope.EventMeta{Schema: testSchema},
+		Device:    envelope.Device{Ip: "203.0.113.42", Useragent: "Mozilla/5.0"},
+		User:      &envelope.User{Id: &userId},
+		Contexts:  &contexts,
+		Payload: event.Payload{
+			"email":   "someone@acme.com",
+			"address": map[string]interface{}{"street": "1 Main St", "city": "Springfield"},
+			"ssn":     "000-00-0000",
+		},
+	}
+}
+
+func TestPolicyActions(t *testing.T) {
+	p, err := BuildPolicy(config.Privacy{
+		Salt: "pepper",
+		Keys: []config.PrivacyKey{{Id: "k1", Secret: "secret"}},
+		Rules: []config.PiiRule{
+			{Path: "device.ip", Action: TRUNCATE},
+			{Path: "payload.email", Action: HASH},
+			{Path: "payload.address.street", Action: REDACT},
+			{Path: `contexts.com\.acme/ctx/v1\.0\.json.phone`, Action: TOKENIZE},
+			{Path: "payload.ssn", Action: DROP},
+			{Schema: "com.acme/other/*", Path: "device.useragent", Action: DROP},
+		},
+	}, nil)
+	assert.Nil(t, err)
+
+	e := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)[0]
+	assert.Equal(t, "203.0.113.0", e.Device.Ip)
+	assert.Equal(t, p.hash("someone@acme.com", &p.keys[0]), e.Payload["email"])
+	assert.NotEqual(t, "someone@acme.com", e.Payload["email"])
+	assert.Equal(t, REDACTED, e.Payload["address"].(map[string]interface{})["street"])
+	assert.Equal(t, "Springfield", e.Payload["address"].(map[string]interface{})["city"])
+	assert.NotContains(t, e.Payload, "ssn")
+	assert.Equal(t, "Mozilla/5.0", e.Device.Useragent)
+
+	token := (*e.Contexts)["com.acme/ctx/v1.0.json"].(map[string]interface{})["phone"].(string)
+	assert.True(t, strings.HasPrefix(token, "tok:k1:"))
+	phone, err := p.Detokenize(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "555-0100", phone)
+
+	assert.Equal(t, "k1", e.Annotations.Pii.KeyId)
+	assert.Equal(t, HASH, e.Annotations.Pii.Actions["payload.email"])
+	assert.NotContains(t, e.Annotations.Pii.Actions, "device.useragent")
+}
+
+func TestPolicyKeyRotation(t *testing.T) {
+	p, _ := BuildPolicy(config.Privacy{
+		Keys: []config.PrivacyKey{
+			{Id: "new", Secret: "b", ActiveFrom: "2022-10-01T00:00:00Z"},
+			{Id: "old", Secret: "a"},
+		},
+		Rules: []config.PiiRule{{Path: "payload.email", Action: HASH}},
+	}, nil)
+	p.now = func() time.Time { return time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC) }
+	before := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)[0]
+	assert.Equal(t, "old", before.Annotations.Pii.KeyId)
+
+	p.now = func() time.Time { return time.Date(2022, 10, 2, 0, 0, 0, 0, time.UTC) }
+	after := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)[0]
+	assert.Equal(t, "new", after.Annotations.Pii.KeyId)
+	assert.NotEqual(t, before.Payload["email"], after.Payload["email"])
+}
+
+func TestPolicyLegacyAnonymize(t *testing.T) {
+	conf := config.Privacy{Salt: "pepper"}
+	conf.Anonymize.Device.Ip, conf.Anonymize.User.Id = true, true
+	p, err := BuildPolicy(conf, nil)
+	assert.Nil(t, err)
+	e := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)[0]
+	// Unsalted md5, as before policies existed
+	assert.Equal(t, util.Md5("203.0.113.42"), e.Device.Ip)
+	assert.Equal(t, util.Md5("user-1"), *e.User.Id)
+	assert.Equal(t, util.Md5("user-1"), *e.User.AnonymousId)
+	assert.Equal(t, "Mozilla/5.0", e.Device.Useragent)
+	assert.Nil(t, e.Annotations)
+}
+
+func TestPolicySchemaAnnotations(t *testing.T) {
+	r := registry.Registry{Cache: freecache.NewCache(1024 * 1024)}
+	schema := `{"properties": {"email": {"type": "string", "pii": "hash"}, "address": {"type": "object", "properties": {"street": {"type": "string", "pii": "redact"}}}}}`
+	assert.Nil(t, r.Cache.Set([]byte(testSchema), []byte(schema), 0))
+
+	p, _ := BuildPolicy(config.Privacy{SchemaAnnotations: true, Keys: []config.PrivacyKey{{Id: "k1", Secret: "secret"}}}, &r)
+	e := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)[0]
+	assert.Equal(t, p.hash("someone@acme.com", &p.keys[0]), e.Payload["email"])
+	assert.Equal(t, REDACTED, e.Payload["address"].(map[string]interface{})["street"])
+	assert.Equal(t, "000-00-0000", e.Payload["ssn"])
+}
+
+func TestPolicySchemaAnnotationsWithoutKeys(t *testing.T) {
+	r := registry.Registry{Cache: freecache.NewCache(1024 * 1024)}
+	schema := `{"properties": {"email": {"type": "string", "pii": "tokenize"}, "ssn": {"type": "string", "pii": "hash"}}}`
+	assert.Nil(t, r.Cache.Set([]byte(testSchema), []byte(schema), 0))
+
+	// Actions which need a missing key can't be applied, so the fields are redacted instead
+	p, err := BuildPolicy(config.Privacy{SchemaAnnotations: true}, &r)
+	assert.Nil(t, err)
+	envs := AnonymizeEnvelopes([]envelope.Envelope{buildEnvelope()}, p)
+	assert.Len(t, envs, 1)
+	assert.Equal(t, REDACTED, envs[0].Payload["email"])
+	assert.Equal(t, REDACTED, envs[0].Payload["ssn"])
+	assert.Equal(t, REDACT, envs[0].Annotations.Pii.Actions["payload.email"])
+}
+
+func TestStringPath(t *testing.T) {
+	for _, path := range []string{"device.ip", "device.useragent", "user.id", "payload.email", "payload.a.b", `contexts.com\.acme/ctx/v1\.0\.json.phone`} {
+		assert.True(t, stringPath(path), path)
+	}
+	for _, path := range []string{"device", "user", "payload", "contexts", "pipeline.collector.tstamp", "device.nope"} {
+		assert.False(t, stringPath(path), path)
+	}
+}
+
+func TestTruncateIp(t *testing.T) {
+	v4, _ := truncateIp("192.168.10.77")
+	assert.Equal(t, "192.168.10.0", v4)
+	v6, _ := truncateIp("2001:db8:abcd:12:3456::1")
+	assert.Equal(t, "2001:db8:abcd::", v6)
+	_, ok := truncateIp("hashed-already")
+	assert.False(t, ok)
+}
+
+func TestInvalidPolicies(t *testing.T) {
+	_, err := BuildPolicy(config.Privacy{Rules: []config.PiiRule{{Path: "payload.a", Action: "shred"}}}, nil)
+	assert.NotNil(t, err)
+	_, err = BuildPolicy(config.Privacy{Rules: []config.PiiRule{{Path: "payload.a", Action: TOKENIZE}}}, nil)
+	assert.NotNil(t, err)
+	_, err = BuildPolicy(config.Privacy{Rules: []config.PiiRule{{Path: "payload.a", Action: HASH}}}, nil)
+	assert.NotNil(t, err)
+	_, err = BuildPolicy(config.Privacy{Keys: []config.PrivacyKey{{Id: "k", Secret: "s", ActiveFrom: "yesterday"}}}, nil)
+	assert.NotNil(t, err)
+	// Writing a string over a struct or number would make the envelope unreadable
+	for _, path := range []string{"device", "pipeline.collector.tstamp", "payload", "event.uuid"} {
+		_, err = BuildPolicy(config.Privacy{Rules: []config.PiiRule{{Path: path, Action: REDACT}}}, nil)
+		assert.NotNil(t, err, path)
+	}
+	_, err = BuildPolicy(config.Privacy{Rules: []config.PiiRule{{Path: "device", Action: DROP}}}, nil)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+const DEFAULT_SUPPRESSION_OBJECT string = "suppressions.txt"
+
+// Hash an identifier the way it is stored in a suppression list.
+func HashIdentifier(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func validHash(h string) bool {
+	if len(h) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+// A list of users and devices whose events must not be collected.
+//
+// Entries are hex-encoded sha256 hashes of a `User.Id`, `Device.Id`,
+// `Device.Idfa`, or `Device.AdId`, one per line. The list is loaded from a
+// registry-style backend and optionally refreshed. Entries added through the
+// admin route are appended to a journal file before they take effect, and the
+// journal is reloaded alongside the backend at startup and on every refresh.
+// Replicas sharing a journal pick up each other's additions on refresh.
+type SuppressionList struct {
+	mu      sync.RWMutex
+	jmu     sync.Mutex
+	loaded  map[string]struct{}
+	added   map[string]struct{}
+	backend registry.SchemaCacheBackend
+	object  string
+	journal string
+	done    chan struct{}
+}
+
+func BuildSuppressionList(conf config.Suppression) (*SuppressionList, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	if conf.Admin.Enabled && conf.Admin.Token == "" {
+		return nil, errors.New("the suppression admin route requires a token")
+	}
+	if conf.Admin.Enabled && conf.Admin.Journal == "" {
+		return nil, errors.New("the suppression admin route requires a journal")
+	}
+	l := SuppressionList{
+		loaded:  make(map[string]struct{}),
+		added:   make(map[string]struct{}),
+		object:  conf.Object,
+		journal: conf.Admin.Journal,
+		done:    make(chan struct{}),
+	}
+	if l.object == "" {
+		l.object = DEFAULT_SUPPRESSION_OBJECT
+	}
+	if conf.Backend.Type != "" {
+		backend, err := registry.BuildSchemaCacheBackend(conf.Backend)
+		if err != nil {
+			return nil, err
+		}
+		if err := registry.InitializeSchemaCacheBackend(conf.Backend, backend); err != nil {
+			return nil, err
+		}
+		l.backend = backend
+	}
+	if err := l.Load(); err != nil {
+		if l.backend != nil {
+			l.backend.Close()
+		}
+		return nil, err
+	}
+	if conf.RefreshSeconds > 0 && (l.backend != nil || l.journal != "") {
+		go l.refresh(time.Duration(conf.RefreshSeconds) * time.Second)
+	}
+	return &l, nil
+}
+
+func parseSuppressions(contents []byte) map[string]struct{} {
+	hashes := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !validHash(line) {
+			log.Warn().Msg("🟡 skipping invalid suppression list entry")
+			continue
+		}
+		hashes[line] = struct{}{}
+	}
+	return hashes
+}
+
+// Load (or reload) the list from its backend and journal.
+func (l *SuppressionList) Load() error {
+	hashes := make(map[string]struct{})
+	if l.backend != nil {
+		contents, err := l.backend.GetRemote(l.object)
+		if err != nil {
+			return err
+		}
+		hashes = parseSuppressions(contents)
+	}
+	if l.journal != "" {
+		contents, err := os.ReadFile(l.journal)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for h := range parseSuppressions(contents) {
+			hashes[h] = struct{}{}
+		}
+	}
+	l.mu.Lock()
+	l.loaded = hashes
+	l.mu.Unlock()
+	log.Debug().Int("entries", len(hashes)).Msg("🟡 suppression list loaded")
+	return nil
+}
+
+func (l *SuppressionList) refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Load(); err != nil {
+				log.Error().Err(err).Msg("🔴 could not refresh suppression list - keeping previous list")
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *SuppressionList) appendJournal(hashes []string) error {
+	l.jmu.Lock()
+	defer l.jmu.Unlock()
+	f, err := os.OpenFile(l.journal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, h := range hashes {
+		buf.WriteString(h + "\n")
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Add hashed identifiers to the list.
+// Additions are persisted to the journal (when configured) before they take effect.
+func (l *SuppressionList) Add(hashes ...string) error {
+	normalized := make([]string, len(hashes))
+	for i, h := range hashes {
+		normalized[i] = strings.ToLower(h)
+	}
+	if l.journal != "" && len(normalized) > 0 {
+		if err := l.appendJournal(normalized); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, h := range normalized {
+		l.added[h] = struct{}{}
+	}
+	return nil
+}
+
+func (l *SuppressionList) contains(id *string) bool {
+	if id == nil || *id == "" {
+		return false
+	}
+	h := HashIdentifier(*id)
+	if _, ok := l.loaded[h]; ok {
+		return true
+	}
+	_, ok := l.added[h]
+	return ok
+}
+
+// Suppressed reports whether the envelope's user or device is on the list.
+func (l *SuppressionList) Suppressed(e *envelope.Envelope) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if e.User != nil && l.contains(e.User.Id) {
+		return true
+	}
+	return l.contains(&e.Device.Id) || l.contains(e.Device.Idfa) || l.contains(e.Device.AdId)
+}
+
+func (l *SuppressionList) Close() {
+	if l == nil {
+		return
+	}
+	close(l.done)
+	if l.backend != nil {
+		l.backend.Close()
+	}
+}
+
+// Drop envelopes whose user or device is on the suppression list.
+// Nothing about suppressed envelopes is kept beyond a count.
+func Suppress(envelopes []envelope.Envelope, l *SuppressionList, ps *stats.ProtocolStats) []envelope.Envelope {
+	if l == nil {
+		return envelopes
+	}
+	var envs []envelope.Envelope
+	for _, e := range envelopes {
+		if l.Suppressed(&e) {
+			ps.IncrementSuppressed(&e.EventMeta, 1)
+			continue
+		}
+		envs = append(envs, e)
+	}
+	return envs
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package privacy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func suppressionConfig(dir string, entries ...string) config.Suppression {
+	contents := "# suppressed identifiers\n" + strings.Join(entries, "\n") + "\nnot-a-hash\n"
+	os.WriteFile(filepath.Join(dir, DEFAULT_SUPPRESSION_OBJECT), []byte(contents), 0644)
+	return config.Suppression{
+		Enabled: true,
+		Backend: config.Backend{Type: "fs", Path: dir},
+		Admin:   config.Admin{Enabled: true, Token: "s3cret", Journal: filepath.Join(dir, "suppressions.journal")},
+	}
+}
+
+func buildSuppressionList(t *testing.T, entries ...string) *SuppressionList {
+	l, err := BuildSuppressionList(suppressionConfig(t.TempDir(), entries...))
+	assert.Nil(t, err)
+	t.Cleanup(l.Close)
+	return l
+}
+
+func TestBuildSuppressionListRequiresAdminTokenAndJournal(t *testing.T) {
+	l, err := BuildSuppressionList(config.Suppression{Enabled: true, Admin: config.Admin{Enabled: true, Journal: "suppressions.journal"}})
+	assert.Nil(t, l)
+	assert.NotNil(t, err)
+	l, err = BuildSuppressionList(config.Suppression{Enabled: true, Admin: config.Admin{Enabled: true, Token: "s3cret"}})
+	assert.Nil(t, l)
+	assert.NotNil(t, err)
+}
+
+func TestSuppressionAdditionsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	conf := suppressionConfig(dir)
+	l, err := BuildSuppressionList(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, l.Add(HashIdentifier("device-1")))
+	l.Close()
+
+	l, err = BuildSuppressionList(conf)
+	assert.Nil(t, err)
+	defer l.Close()
+	assert.True(t, l.Suppressed(&envelope.Envelope{Device: envelope.Device{Id: "device-1"}}))
+}
+
+func TestSuppress(t *testing.T) {
+	adId := "38400000-8cf0-11bd-b23e-10b96e40000d"
+	l := buildSuppressionList(t, HashIdentifier("user-1"), strings.ToUpper(HashIdentifier(adId)))
+	ps := stats.BuildProtocolStats()
+
+	suppressedUser := buildEnvelope()
+	suppressedUser.EventMeta.Protocol, suppressedUser.EventMeta.Namespace = protocol.SNOWPLOW, "page_view"
+	suppressedDevice := buildEnvelope()
+	suppressedDevice.User, suppressedDevice.Device.AdId = nil, &adId
+	suppressedDevice.EventMeta.Protocol, suppressedDevice.EventMeta.Namespace = protocol.SNOWPLOW, "page_view"
+	kept := buildEnvelope()
+	kept.User = nil
+
+	envs := Suppress([]envelope.Envelope{suppressedUser, suppressedDevice, kept}, l, ps)
+	assert.Equal(t, []envelope.Envelope{kept}, envs)
+	assert.Equal(t, int64(2), ps.Suppressed[protocol.SNOWPLOW]["page_view"])
+
+	kept.Device.Id = "device-1"
+	assert.False(t, l.Suppressed(&kept))
+	assert.Nil(t, l.Add(HashIdentifier("device-1")))
+	assert.True(t, l.Suppressed(&kept))
+}
+
+func TestSuppressionHandler(t *testing.T) {
+	l := buildSuppressionList(t)
+	r := gin.New()
+	r.POST(DEFAULT_SUPPRESSION_ADMIN_PATH, SuppressionHandler(l, "s3cret"))
+	post := func(body string, token string) int {
+		req := httptest.NewRequest(http.MethodPost, DEFAULT_SUPPRESSION_ADMIN_PATH, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	body := `{"ids": ["user-2"], "hashes": ["` + HashIdentifier("device-2") + `"]}`
+	assert.Equal(t, http.StatusUnauthorized, post(body, ""))
+	assert.Equal(t, http.StatusUnauthorized, post(body, "wrong"))
+	assert.Equal(t, http.StatusBadRequest, post(`{"hashes": ["nope"]}`, "s3cret"))
+	assert.Equal(t, http.StatusOK, post(body, "s3cret"))
+
+	userId := "user-2"
+	assert.True(t, l.Suppressed(&envelope.Envelope{User: &envelope.User{Id: &userId}}))
+	assert.True(t, l.Suppressed(&envelope.Envelope{Device: envelope.Device{Id: "device-2"}}))
+
+	// A route without a token never accepts requests
+	r.POST("/open", SuppressionHandler(l, ""))
+	req := httptest.NewRequest(http.MethodPost, "/open", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -5,16 +5,22 @@
 package registry
 
 import (
+	"context"
 	"strings"
 
 	"github.com/coocood/freecache"
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/metrics"
+	"github.com/silverton-io/buz/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Registry struct {
 	Cache        *freecache.Cache
 	Backend      SchemaCacheBackend
+	backendType  string
 	maxSizeBytes int
 	ttlSeconds   int
 }
@@ -26,6 +32,7 @@ func (r *Registry) Initialize(conf config.Registry) error {
 		return initErr
 	}
 	r.Backend = cacheBackend
+	r.backendType = conf.Backend.Type
 	r.Cache = freecache.NewCache(conf.MaxSizeBytes)
 	r.maxSizeBytes = conf.MaxSizeBytes
 	r.ttlSeconds = conf.TtlSeconds
@@ -33,20 +40,34 @@ func (r *Registry) Initialize(conf config.Registry) error {
 }
 
 func (r *Registry) Get(key string) (exists bool, data []byte) {
+	return r.GetContext(context.Background(), key)
+}
+
+// Get a schema, tracing any fetch from the remote backend as a child of ctx.
+func (r *Registry) GetContext(ctx context.Context, key string) (exists bool, data []byte) {
 	k := []byte(key)
 	schemaContents, _ := r.Cache.Get(k)
 	if schemaContents != nil { // Schema already cached locally
 		log.Debug().Msg("🟡 found cache key " + key)
+		metrics.SchemaCacheRequests.WithLabelValues(metrics.HIT).Inc()
 		return true, schemaContents
 	} else { // Schema not yet cached locally - getting from remote backend
+		metrics.SchemaCacheRequests.WithLabelValues(metrics.MISS).Inc()
 		// Ensure schemaKey is key ending in .json (add if not present)
 		schemaKey := key
 		if !strings.HasSuffix(schemaKey, ".json") {
 			schemaKey = schemaKey + ".json"
 		}
+		_, span := tracing.Start(ctx, tracing.REGISTRY_FETCH, trace.WithAttributes(
+			attribute.String("buz.registry.backend", r.backendType),
+			attribute.String("buz.schema", schemaKey),
+		))
 		schemaContents, err := r.Backend.GetRemote(schemaKey)
+		tracing.RecordError(span, err)
+		span.End()
 		if err != nil { // Error when getting schema from remote backend
 			log.Debug().Msg("error when getting remote schema")
+			metrics.RegistryBackendErrors.WithLabelValues(r.backendType).Inc()
 			return false, nil
 		}
 		log.Debug().Msg("🟡 caching " + key)
@@ -39,3 +39,19 @@ var RateLimitExceeded = Response{
 var ManifoldDistributionError = Response{
 	Message: "distribution error",
 }
+
+var BatchTooLarge = Response{
+	Message: "batch too large",
+}
+
+var Unauthorized = Response{
+	Message: "unauthorized",
+}
+
+var ClusterStatsUnavailable = Response{
+	Message: "cluster stats unavailable",
+}
+
+var SuppressionNotPersisted = Response{
+	Message: "suppression could not be persisted",
+}
@@ -17,6 +17,10 @@ func TestResponse(t *testing.T) {
 		{SchemaNotCached, Response{Message: "schema not cached"}},
 		{Timeout, Response{Message: "request timed out"}},
 		{RateLimitExceeded, Response{Message: "rate limit exceeded"}},
+		{BatchTooLarge, Response{Message: "batch too large"}},
+		{Unauthorized, Response{Message: "unauthorized"}},
+		{ClusterStatsUnavailable, Response{Message: "cluster stats unavailable"}},
+		{SuppressionNotPersisted, Response{Message: "suppression could not be persisted"}},
 	}
 
 	for _, tc := range testCases {
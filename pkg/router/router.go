@@ -0,0 +1,167 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package router
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/util"
+)
+
+type field struct {
+	get  func(m *envelope.EventMeta) string
+	glob *regexp.Regexp
+}
+
+// A single routing rule. Every populated field of the rule must match.
+// Paths use the same dot-delimited syntax as transforms and privacy rules,
+// with dots inside keys escaped by a backslash.
+type rule struct {
+	fields []field
+	path   string
+	value  *regexp.Regexp
+}
+
+func buildRule(conf config.Route) (*rule, error) {
+	r := rule{path: conf.Path}
+	metaFields := []struct {
+		glob string
+		get  func(m *envelope.EventMeta) string
+	}{
+		{conf.Protocol, func(m *envelope.EventMeta) string { return m.Protocol }},
+		{conf.Vendor, func(m *envelope.EventMeta) string { return m.Vendor }},
+		{conf.Namespace, func(m *envelope.EventMeta) string { return m.Namespace }},
+		{conf.Version, func(m *envelope.EventMeta) string { return m.Version }},
+		{conf.Schema, func(m *envelope.EventMeta) string { return m.Schema }},
+	}
+	for _, f := range metaFields {
+		if f.glob == "" {
+			continue
+		}
+		g, err := util.CompileGlob(f.glob)
+		if err != nil {
+			return nil, err
+		}
+		r.fields = append(r.fields, field{get: f.get, glob: g})
+	}
+	if conf.Value != "" {
+		if conf.Path == "" {
+			return nil, errors.New("route value requires a path")
+		}
+		g, err := util.CompileGlob(conf.Value)
+		if err != nil {
+			return nil, err
+		}
+		r.value = g
+	}
+	if len(r.fields) == 0 && r.path == "" {
+		return nil, errors.New("route must match on at least one field")
+	}
+	return &r, nil
+}
+
+func (r *rule) matches(e *envelope.Envelope, doc func() map[string]interface{}) bool {
+	for _, f := range r.fields {
+		if !f.glob.MatchString(f.get(&e.EventMeta)) {
+			return false
+		}
+	}
+	if r.path == "" {
+		return true
+	}
+	v, ok := util.GetPath(doc(), r.path)
+	if !ok {
+		return false
+	}
+	return r.value == nil || r.value.MatchString(util.PathString(v))
+}
+
+// Router decides which envelopes a sink receives.
+// An envelope is routed to the sink unless it matches an exclude rule,
+// and - when include rules are configured - only if it matches one of them.
+type Router struct {
+	include []*rule
+	exclude []*rule
+}
+
+func BuildRouter(conf config.Routing) (*Router, error) {
+	r := Router{}
+	for _, c := range conf.Include {
+		rule, err := buildRule(c)
+		if err != nil {
+			log.Error().Err(err).Interface("route", c).Msg("🔴 invalid include route")
+			return nil, err
+		}
+		r.include = append(r.include, rule)
+	}
+	for _, c := range conf.Exclude {
+		rule, err := buildRule(c)
+		if err != nil {
+			log.Error().Err(err).Interface("route", c).Msg("🔴 invalid exclude route")
+			return nil, err
+		}
+		r.exclude = append(r.exclude, rule)
+	}
+	return &r, nil
+}
+
+func anyMatch(rules []*rule, e *envelope.Envelope, doc func() map[string]interface{}) bool {
+	for _, r := range rules {
+		if r.matches(e, doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) Matches(e *envelope.Envelope) bool {
+	// Only convert the envelope if a path-based rule needs it
+	var m map[string]interface{}
+	doc := func() map[string]interface{} {
+		if m == nil {
+			var err error
+			if m, err = e.AsMap(); err != nil {
+				log.Error().Err(err).Msg("🔴 could not convert envelope for routing")
+				m = map[string]interface{}{}
+			}
+		}
+		return m
+	}
+	if anyMatch(r.exclude, e, doc) {
+		return false
+	}
+	return len(r.include) == 0 || anyMatch(r.include, e, doc)
+}
+
+// Route returns the envelopes which should be published to the sink.
+func (r *Router) Route(envelopes []envelope.Envelope) []envelope.Envelope {
+	if len(r.include) == 0 && len(r.exclude) == 0 {
+		return envelopes
+	}
+	var routed []envelope.Envelope
+	for i := range envelopes {
+		if r.Matches(&envelopes[i]) {
+			routed = append(routed, envelopes[i])
+		}
+	}
+	return routed
+}
+
+// BuildRouters builds a router for every configured sink, keyed by sink name.
+func BuildRouters(conf []config.Sink) (map[string]*Router, error) {
+	routers := make(map[string]*Router)
+	for _, sConf := range conf {
+		r, err := BuildRouter(sConf.Routing)
+		if err != nil {
+			return nil, err
+		}
+		routers[sConf.Name] = r
+	}
+	return routers, nil
+}
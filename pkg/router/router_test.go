@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package router
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildEnvelope(proto string, schema string, deviceId string) envelope.Envelope {
+	return envelope.Envelope{
+		EventMeta: envelope.EventMeta{Protocol: proto, Schema: schema, Vendor: "com.acme", Version: "1.0"},
+		Device:    envelope.Device{Id: deviceId},
+	}
+}
+
+func TestRouterInclude(t *testing.T) {
+	r, err := BuildRouter(config.Routing{
+		Include: []config.Route{{Schema: "com.acme/billing/*"}},
+	})
+	assert.Nil(t, err)
+	billing := buildEnvelope(protocol.SELF_DESCRIBING, "com.acme/billing/invoice/v1.0.json", "a")
+	other := buildEnvelope(protocol.SELF_DESCRIBING, "com.acme/shipping/label/v1.0.json", "a")
+	assert.True(t, r.Matches(&billing))
+	assert.False(t, r.Matches(&other))
+	assert.Len(t, r.Route([]envelope.Envelope{billing, other}), 1)
+}
+
+func TestRouterExclude(t *testing.T) {
+	r, err := BuildRouter(config.Routing{
+		Exclude: []config.Route{{Protocol: protocol.PIXEL}},
+	})
+	assert.Nil(t, err)
+	pixel := buildEnvelope(protocol.PIXEL, "io.silverton/buz/pixel/arbitrary/v1.0.json", "a")
+	snowplow := buildEnvelope(protocol.SNOWPLOW, "io.silverton/snowplow/page_view/v1.0.json", "a")
+	assert.False(t, r.Matches(&pixel))
+	assert.True(t, r.Matches(&snowplow))
+}
+
+func TestRouterPath(t *testing.T) {
+	r, err := BuildRouter(config.Routing{
+		Include: []config.Route{{Path: "device.id", Value: "abc*"}},
+	})
+	assert.Nil(t, err)
+	match := buildEnvelope(protocol.PIXEL, "", "abc123")
+	noMatch := buildEnvelope(protocol.PIXEL, "", "xyz")
+	assert.True(t, r.Matches(&match))
+	assert.False(t, r.Matches(&noMatch))
+
+	// Path existence
+	r, _ = BuildRouter(config.Routing{Include: []config.Route{{Path: "user.id"}}})
+	assert.False(t, r.Matches(&match))
+
+	// Dots within keys are escaped, as in transform and privacy paths
+	r, _ = BuildRouter(config.Routing{Include: []config.Route{{Path: `contexts.com\.acme/cart/v1\.0\.json.items`, Value: "3"}}})
+	contexts := map[string]interface{}{"com.acme/cart/v1.0.json": map[string]interface{}{"items": 3}}
+	match.Contexts = &contexts
+	assert.True(t, r.Matches(&match))
+	assert.False(t, r.Matches(&noMatch))
+}
+
+func TestRouterRuleFieldsAreConjunctive(t *testing.T) {
+	r, _ := BuildRouter(config.Routing{
+		Include: []config.Route{{Vendor: "com.acme", Version: "2.*"}},
+	})
+	e := buildEnvelope(protocol.SELF_DESCRIBING, "", "")
+	assert.False(t, r.Matches(&e))
+}
+
+func TestInvalidRoutes(t *testing.T) {
+	_, err := BuildRouter(config.Routing{Include: []config.Route{{}}})
+	assert.NotNil(t, err)
+	_, err = BuildRouter(config.Routing{Include: []config.Route{{Value: "something"}}})
+	assert.NotNil(t, err)
+}
+
+func TestRouterWithoutRulesPassesThrough(t *testing.T) {
+	r, _ := BuildRouter(config.Routing{})
+	envelopes := []envelope.Envelope{buildEnvelope(protocol.PIXEL, "", "")}
+	assert.Equal(t, envelopes, r.Route(envelopes))
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/util"
+)
+
+// Buckets are full again after a second without events,
+// so any idle for longer are swept rather than kept forever.
+const RATE_CAP_SWEEP_INTERVAL = 1 * time.Second
+
+// A token bucket refilled at a fixed number of events per second,
+// holding at most one second's worth of events.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// The tokens in the bucket at the given time, refilled since it was last taken from.
+func (b *tokenBucket) available(now time.Time) float64 {
+	if b.last.IsZero() {
+		return b.rate
+	}
+	return math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+}
+
+func (b *tokenBucket) take(now time.Time, n int) {
+	b.tokens = b.available(now) - float64(n)
+	b.last = now
+}
+
+// A rate cap keeps a bucket for every recently-seen namespace it matches.
+type rateCap struct {
+	namespace       *regexp.Regexp
+	eventsPerSecond float64
+	buckets         map[string]*tokenBucket
+}
+
+func (c *rateCap) available(namespace string, now time.Time) float64 {
+	if b, ok := c.buckets[namespace]; ok {
+		return b.available(now)
+	}
+	return c.eventsPerSecond
+}
+
+// Remove buckets which have refilled completely, and are equivalent to a new one.
+func (c *rateCap) sweep(now time.Time) {
+	for namespace, b := range c.buckets {
+		if now.Sub(b.last) >= RATE_CAP_SWEEP_INTERVAL {
+			delete(c.buckets, namespace)
+		}
+	}
+}
+
+// Sampler decides which envelopes are kept for a sink.
+//
+// Sampling is deterministic: the configured key (such as `device.id` or `session.id`)
+// is hashed, so every envelope sharing a key is kept or dropped together.
+// Envelopes without the key are sampled on their event uuid.
+//
+// Rate caps limit the events per second delivered to the sink, with an
+// independent limit for every namespace matching the cap. Namespaces are
+// client-controlled, so buckets of idle namespaces are periodically swept.
+// Envelopes which might still be rejected downstream are sampled with Reserve,
+// so rate cap tokens are only taken once they are accepted.
+type Sampler struct {
+	mu        sync.Mutex
+	rate      float64
+	key       string
+	resolve   func(e *envelope.Envelope) (interface{}, bool)
+	namespace *regexp.Regexp
+	caps      []*rateCap
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func BuildSampler(sampling config.Sampling, caps []config.RateCap) (*Sampler, error) {
+	s := Sampler{rate: 1, now: time.Now}
+	if sampling.Enabled {
+		if sampling.Rate < 0 || sampling.Rate > 1 {
+			return nil, errors.New("sampling rate must be between 0 and 1")
+		}
+		s.rate, s.key = sampling.Rate, sampling.Key
+		if s.key != "" {
+			s.resolve = keyResolver(s.key)
+		}
+		if sampling.Namespace != "" {
+			g, err := util.CompileGlob(sampling.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.namespace = g
+		}
+	}
+	for _, c := range caps {
+		if c.EventsPerSecond <= 0 {
+			return nil, errors.New("rate cap eventsPerSecond must be positive")
+		}
+		namespace := c.Namespace
+		if namespace == "" {
+			namespace = "*"
+		}
+		g, err := util.CompileGlob(namespace)
+		if err != nil {
+			return nil, err
+		}
+		s.caps = append(s.caps, &rateCap{namespace: g, eventsPerSecond: c.EventsPerSecond, buckets: make(map[string]*tokenBucket)})
+	}
+	return &s, nil
+}
+
+func (s *Sampler) samples(e *envelope.Envelope) bool {
+	return s.rate < 1 && (s.namespace == nil || s.namespace.MatchString(e.Namespace))
+}
+
+// Resolve a sampling key directly from the envelope where possible,
+// falling back to a map of the whole envelope for any other path.
+func keyResolver(key string) func(e *envelope.Envelope) (interface{}, bool) {
+	switch key {
+	case "device.id":
+		return func(e *envelope.Envelope) (interface{}, bool) { return e.Device.Id, true }
+	case "user.id":
+		return func(e *envelope.Envelope) (interface{}, bool) {
+			if e.User == nil || e.User.Id == nil {
+				return nil, false
+			}
+			return *e.User.Id, true
+		}
+	case "session.id":
+		return func(e *envelope.Envelope) (interface{}, bool) {
+			if e.Session == nil || e.Session.Id == nil {
+				return nil, false
+			}
+			return *e.Session.Id, true
+		}
+	}
+	// The rest of the path keeps its escaped dots
+	if rest := strings.TrimPrefix(key, "payload."); rest != key {
+		return func(e *envelope.Envelope) (interface{}, bool) { return util.GetPath(e.Payload, rest) }
+	}
+	if rest := strings.TrimPrefix(key, "contexts."); rest != key {
+		return func(e *envelope.Envelope) (interface{}, bool) {
+			if e.Contexts == nil {
+				return nil, false
+			}
+			return util.GetPath(*e.Contexts, rest)
+		}
+	}
+	return func(e *envelope.Envelope) (interface{}, bool) {
+		m, err := e.AsMap()
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not convert envelope for sampling")
+			return nil, false
+		}
+		return util.GetPath(m, key)
+	}
+}
+
+func (s *Sampler) sampleKey(e *envelope.Envelope) string {
+	if s.resolve != nil {
+		if v, ok := s.resolve(e); ok && util.PathString(v) != "" {
+			return util.PathString(v)
+		}
+	}
+	return e.Uuid.String()
+}
+
+// Keep returns true if the envelope is within the sampling rate.
+func (s *Sampler) Keep(e *envelope.Envelope) bool {
+	if !s.samples(e) {
+		return true
+	}
+	sum := sha256.Sum256([]byte(s.sampleKey(e)))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/float64(math.MaxUint64) < s.rate
+}
+
+// Rate cap tokens to take once envelopes are accepted, by cap and namespace.
+type Reservation struct {
+	s     *Sampler
+	takes map[*rateCap]map[string]int
+}
+
+// Take the reserved rate cap tokens.
+func (r *Reservation) Commit() {
+	if r == nil || len(r.takes) == 0 {
+		return
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	now := r.s.now()
+	for c, namespaces := range r.takes {
+		for namespace, n := range namespaces {
+			b, ok := c.buckets[namespace]
+			if !ok {
+				b = &tokenBucket{rate: c.eventsPerSecond}
+				c.buckets[namespace] = b
+			}
+			b.take(now, n)
+		}
+	}
+}
+
+// Check the envelope against every matching rate cap, counting the tokens
+// already reserved. If it is within all of them a token is reserved from each.
+func (s *Sampler) reserve(e *envelope.Envelope, now time.Time, takes map[*rateCap]map[string]int) bool {
+	var matched []*rateCap
+	for _, c := range s.caps {
+		if !c.namespace.MatchString(e.Namespace) {
+			continue
+		}
+		if c.available(e.Namespace, now)-float64(takes[c][e.Namespace]) < 1 {
+			return false
+		}
+		matched = append(matched, c)
+	}
+	for _, c := range matched {
+		if takes[c] == nil {
+			takes[c] = make(map[string]int)
+		}
+		takes[c][e.Namespace]++
+	}
+	return true
+}
+
+func (s *Sampler) maybeSweep(now time.Time) {
+	if now.Sub(s.lastSweep) >= RATE_CAP_SWEEP_INTERVAL {
+		for _, c := range s.caps {
+			c.sweep(now)
+		}
+		s.lastSweep = now
+	}
+}
+
+// Allow returns true if the envelope is within every matching rate cap.
+func (s *Sampler) Allow(e *envelope.Envelope) bool {
+	if len(s.caps) == 0 {
+		return true
+	}
+	s.mu.Lock()
+	now := s.now()
+	s.maybeSweep(now)
+	r := Reservation{s: s, takes: make(map[*rateCap]map[string]int)}
+	allowed := s.reserve(e, now, r.takes)
+	s.mu.Unlock()
+	r.Commit()
+	return allowed
+}
+
+// Reserve splits envelopes into those which should be published to the sink
+// and those which were sampled out or exceeded a rate cap, without taking
+// rate cap tokens until the returned reservation is committed.
+func (s *Sampler) Reserve(envelopes []envelope.Envelope) (kept []envelope.Envelope, dropped []envelope.Envelope, r *Reservation) {
+	if s.rate >= 1 && len(s.caps) == 0 {
+		return envelopes, nil, nil
+	}
+	r = &Reservation{s: s, takes: make(map[*rateCap]map[string]int)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.maybeSweep(now)
+	for i := range envelopes {
+		if s.Keep(&envelopes[i]) && s.reserve(&envelopes[i], now, r.takes) {
+			kept = append(kept, envelopes[i])
+		} else {
+			dropped = append(dropped, envelopes[i])
+		}
+	}
+	return kept, dropped, r
+}
+
+// Sample splits envelopes into those which should be published to the sink
+// and those which were sampled out or exceeded a rate cap.
+func (s *Sampler) Sample(envelopes []envelope.Envelope) (kept []envelope.Envelope, dropped []envelope.Envelope) {
+	kept, dropped, r := s.Reserve(envelopes)
+	r.Commit()
+	return kept, dropped
+}
+
+// BuildSamplers builds a sampler for every configured sink, keyed by sink name.
+func BuildSamplers(conf []config.Sink) (map[string]*Sampler, error) {
+	samplers := make(map[string]*Sampler)
+	for _, sConf := range conf {
+		s, err := BuildSampler(sConf.Sampling, sConf.RateCaps)
+		if err != nil {
+			log.Error().Err(err).Interface("sink", sConf.Name).Msg("🔴 invalid sampling configuration")
+			return nil, err
+		}
+		samplers[sConf.Name] = s
+	}
+	return samplers, nil
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package router
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplerIsDeterministicPerKey(t *testing.T) {
+	s, err := BuildSampler(config.Sampling{Enabled: true, Rate: 0.5, Key: "device.id"}, nil)
+	assert.Nil(t, err)
+	var envelopes []envelope.Envelope
+	for i := 0; i < 1000; i++ {
+		e := buildEnvelope(protocol.PIXEL, "", fmt.Sprintf("device-%d", i%100))
+		e.Uuid = uuid.New()
+		envelopes = append(envelopes, e)
+	}
+	kept, dropped := s.Sample(envelopes)
+	assert.Equal(t, 1000, len(kept)+len(dropped))
+	assert.InDelta(t, 500, len(kept), 200)
+
+	// Every envelope for a device is kept or dropped together
+	keptDevices := make(map[string]bool)
+	for _, e := range kept {
+		keptDevices[e.Device.Id] = true
+	}
+	for _, e := range dropped {
+		assert.False(t, keptDevices[e.Device.Id])
+	}
+}
+
+func TestSamplerNamespace(t *testing.T) {
+	s, _ := BuildSampler(config.Sampling{Enabled: true, Rate: 0, Namespace: "noisy.*"}, nil)
+	noisy := buildEnvelope(protocol.PIXEL, "", "a")
+	noisy.Namespace = "noisy.heartbeat"
+	quiet := buildEnvelope(protocol.PIXEL, "", "a")
+	quiet.Namespace = "checkout"
+	assert.False(t, s.Keep(&noisy))
+	assert.True(t, s.Keep(&quiet))
+}
+
+func TestSamplerRateCaps(t *testing.T) {
+	s, err := BuildSampler(config.Sampling{}, []config.RateCap{{Namespace: "page*", EventsPerSecond: 2}})
+	assert.Nil(t, err)
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+
+	pageView := buildEnvelope(protocol.PIXEL, "", "a")
+	pageView.Namespace = "page_view"
+	pagePing := buildEnvelope(protocol.PIXEL, "", "a")
+	pagePing.Namespace = "page_ping"
+	other := buildEnvelope(protocol.PIXEL, "", "a")
+	other.Namespace = "other"
+
+	kept, dropped := s.Sample([]envelope.Envelope{pageView, pageView, pageView, pagePing, other, other, other})
+	// Every matching namespace is capped independently
+	assert.Len(t, kept, 6)
+	assert.Len(t, dropped, 1)
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, s.Allow(&pageView))
+	assert.False(t, s.Allow(&pageView))
+}
+
+func TestSamplerReserve(t *testing.T) {
+	s, err := BuildSampler(config.Sampling{}, []config.RateCap{{EventsPerSecond: 2}})
+	assert.Nil(t, err)
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+	e := buildEnvelope(protocol.PIXEL, "", "a")
+	e.Namespace = "page_view"
+
+	// Reservations which are never committed take no tokens
+	kept, dropped, _ := s.Reserve([]envelope.Envelope{e, e, e})
+	assert.Len(t, kept, 2)
+	assert.Len(t, dropped, 1)
+	kept, _, r := s.Reserve([]envelope.Envelope{e, e})
+	assert.Len(t, kept, 2)
+
+	r.Commit()
+	kept, _, _ = s.Reserve([]envelope.Envelope{e})
+	assert.Len(t, kept, 0)
+}
+
+func TestSamplerKeyResolver(t *testing.T) {
+	userId, sessionId := "user-1", "session-1"
+	e := buildEnvelope(protocol.PIXEL, "", "device-1")
+	e.User, e.Session = &envelope.User{Id: &userId}, &envelope.Session{Id: &sessionId}
+	e.Payload = map[string]interface{}{"cart": map[string]interface{}{"id": float64(7)}, "a.b": "escaped"}
+	e.Contexts = &map[string]interface{}{"com.acme/ctx/v1.0.json": map[string]interface{}{"id": "ctx-1"}}
+
+	var testCases = []struct {
+		key  string
+		want interface{}
+	}{
+		{"device.id", "device-1"},
+		{"user.id", userId},
+		{"session.id", sessionId},
+		{"payload.cart.id", float64(7)},
+		{`payload.a\.b`, "escaped"},
+		{`contexts.com\.acme/ctx/v1\.0\.json.id`, "ctx-1"},
+		{"device.ip", ""}, // Resolved from the whole envelope
+	}
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			v, ok := keyResolver(tc.key)(&e)
+			assert.True(t, ok)
+			assert.Equal(t, tc.want, v)
+		})
+	}
+	_, ok := keyResolver("user.id")(&envelope.Envelope{})
+	assert.False(t, ok)
+}
+
+func TestSamplerSweepsIdleBuckets(t *testing.T) {
+	s, err := BuildSampler(config.Sampling{}, []config.RateCap{{EventsPerSecond: 1}})
+	assert.Nil(t, err)
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+
+	e := buildEnvelope(protocol.PIXEL, "", "a")
+	for i := 0; i < 100; i++ {
+		e.Namespace = fmt.Sprintf("namespace-%d", i)
+		assert.True(t, s.Allow(&e))
+	}
+	assert.Len(t, s.caps[0].buckets, 100)
+
+	now = now.Add(2 * time.Second)
+	assert.True(t, s.Allow(&e))
+	assert.Len(t, s.caps[0].buckets, 1)
+}
+
+func TestInvalidSampling(t *testing.T) {
+	_, err := BuildSampler(config.Sampling{Enabled: true, Rate: 1.5}, nil)
+	assert.NotNil(t, err)
+	_, err = BuildSampler(config.Sampling{}, []config.RateCap{{Namespace: "*"}})
+	assert.NotNil(t, err)
+}
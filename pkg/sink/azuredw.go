@@ -4,4 +4,179 @@
 
 package sink
 
-// FIXME! Implement azure dw sink
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/db"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const DEFAULT_AZUREDW_PORT uint16 = 1433
+
+// Every column mirrors a field of envelope.StringEnvelope, stored as JSON text.
+func azureDwColumns() []string {
+	return jsonColumns(envelope.StringEnvelope{})
+}
+
+// Bracket-quote each part of a (possibly schema-qualified) table name.
+func quoteSqlServerIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = "[" + part + "]"
+	}
+	return strings.Join(parts, ".")
+}
+
+func azureDwCreateTableStatement(table string) string {
+	var columns []string
+	for _, column := range azureDwColumns() {
+		columns = append(columns, "["+column+"] NVARCHAR(MAX)")
+	}
+	// Synapse doesn't support CREATE TABLE IF NOT EXISTS
+	return "IF OBJECT_ID(N'" + table + "', N'U') IS NULL CREATE TABLE " + quoteSqlServerIdentifier(table) + " (" + strings.Join(columns, ", ") + ")"
+}
+
+// The values of an envelope's columns, in column order. Absent fields are null.
+func azureDwRow(e envelope.Envelope, columns []string) ([]interface{}, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	row := make([]interface{}, len(columns))
+	for i, column := range columns {
+		value, ok := fields[column]
+		if !ok || string(value) == "null" {
+			continue
+		}
+		row[i] = string(value)
+	}
+	return row, nil
+}
+
+// A sink which bulk loads envelopes into Azure Synapse Analytics (SQL DW)
+// or SQL Server, using the TDS bulk copy protocol.
+type AzureDwSink struct {
+	id               *uuid.UUID
+	name             string
+	deliveryRequired bool
+	db               *sql.DB
+	validTable       string
+	invalidTable     string
+	columns          []string
+}
+
+func (s *AzureDwSink) Id() *uuid.UUID {
+	return s.id
+}
+
+func (s *AzureDwSink) Name() string {
+	return s.name
+}
+
+func (s *AzureDwSink) Type() string {
+	return db.AZUREDW
+}
+
+func (s *AzureDwSink) DeliveryRequired() bool {
+	return s.deliveryRequired
+}
+
+func (s *AzureDwSink) Initialize(conf config.Sink) error {
+	log.Debug().Msg("🟡 initializing azure dw sink")
+	if conf.ValidTable == "" || conf.InvalidTable == "" {
+		return errors.New("azure dw sink requires a validTable and invalidTable")
+	}
+	for _, tbl := range []string{conf.ValidTable, conf.InvalidTable} {
+		if !sqlTableName.MatchString(tbl) {
+			return errors.New("invalid azure dw table name: " + tbl)
+		}
+	}
+	port := conf.AzureDwPort
+	if port == 0 {
+		port = DEFAULT_AZUREDW_PORT
+	}
+	connParams := db.ConnectionParams{
+		Host: conf.AzureDwHost,
+		Port: port,
+		Db:   conf.AzureDwDbName,
+		User: conf.AzureDwUser,
+		Pass: conf.AzureDwPass,
+	}
+	sqlDb, err := sql.Open("sqlserver", db.GenerateSqlServerDsn(connParams))
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not open azure dw connection")
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), INIT_TIMEOUT_SECONDS*time.Second)
+	defer cancel()
+	for _, tbl := range []string{conf.ValidTable, conf.InvalidTable} {
+		if _, err := sqlDb.ExecContext(ctx, azureDwCreateTableStatement(tbl)); err != nil {
+			log.Error().Err(err).Msg("🔴 could not ensure azure dw table " + tbl)
+			sqlDb.Close()
+			return err
+		}
+	}
+	id := uuid.New()
+	s.id, s.name, s.deliveryRequired = &id, conf.Name, conf.DeliveryRequired
+	s.db, s.validTable, s.invalidTable = sqlDb, conf.ValidTable, conf.InvalidTable
+	s.columns = azureDwColumns()
+	return nil
+}
+
+func (s *AzureDwSink) batchPublish(ctx context.Context, table string, envelopes []envelope.Envelope) error {
+	txn, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	stmt, err := txn.PrepareContext(ctx, mssql.CopyIn(quoteSqlServerIdentifier(table), mssql.BulkOptions{}, s.columns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range envelopes {
+		row, err := azureDwRow(e, s.columns)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	// Executing without arguments flushes the bulk copy
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		log.Error().Err(err).Msg("🔴 could not bulk insert into azure dw table " + table)
+		return err
+	}
+	return txn.Commit()
+}
+
+func (s *AzureDwSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.validTable, envelopes)
+	return err
+}
+
+func (s *AzureDwSink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.invalidTable, envelopes)
+	return err
+}
+
+func (s *AzureDwSink) Close() {
+	log.Debug().Msg("🟡 closing azure dw sink")
+	if s.db != nil {
+		s.db.Close()
+	}
+}
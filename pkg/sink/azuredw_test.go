@@ -0,0 +1,34 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureDwCreateTableStatement(t *testing.T) {
+	assert.Equal(t,
+		"IF OBJECT_ID(N'dbo.events', N'U') IS NULL CREATE TABLE [dbo].[events] ([event] NVARCHAR(MAX), [pipeline] NVARCHAR(MAX), [device] NVARCHAR(MAX), [user] NVARCHAR(MAX), [session] NVARCHAR(MAX), [web] NVARCHAR(MAX), [annotations] NVARCHAR(MAX), [enrichments] NVARCHAR(MAX), [validation] NVARCHAR(MAX), [contexts] NVARCHAR(MAX), [payload] NVARCHAR(MAX))",
+		azureDwCreateTableStatement("dbo.events"),
+	)
+}
+
+func TestAzureDwRow(t *testing.T) {
+	columns := azureDwColumns()
+	row, err := azureDwRow(buildBigqueryTestEnvelope(), columns)
+	assert.Nil(t, err)
+	assert.Equal(t, len(columns), len(row))
+	for i, column := range columns {
+		switch column {
+		case "payload":
+			assert.JSONEq(t, `{"url": "https://buz.dev"}`, row[i].(string))
+		case "user":
+			// Absent fields are left null
+			assert.Nil(t, row[i])
+		}
+	}
+}
@@ -4,4 +4,327 @@
 
 package sink
 
-// TODO! Implement me.
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	COLLECTOR_TSTAMP_COLUMN string = "collectorTstamp"
+	// AppendRows requests are capped at 10MB - leave headroom for request framing
+	BIGQUERY_MAX_APPEND_BYTES int = 9 * 1024 * 1024
+)
+
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*)?$`)
+
+// The json names of every top-level envelope field, each of which is stored in a JSON column.
+func envelopeColumns() []string {
+	return jsonColumns(envelope.Envelope{})
+}
+
+// The json names of every top-level field of a model.
+func jsonColumns(model interface{}) []string {
+	var columns []string
+	t := reflect.TypeOf(model)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+// A table schema derived from the envelope, partitioned by collector timestamp.
+func bigqueryEnvelopeSchema() bigquery.Schema {
+	schema := bigquery.Schema{
+		{Name: COLLECTOR_TSTAMP_COLUMN, Type: bigquery.TimestampFieldType, Required: true},
+	}
+	for _, column := range envelopeColumns() {
+		schema = append(schema, &bigquery.FieldSchema{Name: column, Type: bigquery.JSONFieldType})
+	}
+	return schema
+}
+
+// The proto descriptor of a table row, as required by the Storage Write API.
+// Timestamps are written as epoch microseconds and JSON columns as strings.
+func bigqueryRowDescriptor(schema bigquery.Schema) (protoreflect.MessageDescriptor, *descriptorpb.DescriptorProto, error) {
+	dp := &descriptorpb.DescriptorProto{Name: proto.String("Envelope")}
+	for i, field := range schema {
+		fdp := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(field.Name),
+			Number: proto.Int32(int32(i + 1)),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+		if field.Required {
+			fdp.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+		}
+		if field.Type == bigquery.TimestampFieldType {
+			fdp.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+		}
+		dp.Field = append(dp.Field, fdp)
+	}
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("envelope.proto"),
+		Syntax:      proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{dp},
+	}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fd.Messages().Get(0), dp, nil
+}
+
+// Encode an envelope as a serialized row message.
+func encodeBigqueryRow(md protoreflect.MessageDescriptor, e envelope.Envelope) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var columns map[string]json.RawMessage
+	if err := json.Unmarshal(b, &columns); err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	msg.Set(fields.ByName(protoreflect.Name(COLLECTOR_TSTAMP_COLUMN)), protoreflect.ValueOfInt64(e.Pipeline.Collector.Tstamp.UnixMicro()))
+	for name, value := range columns {
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil || string(value) == "null" {
+			continue
+		}
+		msg.Set(fd, protoreflect.ValueOfString(string(value)))
+	}
+	return proto.Marshal(msg)
+}
+
+type BigquerySink struct {
+	id               *uuid.UUID
+	name             string
+	deliveryRequired bool
+	client           *bigquery.Client
+	writer           *managedwriter.Client
+	validStream      *managedwriter.ManagedStream
+	invalidStream    *managedwriter.ManagedStream
+	rowDescriptor    protoreflect.MessageDescriptor
+}
+
+func (s *BigquerySink) Id() *uuid.UUID {
+	return s.id
+}
+
+func (s *BigquerySink) Name() string {
+	return s.name
+}
+
+func (s *BigquerySink) Type() string {
+	return BIGQUERY
+}
+
+func (s *BigquerySink) DeliveryRequired() bool {
+	return s.deliveryRequired
+}
+
+func ensureBigqueryTable(ctx context.Context, dataset *bigquery.Dataset, table string, schema bigquery.Schema) error {
+	t := dataset.Table(table)
+	_, err := t.Metadata(ctx)
+	if err == nil {
+		log.Debug().Msg("🟡 " + table + " table already exists - not creating")
+		return nil
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return err
+	}
+	log.Debug().Msg("🟡 " + table + " table doesn't exist - creating")
+	return t.Create(ctx, &bigquery.TableMetadata{
+		Schema: schema,
+		TimePartitioning: &bigquery.TimePartitioning{
+			Type:  bigquery.DayPartitioningType,
+			Field: COLLECTOR_TSTAMP_COLUMN,
+		},
+	})
+}
+
+func (s *BigquerySink) Initialize(conf config.Sink) error {
+	log.Debug().Msg("🟡 initializing bigquery sink")
+	if conf.Project == "" || conf.Dataset == "" || conf.ValidTable == "" || conf.InvalidTable == "" {
+		return errors.New("bigquery sink requires a project, dataset, validTable, and invalidTable")
+	}
+	var bqOpts, writerOpts []option.ClientOption
+	if conf.BigqueryEndpoint != "" {
+		bqOpts = append(bqOpts, option.WithEndpoint(conf.BigqueryEndpoint), option.WithoutAuthentication())
+	}
+	if conf.BigqueryStorageEndpoint != "" {
+		writerOpts = append(writerOpts,
+			option.WithEndpoint(conf.BigqueryStorageEndpoint),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+	}
+	initCtx, cancel := context.WithTimeout(context.Background(), INIT_TIMEOUT_SECONDS*time.Second)
+	defer cancel()
+	client, err := bigquery.NewClient(initCtx, conf.Project, bqOpts...)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not create bigquery client")
+		return err
+	}
+	schema := bigqueryEnvelopeSchema()
+	dataset := client.Dataset(conf.Dataset)
+	for _, tbl := range []string{conf.ValidTable, conf.InvalidTable} {
+		if err := ensureBigqueryTable(initCtx, dataset, tbl, schema); err != nil {
+			log.Error().Err(err).Msg("🔴 could not ensure bigquery table " + tbl)
+			client.Close()
+			return err
+		}
+	}
+	md, dp, err := bigqueryRowDescriptor(schema)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	// Streams live as long as the sink, so they must not use the init context
+	ctx := context.Background()
+	writer, err := managedwriter.NewClient(ctx, conf.Project, writerOpts...)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not create bigquery storage write client")
+		client.Close()
+		return err
+	}
+	var streams []*managedwriter.ManagedStream
+	for _, tbl := range []string{conf.ValidTable, conf.InvalidTable} {
+		stream, err := writer.NewManagedStream(ctx,
+			managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(conf.Project, conf.Dataset, tbl)),
+			managedwriter.WithType(managedwriter.DefaultStream),
+			managedwriter.WithSchemaDescriptor(dp),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not open bigquery write stream for " + tbl)
+			writer.Close()
+			client.Close()
+			return err
+		}
+		streams = append(streams, stream)
+	}
+	id := uuid.New()
+	s.id, s.name, s.deliveryRequired = &id, conf.Name, conf.DeliveryRequired
+	s.client, s.writer, s.rowDescriptor = client, writer, md
+	s.validStream, s.invalidStream = streams[0], streams[1]
+	return nil
+}
+
+// Split encoded rows into appends that each stay under maxBytes.
+// A row larger than maxBytes is sent on its own so the api can reject it.
+func chunkBigqueryRows(rows [][]byte, maxBytes int) [][][]byte {
+	var chunks [][][]byte
+	var chunk [][]byte
+	size := 0
+	for _, row := range rows {
+		if len(chunk) > 0 && size+len(row) > maxBytes {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, row)
+		size += len(row)
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// Log each row rejected by an append and summarize them as a single error.
+// Row indexes are relative to the append, which starts at offset within envelopes.
+func bigqueryRowErrors(rowErrors []*storagepb.RowError, envelopes []envelope.Envelope, offset int) error {
+	if len(rowErrors) == 0 {
+		return nil
+	}
+	for _, rowErr := range rowErrors {
+		idx := offset + int(rowErr.GetIndex())
+		l := log.Error().Int("row", idx).Str("code", rowErr.GetCode().String())
+		if idx >= 0 && idx < len(envelopes) {
+			l = l.Str("uuid", envelopes[idx].Uuid.String())
+		}
+		l.Msg("🔴 bigquery rejected row: " + rowErr.GetMessage())
+	}
+	first := rowErrors[0]
+	return fmt.Errorf("bigquery rejected %d rows, first at row %d: %s", len(rowErrors), offset+int(first.GetIndex()), first.GetMessage())
+}
+
+func (s *BigquerySink) batchPublish(ctx context.Context, stream *managedwriter.ManagedStream, envelopes []envelope.Envelope) error {
+	var rows [][]byte
+	for _, e := range envelopes {
+		row, err := encodeBigqueryRow(s.rowDescriptor, e)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	var results []*managedwriter.AppendResult
+	var offsets []int
+	offset := 0
+	for _, chunk := range chunkBigqueryRows(rows, BIGQUERY_MAX_APPEND_BYTES) {
+		result, err := stream.AppendRows(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+		offsets = append(offsets, offset)
+		offset += len(chunk)
+	}
+	var publishErr error
+	for i, result := range results {
+		resp, err := result.FullResponse(ctx)
+		if rowErr := bigqueryRowErrors(resp.GetRowErrors(), envelopes, offsets[i]); rowErr != nil {
+			err = rowErr
+		}
+		if err != nil && publishErr == nil {
+			publishErr = err
+		}
+	}
+	return publishErr
+}
+
+func (s *BigquerySink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.validStream, envelopes)
+	return err
+}
+
+func (s *BigquerySink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.invalidStream, envelopes)
+	return err
+}
+
+func (s *BigquerySink) Close() {
+	log.Debug().Msg("🟡 closing bigquery sink")
+	s.validStream.Close()
+	s.invalidStream.Close()
+	s.writer.Close()
+	s.client.Close()
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/uuid"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/event"
+	"github.com/stretchr/testify/assert"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func buildBigqueryTestEnvelope() envelope.Envelope {
+	return envelope.Envelope{
+		EventMeta: envelope.EventMeta{Uuid: uuid.New(), Namespace: "page_view"},
+		Pipeline: envelope.Pipeline{
+			Collector: envelope.Collector{Tstamp: time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)},
+		},
+		Payload: event.Payload{"url": "https://buz.dev"},
+	}
+}
+
+func TestBigqueryEnvelopeSchema(t *testing.T) {
+	schema := bigqueryEnvelopeSchema()
+	assert.Equal(t, COLLECTOR_TSTAMP_COLUMN, schema[0].Name)
+	assert.Equal(t, bigquery.TimestampFieldType, schema[0].Type)
+	var columns []string
+	for _, f := range schema[1:] {
+		assert.Equal(t, bigquery.JSONFieldType, f.Type)
+		columns = append(columns, f.Name)
+	}
+	assert.Equal(t, []string{"event", "pipeline", "device", "user", "session", "web", "annotations", "enrichments", "validation", "contexts", "payload"}, columns)
+}
+
+func TestEncodeBigqueryRow(t *testing.T) {
+	md, _, err := bigqueryRowDescriptor(bigqueryEnvelopeSchema())
+	assert.Nil(t, err)
+	e := buildBigqueryTestEnvelope()
+	b, err := encodeBigqueryRow(md, e)
+	assert.Nil(t, err)
+
+	row := dynamicpb.NewMessage(md)
+	assert.Nil(t, proto.Unmarshal(b, row))
+	fields := md.Fields()
+	assert.Equal(t, e.Pipeline.Collector.Tstamp.UnixMicro(), row.Get(fields.ByName(protoreflect.Name(COLLECTOR_TSTAMP_COLUMN))).Int())
+	assert.JSONEq(t, `{"url": "https://buz.dev"}`, row.Get(fields.ByName("payload")).String())
+	// Absent fields are left null
+	assert.False(t, row.Has(fields.ByName("user")))
+}
+
+// Runs against a local emulator such as https://github.com/goccy/bigquery-emulator, started with
+// `bigquery-emulator --project=buz --dataset=buz`
+func TestBigquerySinkEmulator(t *testing.T) {
+	endpoint, storageEndpoint := os.Getenv("BIGQUERY_EMULATOR_HOST"), os.Getenv("BIGQUERY_STORAGE_EMULATOR_HOST")
+	if endpoint == "" || storageEndpoint == "" {
+		t.Skip("BIGQUERY_EMULATOR_HOST and BIGQUERY_STORAGE_EMULATOR_HOST are not set")
+	}
+	conf := config.Sink{
+		Name:                    "bigquery",
+		Type:                    BIGQUERY,
+		Project:                 "buz",
+		Dataset:                 "buz",
+		ValidTable:              "events",
+		InvalidTable:            "invalid_events",
+		BigqueryEndpoint:        "http://" + endpoint,
+		BigqueryStorageEndpoint: storageEndpoint,
+	}
+	s := BigquerySink{}
+	assert.Nil(t, s.Initialize(conf))
+	defer s.Close()
+	assert.Nil(t, s.BatchPublishValid(context.Background(), []envelope.Envelope{buildBigqueryTestEnvelope()}))
+	assert.Nil(t, s.BatchPublishInvalid(context.Background(), []envelope.Envelope{buildBigqueryTestEnvelope()}))
+}
+
+func TestChunkBigqueryRows(t *testing.T) {
+	row := func(n int) []byte { return make([]byte, n) }
+	chunks := chunkBigqueryRows([][]byte{row(4), row(4), row(3), row(12), row(1)}, 10)
+	var sizes [][]int
+	for _, chunk := range chunks {
+		var s []int
+		for _, r := range chunk {
+			s = append(s, len(r))
+		}
+		sizes = append(sizes, s)
+	}
+	// Oversized rows get their own append
+	assert.Equal(t, [][]int{{4, 4}, {3}, {12}, {1}}, sizes)
+	assert.Nil(t, chunkBigqueryRows(nil, 10))
+}
+
+func TestBigqueryRowErrors(t *testing.T) {
+	envs := []envelope.Envelope{buildBigqueryTestEnvelope(), buildBigqueryTestEnvelope(), buildBigqueryTestEnvelope()}
+	assert.Nil(t, bigqueryRowErrors(nil, envs, 0))
+	err := bigqueryRowErrors([]*storagepb.RowError{
+		{Index: 0, Code: storagepb.RowError_FIELDS_ERROR, Message: "bad field"},
+		{Index: 1, Code: storagepb.RowError_FIELDS_ERROR, Message: "worse field"},
+	}, envs, 1)
+	assert.EqualError(t, err, "bigquery rejected 2 rows, first at row 1: bad field")
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	BREAKER_CLOSED    string = "closed"
+	BREAKER_OPEN      string = "open"
+	BREAKER_HALF_OPEN string = "half-open"
+)
+
+var ErrBreakerOpen = errors.New("sink circuit breaker is open")
+
+// A consecutive-failure circuit breaker.
+// The breaker opens after `threshold` consecutive failures and rejects calls
+// until `cooldown` has elapsed. It then lets a single probe through (half-open):
+// a successful probe closes the breaker, a failed one re-opens it.
+// A threshold of zero disables the breaker.
+type circuitBreaker struct {
+	mu                  sync.Mutex
+	threshold           int
+	cooldown            time.Duration
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	now                 func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BREAKER_CLOSED,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a call may proceed.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BREAKER_OPEN:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BREAKER_HALF_OPEN
+		return true
+	case BREAKER_HALF_OPEN:
+		return false // A probe is already in flight
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state, b.consecutiveFailures = BREAKER_CLOSED, 0
+}
+
+func (b *circuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutiveFailures++
+	if b.threshold <= 0 {
+		return
+	}
+	if b.state == BREAKER_HALF_OPEN || b.consecutiveFailures >= b.threshold {
+		b.state, b.openedAt = BREAKER_OPEN, b.now()
+	}
+}
+
+func (b *circuitBreaker) State() (state string, consecutiveFailures int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.consecutiveFailures
+}
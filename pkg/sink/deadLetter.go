@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+// DeadLetterSink wraps a sink and routes batches it fails to publish
+// to another sink, annotated with the reason for the failure.
+// A failed batch is only reported as failed if dead-lettering it fails too.
+type DeadLetterSink struct {
+	Sink
+	deadLetter Sink
+}
+
+func NewDeadLetterSink(s Sink, deadLetter Sink) *DeadLetterSink {
+	return &DeadLetterSink{Sink: s, deadLetter: deadLetter}
+}
+
+// AnnotateDeadLetter annotates copies of the envelopes with why they were dead-lettered,
+// leaving the originals (which are shared across sinks) untouched.
+func AnnotateDeadLetter(envelopes []envelope.Envelope, deadLetter envelope.DeadLetter) []envelope.Envelope {
+	var wrapped []envelope.Envelope
+	for _, e := range envelopes {
+		annotations := envelope.Annotations{}
+		if e.Annotations != nil {
+			annotations = *e.Annotations
+		}
+		annotations.DeadLetter = &deadLetter
+		e.Annotations = &annotations
+		wrapped = append(wrapped, e)
+	}
+	return wrapped
+}
+
+func (s *DeadLetterSink) wrap(envelopes []envelope.Envelope, publishErr error) []envelope.Envelope {
+	attempts := 1
+	var pErr *PublishError
+	if errors.As(publishErr, &pErr) {
+		attempts = pErr.Attempts
+	}
+	return AnnotateDeadLetter(envelopes, envelope.DeadLetter{
+		Sink:     s.Name(),
+		SinkType: s.Type(),
+		Error:    publishErr.Error(),
+		Attempts: attempts,
+		Tstamp:   time.Now().UTC(),
+	})
+}
+
+func (s *DeadLetterSink) publish(ctx context.Context, publishFn func(context.Context, []envelope.Envelope) error, deadLetterFn func(context.Context, []envelope.Envelope) error, envelopes []envelope.Envelope) error {
+	err := publishFn(ctx, envelopes)
+	if err == nil {
+		return nil
+	}
+	if dlErr := deadLetterFn(ctx, s.wrap(envelopes, err)); dlErr != nil {
+		log.Error().Err(dlErr).Interface("sinkName", s.Name()).Interface("deadLetterSinkName", s.deadLetter.Name()).Msg("🔴 could not dead-letter envelopes")
+		return err
+	}
+	log.Warn().Err(err).Interface("sinkName", s.Name()).Interface("deadLetterSinkName", s.deadLetter.Name()).Int("count", len(envelopes)).Msg("🟡 dead-lettered envelopes")
+	return nil
+}
+
+func (s *DeadLetterSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	return s.publish(ctx, s.Sink.BatchPublishValid, s.deadLetter.BatchPublishValid, envelopes)
+}
+
+func (s *DeadLetterSink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	return s.publish(ctx, s.Sink.BatchPublishInvalid, s.deadLetter.BatchPublishInvalid, envelopes)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+type capturingSink struct {
+	BlackholeSink
+	valid []envelope.Envelope
+}
+
+func (s *capturingSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	s.valid = append(s.valid, envelopes...)
+	return nil
+}
+
+func TestDeadLetterSink(t *testing.T) {
+	failing := NewRetrySink(buildFlakySink(10), config.Retry{MaxAttempts: 2, InitialBackoffMs: 1}, stats.BuildSinkStats())
+	dl := capturingSink{}
+	dl.Initialize(config.Sink{Name: "dlq"})
+	s := NewDeadLetterSink(failing, &dl)
+
+	envelopes := []envelope.Envelope{{}, {}}
+	err := s.BatchPublishValid(context.Background(), envelopes)
+	assert.Nil(t, err)
+	assert.Len(t, dl.valid, 2)
+
+	deadLetter := dl.valid[0].Annotations.DeadLetter
+	assert.Equal(t, "flaky", deadLetter.Sink)
+	assert.Equal(t, BLACKHOLE, deadLetter.SinkType)
+	assert.Equal(t, 2, deadLetter.Attempts)
+	assert.Contains(t, deadLetter.Error, "flaky")
+	// The original envelopes are shared with other sinks and must be untouched
+	assert.Nil(t, envelopes[0].Annotations)
+}
+
+func TestBuildAndInitializeSinksDeadLetter(t *testing.T) {
+	ss := stats.BuildSinkStats()
+	conf := []config.Sink{
+		{Name: "primary", Type: BLACKHOLE, DeadLetter: "dlq"},
+		{Name: "dlq", Type: BLACKHOLE},
+	}
+	sinks, err := BuildAndInitializeSinks(conf, ss)
+	assert.Nil(t, err)
+	assert.IsType(t, &DeadLetterSink{}, sinks[0])
+	assert.IsType(t, &MeteredSink{}, sinks[1])
+
+	conf[0].DeadLetter = "missing"
+	_, err = BuildAndInitializeSinks(conf, ss)
+	assert.NotNil(t, err)
+
+	conf[0].DeadLetter = "primary"
+	_, err = BuildAndInitializeSinks(conf, ss)
+	assert.NotNil(t, err)
+
+	conf[0].DeadLetter, conf[1].Name = "", "primary"
+	_, err = BuildAndInitializeSinks(conf, ss)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"context"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/metrics"
+	"github.com/silverton-io/buz/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MeteredSink wraps a sink, recording publish latency, results, and batch sizes,
+// and tracing each publish.
+type MeteredSink struct {
+	Sink
+}
+
+func NewMeteredSink(s Sink) *MeteredSink {
+	return &MeteredSink{Sink: s}
+}
+
+func (s *MeteredSink) observe(ctx context.Context, validity string, envelopes []envelope.Envelope, fn func(ctx context.Context) error) error {
+	ctx, span := tracing.StartBatch(ctx, tracing.SINK_PUBLISH, envelopes,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("buz.sink.name", s.Name()),
+			attribute.String("buz.sink.type", s.Type()),
+			attribute.String("buz.validity", validity),
+			attribute.Int("buz.envelopes", len(envelopes)),
+		),
+	)
+	defer span.End()
+	start := time.Now()
+	err := fn(ctx)
+	tracing.RecordError(span, err)
+	metrics.SinkPublishDuration.WithLabelValues(s.Name(), s.Type(), validity).Observe(time.Since(start).Seconds())
+	metrics.SinkBatchSize.WithLabelValues(s.Name(), s.Type(), validity).Observe(float64(len(envelopes)))
+	result := metrics.SUCCESS
+	if err != nil {
+		result = metrics.FAILURE
+	}
+	metrics.SinkPublishes.WithLabelValues(s.Name(), s.Type(), validity, result).Inc()
+	return err
+}
+
+func (s *MeteredSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	return s.observe(ctx, metrics.VALID, envelopes, func(ctx context.Context) error { return s.Sink.BatchPublishValid(ctx, envelopes) })
+}
+
+func (s *MeteredSink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	return s.observe(ctx, metrics.INVALID, envelopes, func(ctx context.Context) error { return s.Sink.BatchPublishInvalid(ctx, envelopes) })
+}
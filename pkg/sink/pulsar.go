@@ -3,3 +3,167 @@
 // which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
 
 package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+func pulsarCompressionType(compression string) (pulsar.CompressionType, error) {
+	switch compression {
+	case "", "none":
+		return pulsar.NoCompression, nil
+	case "lz4":
+		return pulsar.LZ4, nil
+	case "zlib":
+		return pulsar.ZLib, nil
+	case "zstd":
+		return pulsar.ZSTD, nil
+	}
+	return pulsar.NoCompression, errors.New("unsupported pulsar compression: " + compression)
+}
+
+// The same metadata the kafka sink sets as record headers.
+func pulsarProperties(e envelope.Envelope) map[string]string {
+	return map[string]string{
+		envelope.INPUT_PROTOCOL: e.EventMeta.Protocol,
+		envelope.VENDOR:         e.EventMeta.Vendor,
+		envelope.NAMESPACE:      e.EventMeta.Namespace,
+		envelope.VERSION:        e.EventMeta.Version,
+		envelope.FORMAT:         e.EventMeta.Format,
+		envelope.SCHEMA:         e.EventMeta.Schema,
+	}
+}
+
+type PulsarSink struct {
+	id               *uuid.UUID
+	name             string
+	deliveryRequired bool
+	client           pulsar.Client
+	validProducer    pulsar.Producer
+	invalidProducer  pulsar.Producer
+}
+
+func (s *PulsarSink) Id() *uuid.UUID {
+	return s.id
+}
+
+func (s *PulsarSink) Name() string {
+	return s.name
+}
+
+func (s *PulsarSink) Type() string {
+	return PULSAR
+}
+
+func (s *PulsarSink) DeliveryRequired() bool {
+	return s.deliveryRequired
+}
+
+func (s *PulsarSink) Initialize(conf config.Sink) error {
+	log.Debug().Msg("🟡 initializing pulsar sink")
+	if conf.PulsarUrl == "" || conf.ValidTopic == "" || conf.InvalidTopic == "" {
+		return errors.New("pulsar sink requires a pulsarUrl, validTopic, and invalidTopic")
+	}
+	compression, err := pulsarCompressionType(conf.Compression)
+	if err != nil {
+		return err
+	}
+	opts := pulsar.ClientOptions{
+		URL:               conf.PulsarUrl,
+		ConnectionTimeout: INIT_TIMEOUT_SECONDS * time.Second,
+	}
+	if conf.PulsarToken != "" {
+		opts.Authentication = pulsar.NewAuthenticationToken(conf.PulsarToken)
+	}
+	client, err := pulsar.NewClient(opts)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not create pulsar client")
+		return err
+	}
+	var producers []pulsar.Producer
+	for _, topic := range []string{conf.ValidTopic, conf.InvalidTopic} {
+		producer, err := client.CreateProducer(pulsar.ProducerOptions{
+			Topic:                   topic,
+			CompressionType:         compression,
+			DisableBatching:         conf.DisableBatching,
+			BatchingMaxMessages:     conf.BatchMaxMessages,
+			BatchingMaxPublishDelay: time.Duration(conf.BatchMaxDelayMs) * time.Millisecond,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not create pulsar producer for topic " + topic)
+			for _, p := range producers {
+				p.Close()
+			}
+			client.Close()
+			return err
+		}
+		producers = append(producers, producer)
+	}
+	id := uuid.New()
+	s.id, s.name, s.deliveryRequired = &id, conf.Name, conf.DeliveryRequired
+	s.client, s.validProducer, s.invalidProducer = client, producers[0], producers[1]
+	return nil
+}
+
+func (s *PulsarSink) batchPublish(ctx context.Context, producer pulsar.Producer, envelopes []envelope.Envelope) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var publishErr error
+	for _, e := range envelopes {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msg := &pulsar.ProducerMessage{
+			Payload:    payload,
+			Key:        e.EventMeta.Namespace,
+			Properties: pulsarProperties(e),
+		}
+		wg.Add(1)
+		producer.SendAsync(ctx, msg, func(id pulsar.MessageID, m *pulsar.ProducerMessage, err error) {
+			defer wg.Done()
+			if err != nil {
+				log.Error().Err(err).Msg("🔴 could not publish message to " + producer.Topic())
+				mu.Lock()
+				publishErr = err
+				mu.Unlock()
+			}
+		})
+	}
+	// Send the last batch now, rather than waiting out the batching delay
+	if err := producer.Flush(); err != nil {
+		log.Error().Err(err).Msg("🔴 could not flush pulsar producer")
+	}
+	wg.Wait()
+	return publishErr
+}
+
+func (s *PulsarSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.validProducer, envelopes)
+	return err
+}
+
+func (s *PulsarSink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.invalidProducer, envelopes)
+	return err
+}
+
+func (s *PulsarSink) Close() {
+	log.Debug().Msg("🟡 closing pulsar sink")
+	if s.client == nil {
+		return
+	}
+	s.validProducer.Close()
+	s.invalidProducer.Close()
+	s.client.Close()
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"testing"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPulsarCompressionType(t *testing.T) {
+	for compression, want := range map[string]pulsar.CompressionType{
+		"":     pulsar.NoCompression,
+		"none": pulsar.NoCompression,
+		"lz4":  pulsar.LZ4,
+		"zlib": pulsar.ZLib,
+		"zstd": pulsar.ZSTD,
+	} {
+		got, err := pulsarCompressionType(compression)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := pulsarCompressionType("snappy")
+	assert.NotNil(t, err)
+}
+
+func TestPulsarProperties(t *testing.T) {
+	e := envelope.Envelope{EventMeta: envelope.EventMeta{
+		Protocol:  "snowplow",
+		Vendor:    "com.acme",
+		Namespace: "page_view",
+		Version:   "1.0",
+		Format:    "jsonschema",
+		Schema:    "com.acme/page_view/1.0.json",
+	}}
+	assert.Equal(t, map[string]string{
+		envelope.INPUT_PROTOCOL: "snowplow",
+		envelope.VENDOR:         "com.acme",
+		envelope.NAMESPACE:      "page_view",
+		envelope.VERSION:        "1.0",
+		envelope.FORMAT:         "jsonschema",
+		envelope.SCHEMA:         "com.acme/page_view/1.0.json",
+	}, pulsarProperties(e))
+}
@@ -3,3 +3,274 @@
 // which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
 
 package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const (
+	DEFAULT_EXCHANGE_TYPE string = amqp.ExchangeTopic
+	DEFAULT_ROUTING_KEY   string = "{vendor}.{namespace}.{version}"
+)
+
+var (
+	ErrPublishNacked   = errors.New("publish was not confirmed by the broker")
+	ErrPublishReturned = errors.New("publish was returned by the broker as unroutable")
+)
+
+// Build a routing key from a template, replacing {protocol}, {vendor},
+// {namespace}, {version}, {format}, and {schema} with the envelope's metadata.
+func amqpRoutingKey(template string, e envelope.Envelope) string {
+	return strings.NewReplacer(
+		"{protocol}", e.EventMeta.Protocol,
+		"{vendor}", e.EventMeta.Vendor,
+		"{namespace}", e.EventMeta.Namespace,
+		"{version}", e.EventMeta.Version,
+		"{format}", e.EventMeta.Format,
+		"{schema}", e.EventMeta.Schema,
+	).Replace(template)
+}
+
+// The same metadata the kafka sink sets as record headers.
+func amqpHeaders(e envelope.Envelope) amqp.Table {
+	return amqp.Table{
+		envelope.INPUT_PROTOCOL: e.EventMeta.Protocol,
+		envelope.VENDOR:         e.EventMeta.Vendor,
+		envelope.NAMESPACE:      e.EventMeta.Namespace,
+		envelope.VERSION:        e.EventMeta.Version,
+		envelope.FORMAT:         e.EventMeta.Format,
+		envelope.SCHEMA:         e.EventMeta.Schema,
+	}
+}
+
+// A confirmation of a published message, such as *amqp.DeferredConfirmation.
+type amqpConfirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+// The parts of a confirming channel a batch is published with.
+type amqpPublisher interface {
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	publish(ctx context.Context, exchange string, key string, mandatory bool, msg amqp.Publishing) (amqpConfirmation, error)
+	Close() error
+}
+
+type amqpChannel struct {
+	*amqp.Channel
+}
+
+func (c amqpChannel) publish(ctx context.Context, exchange string, key string, mandatory bool, msg amqp.Publishing) (amqpConfirmation, error) {
+	return c.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, false, msg)
+}
+
+// A sink which publishes envelopes to RabbitMQ (or any AMQP 0-9-1 broker).
+//
+// Messages are published with publisher confirms, so a batch is only published once
+// every message in it has been acknowledged by the broker. When a queue is configured
+// for an exchange, messages to it are also published as mandatory, and any message
+// the broker can't route to a queue fails the batch. Without a queue, routing is left
+// to bindings made outside of buz, and unroutable messages are dropped by the broker.
+// Each batch uses its own channel so that returned messages can be attributed to it.
+// Exchanges and any configured queues are declared on initialization, and again on reconnection.
+//
+// NOTE! Failed batches are retried as a whole, so messages of a partially-routed batch may be duplicated.
+type RabbitmqSink struct {
+	id               *uuid.UUID
+	name             string
+	deliveryRequired bool
+	url              string
+	exchangeType     string
+	routingKey       string
+	validExchange    string
+	invalidExchange  string
+	validQueue       string
+	invalidQueue     string
+	mu               sync.Mutex
+	conn             *amqp.Connection
+	open             func() (amqpPublisher, error)
+}
+
+func (s *RabbitmqSink) Id() *uuid.UUID {
+	return s.id
+}
+
+func (s *RabbitmqSink) Name() string {
+	return s.name
+}
+
+func (s *RabbitmqSink) Type() string {
+	return RABBITMQ
+}
+
+func (s *RabbitmqSink) DeliveryRequired() bool {
+	return s.deliveryRequired
+}
+
+// Declare an exchange, and a queue bound to it if one is configured.
+func (s *RabbitmqSink) declare(ch *amqp.Channel, exchange string, queue string) error {
+	if err := ch.ExchangeDeclare(exchange, s.exchangeType, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if queue == "" {
+		return nil
+	}
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	// Topic exchanges need a wildcard to route everything to the queue, while other types ignore the key
+	bindingKey := ""
+	if s.exchangeType == amqp.ExchangeTopic {
+		bindingKey = "#"
+	}
+	return ch.QueueBind(queue, bindingKey, exchange, false, nil)
+}
+
+func (s *RabbitmqSink) connect() error {
+	conn, err := amqp.DialConfig(s.url, amqp.Config{Dial: amqp.DefaultDial(INIT_TIMEOUT_SECONDS * time.Second)})
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not connect to amqp broker")
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not open amqp channel")
+		conn.Close()
+		return err
+	}
+	defer ch.Close()
+	for _, d := range [][2]string{{s.validExchange, s.validQueue}, {s.invalidExchange, s.invalidQueue}} {
+		if err := s.declare(ch, d[0], d[1]); err != nil {
+			log.Error().Err(err).Msg("🔴 could not declare amqp exchange " + d[0])
+			conn.Close()
+			return err
+		}
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *RabbitmqSink) Initialize(conf config.Sink) error {
+	log.Debug().Msg("🟡 initializing rabbitmq sink")
+	if conf.AmqpUrl == "" || conf.ValidExchange == "" || conf.InvalidExchange == "" {
+		return errors.New("rabbitmq sink requires an amqpUrl, validExchange, and invalidExchange")
+	}
+	s.url = conf.AmqpUrl
+	s.validExchange, s.invalidExchange = conf.ValidExchange, conf.InvalidExchange
+	s.validQueue, s.invalidQueue = conf.ValidQueue, conf.InvalidQueue
+	s.exchangeType, s.routingKey = conf.ExchangeType, conf.RoutingKey
+	if s.exchangeType == "" {
+		s.exchangeType = DEFAULT_EXCHANGE_TYPE
+	}
+	if s.routingKey == "" {
+		s.routingKey = DEFAULT_ROUTING_KEY
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+	s.open = s.channel
+	id := uuid.New()
+	s.id, s.name, s.deliveryRequired = &id, conf.Name, conf.DeliveryRequired
+	return nil
+}
+
+// Open a confirming channel, reconnecting if the broker closed the connection.
+func (s *RabbitmqSink) channel() (amqpPublisher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil || s.conn.IsClosed() {
+		log.Warn().Msg("🟡 amqp connection closed - reconnecting")
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	ch, err := s.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		log.Error().Err(err).Msg("🔴 could not enable publisher confirms")
+		ch.Close()
+		return nil, err
+	}
+	return amqpChannel{ch}, nil
+}
+
+// Messages are only mandatory when buz declared a queue for them to be routed to.
+func (s *RabbitmqSink) batchPublish(ctx context.Context, exchange string, queue string, envelopes []envelope.Envelope) error {
+	ch, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	// Buffered so the broker's returns never block, and all of them have
+	// arrived by the time the corresponding confirms have
+	returns := ch.NotifyReturn(make(chan amqp.Return, len(envelopes)))
+	mandatory := queue != ""
+	var confirms []amqpConfirmation
+	for _, e := range envelopes {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		confirm, err := ch.publish(ctx, exchange, amqpRoutingKey(s.routingKey, e), mandatory, amqp.Publishing{
+			Headers:      amqpHeaders(e),
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    e.EventMeta.Uuid.String(),
+			Timestamp:    e.Pipeline.Collector.Tstamp,
+			Body:         payload,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not publish to amqp exchange " + exchange)
+			return err
+		}
+		confirms = append(confirms, confirm)
+	}
+	for _, confirm := range confirms {
+		acked, err := confirm.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !acked {
+			log.Error().Err(ErrPublishNacked).Msg("🔴 could not publish to amqp exchange " + exchange)
+			return ErrPublishNacked
+		}
+	}
+	select {
+	case r := <-returns:
+		log.Error().Err(ErrPublishReturned).Str("routingKey", r.RoutingKey).Msg("🔴 " + r.ReplyText + " on amqp exchange " + exchange)
+		return ErrPublishReturned
+	default:
+		return nil
+	}
+}
+
+func (s *RabbitmqSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.validExchange, s.validQueue, envelopes)
+	return err
+}
+
+func (s *RabbitmqSink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.invalidExchange, s.invalidQueue, envelopes)
+	return err
+}
+
+func (s *RabbitmqSink) Close() {
+	log.Debug().Msg("🟡 closing rabbitmq sink")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
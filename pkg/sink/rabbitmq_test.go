@@ -0,0 +1,126 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAmqpConfirmation struct {
+	acked bool
+	wait  time.Duration
+}
+
+func (c fakeAmqpConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	select {
+	case <-time.After(c.wait):
+		return c.acked, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// A channel which acks every message unless told otherwise, and returns
+// mandatory messages with an unroutable routing key, like a broker would.
+type fakeAmqpPublisher struct {
+	returns    chan amqp.Return
+	nack       bool
+	wait       time.Duration
+	unroutable string
+	mandatory  []bool
+}
+
+func (p *fakeAmqpPublisher) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	p.returns = c
+	return c
+}
+
+func (p *fakeAmqpPublisher) publish(ctx context.Context, exchange string, key string, mandatory bool, msg amqp.Publishing) (amqpConfirmation, error) {
+	p.mandatory = append(p.mandatory, mandatory)
+	if mandatory && key == p.unroutable {
+		p.returns <- amqp.Return{RoutingKey: key, ReplyText: "NO_ROUTE"}
+	}
+	return fakeAmqpConfirmation{acked: !p.nack, wait: p.wait}, nil
+}
+
+func (p *fakeAmqpPublisher) Close() error {
+	return nil
+}
+
+func buildTestRabbitmqSink(p *fakeAmqpPublisher, validQueue string) *RabbitmqSink {
+	return &RabbitmqSink{
+		name:            "rabbitmq",
+		routingKey:      "{namespace}",
+		validExchange:   "valid",
+		invalidExchange: "invalid",
+		validQueue:      validQueue,
+		open:            func() (amqpPublisher, error) { return p, nil },
+	}
+}
+
+func TestAmqpRoutingKey(t *testing.T) {
+	e := envelope.Envelope{EventMeta: envelope.EventMeta{
+		Protocol:  "snowplow",
+		Vendor:    "com.acme",
+		Namespace: "page_view",
+		Version:   "1.0",
+	}}
+	assert.Equal(t, "com.acme.page_view.1.0", amqpRoutingKey(DEFAULT_ROUTING_KEY, e))
+	assert.Equal(t, "events.snowplow.page_view", amqpRoutingKey("events.{protocol}.{namespace}", e))
+	assert.Equal(t, "static", amqpRoutingKey("static", e))
+}
+
+func TestAmqpHeaders(t *testing.T) {
+	e := envelope.Envelope{EventMeta: envelope.EventMeta{Vendor: "com.acme", Namespace: "page_view"}}
+	headers := amqpHeaders(e)
+	assert.Nil(t, headers.Validate())
+	assert.Equal(t, "com.acme", headers[envelope.VENDOR])
+	assert.Equal(t, "page_view", headers[envelope.NAMESPACE])
+}
+
+func TestRabbitmqPublishConfirms(t *testing.T) {
+	envelopes := []envelope.Envelope{{EventMeta: envelope.EventMeta{Namespace: "a"}}, {EventMeta: envelope.EventMeta{Namespace: "b"}}}
+
+	t.Run("acked", func(t *testing.T) {
+		p := &fakeAmqpPublisher{}
+		assert.Nil(t, buildTestRabbitmqSink(p, "").BatchPublishValid(context.Background(), envelopes))
+	})
+
+	t.Run("nacked", func(t *testing.T) {
+		p := &fakeAmqpPublisher{nack: true}
+		assert.ErrorIs(t, buildTestRabbitmqSink(p, "").BatchPublishValid(context.Background(), envelopes), ErrPublishNacked)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		p := &fakeAmqpPublisher{wait: time.Second}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, buildTestRabbitmqSink(p, "").BatchPublishValid(ctx, envelopes), context.DeadlineExceeded)
+	})
+}
+
+func TestRabbitmqPublishReturns(t *testing.T) {
+	envelopes := []envelope.Envelope{{EventMeta: envelope.EventMeta{Namespace: "a"}}, {EventMeta: envelope.EventMeta{Namespace: "b"}}}
+
+	// Without a queue, messages aren't mandatory and are never returned
+	p := &fakeAmqpPublisher{unroutable: "b"}
+	assert.Nil(t, buildTestRabbitmqSink(p, "").BatchPublishValid(context.Background(), envelopes))
+	assert.Equal(t, []bool{false, false}, p.mandatory)
+
+	// With a queue, an unroutable message fails the batch
+	p = &fakeAmqpPublisher{unroutable: "b"}
+	assert.ErrorIs(t, buildTestRabbitmqSink(p, "events").BatchPublishValid(context.Background(), envelopes), ErrPublishReturned)
+	assert.Equal(t, []bool{true, true}, p.mandatory)
+
+	// Only the exchange with a queue is mandatory
+	p = &fakeAmqpPublisher{unroutable: "b"}
+	assert.Nil(t, buildTestRabbitmqSink(p, "events").BatchPublishInvalid(context.Background(), envelopes))
+}
@@ -4,4 +4,507 @@
 
 package sink
 
-// TODO! Implement me.
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconf "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/db"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	REDSHIFT_LOAD_LEDGER               string = "buz_redshift_loads"
+	REDSHIFT_TSTAMP_COLUMN             string = "collector_tstamp"
+	REDSHIFT_TSTAMP_FORMAT             string = "2006-01-02 15:04:05.999999"
+	REDSHIFT_MANIFEST_PREFIX           string = "manifests"
+	REDSHIFT_PENDING_LOAD_INTERVAL_S   int    = 60
+	REDSHIFT_LEDGER_RETENTION_DAYS     int    = 7
+	DEFAULT_REDSHIFT_PORT              uint16 = 5439
+	DEFAULT_REDSHIFT_FLUSH_SIZE        int    = 10000
+	DEFAULT_REDSHIFT_FLUSH_INTERVAL_MS int    = 1000
+)
+
+type redshiftManifestEntry struct {
+	Url       string `json:"url"`
+	Mandatory bool   `json:"mandatory"`
+}
+
+type redshiftManifest struct {
+	Entries []redshiftManifestEntry `json:"entries"`
+}
+
+func quoteRedshiftLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func redshiftCreateTableStatement(table string) string {
+	columns := []string{REDSHIFT_TSTAMP_COLUMN + " TIMESTAMPTZ NOT NULL"}
+	for _, column := range envelopeColumns() {
+		// Quoted, since some envelope columns (such as user) are reserved words
+		columns = append(columns, `"`+column+`" SUPER`)
+	}
+	return "CREATE TABLE IF NOT EXISTS " + table + " (" + strings.Join(columns, ", ") + ") SORTKEY (" + REDSHIFT_TSTAMP_COLUMN + ")"
+}
+
+// COPY reads staged objects by assuming an IAM role, so no secrets ever appear in SQL text.
+func redshiftCopyStatement(table string, manifestUrl string, iamRole string, region string) string {
+	stmt := "COPY " + table + " FROM " + quoteRedshiftLiteral(manifestUrl) + " IAM_ROLE " + quoteRedshiftLiteral(iamRole) +
+		" FORMAT AS JSON 'auto' GZIP TIMEFORMAT 'auto' MANIFEST"
+	if region != "" {
+		stmt += " REGION " + quoteRedshiftLiteral(region)
+	}
+	return stmt
+}
+
+// Encode an envelope as a JSONL line, with the collector timestamp promoted to its own column.
+func encodeRedshiftLine(e envelope.Envelope) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var columns map[string]json.RawMessage
+	if err := json.Unmarshal(b, &columns); err != nil {
+		return nil, err
+	}
+	tstamp, err := json.Marshal(e.Pipeline.Collector.Tstamp.UTC().Format(REDSHIFT_TSTAMP_FORMAT))
+	if err != nil {
+		return nil, err
+	}
+	columns[REDSHIFT_TSTAMP_COLUMN] = tstamp
+	return json.Marshal(columns)
+}
+
+// Encode envelopes as gzipped JSONL.
+func encodeRedshiftObject(envelopes []envelope.Envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, e := range envelopes {
+		line, err := encodeRedshiftLine(e)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(append(line, '\n')); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func redshiftObjectKey(prefix string, table string, t time.Time, id string) string {
+	return path.Join(prefix, table, "dt="+t.UTC().Format("2006-01-02"), id+".jsonl.gz")
+}
+
+func redshiftManifestDir(prefix string, table string) string {
+	return path.Join(prefix, REDSHIFT_MANIFEST_PREFIX, table) + "/"
+}
+
+func redshiftManifestKey(prefix string, table string, id string) string {
+	return redshiftManifestDir(prefix, table) + id + ".manifest"
+}
+
+// Envelopes waiting to be staged together, and the outcome of staging them.
+type redshiftBatch struct {
+	envelopes []envelope.Envelope
+	staged    chan struct{}
+	err       error
+}
+
+// Envelopes published to a table accumulate in a shared batch, which is staged
+// once it reaches the flush size or the flush interval elapses.
+type redshiftBuffer struct {
+	mu      sync.Mutex
+	table   string
+	current *redshiftBatch
+}
+
+// Add envelopes to the current batch, returning every batch they were added to
+// and those which reached the flush size, which the caller must stage.
+func (b *redshiftBuffer) add(envelopes []envelope.Envelope, flushSize int) (batches []*redshiftBatch, full []*redshiftBatch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(envelopes) > 0 {
+		if b.current == nil {
+			b.current = &redshiftBatch{staged: make(chan struct{})}
+		}
+		n := flushSize - len(b.current.envelopes)
+		if n > len(envelopes) {
+			n = len(envelopes)
+		}
+		b.current.envelopes = append(b.current.envelopes, envelopes[:n]...)
+		envelopes = envelopes[n:]
+		batches = append(batches, b.current)
+		if len(b.current.envelopes) >= flushSize {
+			full = append(full, b.current)
+			b.current = nil
+		}
+	}
+	return batches, full
+}
+
+// Take the current batch, if it has any envelopes.
+func (b *redshiftBuffer) take() *redshiftBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch := b.current
+	b.current = nil
+	return batch
+}
+
+// A sink which stages batches of envelopes in S3 (or minio) as gzipped JSONL and
+// loads them into Redshift using COPY.
+//
+// Published envelopes are staged together, as objects of up to flushSize envelopes
+// at least every flushInterval, and publishing only succeeds once they are durably staged.
+// Every staged object gets its own manifest, which is loaded in the background.
+// The manifest is recorded in a load ledger in the same transaction as its COPY,
+// so each object is loaded exactly once. Manifests which could not be loaded
+// are left in place and retried periodically.
+type RedshiftSink struct {
+	id               *uuid.UUID
+	name             string
+	deliveryRequired bool
+	gormDb           *gorm.DB
+	client           *s3.Client
+	bucket           string
+	prefix           string
+	region           string
+	iamRole          string
+	validTable       string
+	invalidTable     string
+	flushSize        int
+	flushInterval    time.Duration
+	validBuffer      *redshiftBuffer
+	invalidBuffer    *redshiftBuffer
+	staged           chan struct{}
+	done             chan struct{}
+	flushers         sync.WaitGroup
+	loader           sync.WaitGroup
+}
+
+func (s *RedshiftSink) Id() *uuid.UUID {
+	return s.id
+}
+
+func (s *RedshiftSink) Name() string {
+	return s.name
+}
+
+func (s *RedshiftSink) Type() string {
+	return REDSHIFT
+}
+
+func (s *RedshiftSink) DeliveryRequired() bool {
+	return s.deliveryRequired
+}
+
+func buildStagingClient(ctx context.Context, conf config.Sink) (*s3.Client, error) {
+	var opts []func(*awsconf.LoadOptions) error
+	if conf.StagingRegion != "" {
+		opts = append(opts, awsconf.WithRegion(conf.StagingRegion))
+	}
+	if conf.StagingAccessKeyId != "" {
+		opts = append(opts, awsconf.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(conf.StagingAccessKeyId, conf.StagingSecretAccessKey, ""),
+		))
+	}
+	cfg, err := awsconf.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if conf.StagingEndpoint != "" {
+			// Minio and other s3-compatible stores
+			o.EndpointResolver = s3.EndpointResolverFromURL(conf.StagingEndpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
+
+func (s *RedshiftSink) Initialize(conf config.Sink) error {
+	log.Debug().Msg("🟡 initializing redshift sink")
+	if conf.StagingBucket == "" || conf.ValidTable == "" || conf.InvalidTable == "" || conf.RedshiftIamRole == "" {
+		return errors.New("redshift sink requires a stagingBucket, validTable, invalidTable, and redshiftIamRole")
+	}
+	for _, tbl := range []string{conf.ValidTable, conf.InvalidTable} {
+		if !sqlTableName.MatchString(tbl) {
+			return errors.New("invalid redshift table name: " + tbl)
+		}
+	}
+	initCtx, cancel := context.WithTimeout(context.Background(), INIT_TIMEOUT_SECONDS*time.Second)
+	defer cancel()
+	client, err := buildStagingClient(initCtx, conf)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not create staging client")
+		return err
+	}
+	if _, err := client.HeadBucket(initCtx, &s3.HeadBucketInput{Bucket: aws.String(conf.StagingBucket)}); err != nil {
+		log.Error().Err(err).Msg("🔴 could not access staging bucket " + conf.StagingBucket)
+		return err
+	}
+	port := conf.RedshiftPort
+	if port == 0 {
+		port = DEFAULT_REDSHIFT_PORT
+	}
+	connParams := db.ConnectionParams{
+		Host: conf.RedshiftHost,
+		Port: port,
+		Db:   conf.RedshiftDbName,
+		User: conf.RedshiftUser,
+		Pass: conf.RedshiftPass,
+	}
+	// Redshift does not support the extended protocol's prepared statements
+	gormDb, err := gorm.Open(postgres.New(postgres.Config{
+		DSN:                  db.GeneratePostgresDsn(connParams),
+		PreferSimpleProtocol: true,
+	}), &gorm.Config{})
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not open redshift connection")
+		return err
+	}
+	statements := []string{
+		"CREATE TABLE IF NOT EXISTS " + REDSHIFT_LOAD_LEDGER + " (manifest VARCHAR(1024) NOT NULL, tbl VARCHAR(256) NOT NULL, loaded_at TIMESTAMPTZ NOT NULL)",
+		redshiftCreateTableStatement(conf.ValidTable),
+		redshiftCreateTableStatement(conf.InvalidTable),
+	}
+	for _, stmt := range statements {
+		if err := gormDb.WithContext(initCtx).Exec(stmt).Error; err != nil {
+			log.Error().Err(err).Msg("🔴 could not ensure redshift table")
+			return err
+		}
+	}
+	id := uuid.New()
+	s.id, s.name, s.deliveryRequired = &id, conf.Name, conf.DeliveryRequired
+	s.gormDb, s.client, s.iamRole = gormDb, client, conf.RedshiftIamRole
+	s.bucket, s.prefix, s.region = conf.StagingBucket, conf.StagingPrefix, conf.StagingRegion
+	s.validTable, s.invalidTable = conf.ValidTable, conf.InvalidTable
+	s.flushSize, s.flushInterval = conf.FlushSize, time.Duration(conf.FlushIntervalMs)*time.Millisecond
+	if s.flushSize <= 0 {
+		s.flushSize = DEFAULT_REDSHIFT_FLUSH_SIZE
+	}
+	if s.flushInterval <= 0 {
+		s.flushInterval = time.Duration(DEFAULT_REDSHIFT_FLUSH_INTERVAL_MS) * time.Millisecond
+	}
+	s.staged, s.done = make(chan struct{}, 1), make(chan struct{})
+	s.validBuffer = &redshiftBuffer{table: s.validTable}
+	s.invalidBuffer = &redshiftBuffer{table: s.invalidTable}
+	for _, b := range []*redshiftBuffer{s.validBuffer, s.invalidBuffer} {
+		s.flushers.Add(1)
+		go s.flushPeriodically(b)
+	}
+	s.loader.Add(1)
+	go s.loadPeriodically(time.Duration(REDSHIFT_PENDING_LOAD_INTERVAL_S) * time.Second)
+	return nil
+}
+
+// Stage envelopes as an object and its manifest, to be loaded in the background.
+func (s *RedshiftSink) stage(ctx context.Context, table string, envelopes []envelope.Envelope) error {
+	contents, err := encodeRedshiftObject(envelopes)
+	if err != nil {
+		return err
+	}
+	id := uuid.New().String()
+	objectKey := redshiftObjectKey(s.prefix, table, time.Now(), id)
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(objectKey),
+		Body:            bytes.NewReader(contents),
+		ContentType:     aws.String("application/json"),
+		ContentEncoding: aws.String("gzip"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not stage redshift object " + objectKey)
+		return err
+	}
+	manifest, err := json.Marshal(redshiftManifest{
+		Entries: []redshiftManifestEntry{{Url: "s3://" + s.bucket + "/" + objectKey, Mandatory: true}},
+	})
+	if err != nil {
+		return err
+	}
+	manifestKey := redshiftManifestKey(s.prefix, table, id)
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(manifestKey),
+		Body:        bytes.NewReader(manifest),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not stage redshift manifest " + manifestKey)
+		return err
+	}
+	select {
+	case s.staged <- struct{}{}:
+	default: // A load is already pending
+	}
+	return nil
+}
+
+// Stage a batch, reporting the outcome to every publish waiting on it.
+func (s *RedshiftSink) flush(table string, batch *redshiftBatch) {
+	if batch == nil {
+		return
+	}
+	batch.err = s.stage(context.Background(), table, batch.envelopes)
+	close(batch.staged)
+}
+
+func (s *RedshiftSink) flushPeriodically(b *redshiftBuffer) {
+	defer s.flushers.Done()
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(b.table, b.take())
+		case <-s.done:
+			s.flush(b.table, b.take())
+			return
+		}
+	}
+}
+
+// COPY a staged object into the table, unless its manifest has already been loaded.
+// Returns whether the object was copied.
+func (s *RedshiftSink) copyOnce(ctx context.Context, table string, manifestKey string) (bool, error) {
+	copied := false
+	err := s.gormDb.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Serialize loads so that concurrent publishes can't load the same manifest twice
+		if err := tx.Exec("LOCK " + REDSHIFT_LOAD_LEDGER).Error; err != nil {
+			return err
+		}
+		var loaded int64
+		if err := tx.Table(REDSHIFT_LOAD_LEDGER).Where("manifest = ?", manifestKey).Count(&loaded).Error; err != nil {
+			return err
+		}
+		if loaded > 0 {
+			log.Debug().Msg("🟡 " + manifestKey + " already loaded - skipping")
+			return nil
+		}
+		if err := tx.Exec(redshiftCopyStatement(table, "s3://"+s.bucket+"/"+manifestKey, s.iamRole, s.region)).Error; err != nil {
+			return err
+		}
+		copied = true
+		return tx.Exec("INSERT INTO "+REDSHIFT_LOAD_LEDGER+" (manifest, tbl, loaded_at) VALUES (?, ?, ?)", manifestKey, table, time.Now().UTC()).Error
+	})
+	return copied && err == nil, err
+}
+
+// Load a staged object, then remove its manifest.
+func (s *RedshiftSink) load(ctx context.Context, table string, manifestKey string) error {
+	if _, err := s.copyOnce(ctx, table, manifestKey); err != nil {
+		return err
+	}
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(manifestKey)})
+	return err
+}
+
+// Load every manifest which is still staged for the table.
+func (s *RedshiftSink) loadPending(ctx context.Context, table string) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(redshiftManifestDir(s.prefix, table)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("🔴 could not list pending redshift manifests")
+			return
+		}
+		for _, obj := range page.Contents {
+			if err := s.load(ctx, table, *obj.Key); err != nil {
+				log.Error().Err(err).Msg("🔴 could not load " + *obj.Key + " into redshift - will retry")
+			}
+		}
+	}
+}
+
+// Ledger entries only need to outlive their manifests, which are removed once loaded.
+func (s *RedshiftSink) pruneLedger(ctx context.Context) {
+	cutoff := time.Now().UTC().AddDate(0, 0, -REDSHIFT_LEDGER_RETENTION_DAYS)
+	if err := s.gormDb.WithContext(ctx).Exec("DELETE FROM "+REDSHIFT_LOAD_LEDGER+" WHERE loaded_at < ?", cutoff).Error; err != nil {
+		log.Error().Err(err).Msg("🔴 could not prune redshift load ledger")
+	}
+}
+
+// Load staged manifests as soon as they are staged, and retry any pending ones periodically.
+func (s *RedshiftSink) loadPeriodically(interval time.Duration) {
+	defer s.loader.Done()
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Starting with anything left staged by a previous run
+		for _, tbl := range []string{s.validTable, s.invalidTable} {
+			s.loadPending(ctx, tbl)
+		}
+		select {
+		case <-s.staged:
+		case <-ticker.C:
+			s.pruneLedger(ctx)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *RedshiftSink) batchPublish(ctx context.Context, b *redshiftBuffer, envelopes []envelope.Envelope) error {
+	batches, full := b.add(envelopes, s.flushSize)
+	for _, batch := range full {
+		s.flush(b.table, batch)
+	}
+	for _, batch := range batches {
+		select {
+		case <-batch.staged:
+			if batch.err != nil {
+				return batch.err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *RedshiftSink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.validBuffer, envelopes)
+	return err
+}
+
+func (s *RedshiftSink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	err := s.batchPublish(ctx, s.invalidBuffer, envelopes)
+	return err
+}
+
+// Stage anything still buffered, then stop loading.
+// Staged manifests which have not been loaded are loaded on the next start.
+func (s *RedshiftSink) Close() {
+	log.Debug().Msg("🟡 closing redshift sink")
+	if s.done == nil {
+		return
+	}
+	close(s.done)
+	s.flushers.Wait()
+	s.loader.Wait()
+	if sqlDb, err := s.gormDb.DB(); err == nil {
+		sqlDb.Close()
+	}
+}
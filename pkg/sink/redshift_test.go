@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestEncodeRedshiftObject(t *testing.T) {
+	envelopes := []envelope.Envelope{buildBigqueryTestEnvelope(), buildBigqueryTestEnvelope()}
+	b, err := encodeRedshiftObject(envelopes)
+	assert.Nil(t, err)
+
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	assert.Nil(t, err)
+	scanner := bufio.NewScanner(zr)
+	lines := 0
+	for scanner.Scan() {
+		var row map[string]json.RawMessage
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &row))
+		assert.JSONEq(t, `"2022-11-01 12:00:00"`, string(row[REDSHIFT_TSTAMP_COLUMN]))
+		assert.JSONEq(t, `{"url": "https://buz.dev"}`, string(row["payload"]))
+		lines++
+	}
+	assert.Equal(t, len(envelopes), lines)
+}
+
+func TestRedshiftKeys(t *testing.T) {
+	tstamp := time.Date(2022, 11, 1, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, "buz/events/dt=2022-11-01/abc.jsonl.gz", redshiftObjectKey("buz", "events", tstamp, "abc"))
+	assert.Equal(t, "events/dt=2022-11-01/abc.jsonl.gz", redshiftObjectKey("", "events", tstamp, "abc"))
+	assert.Equal(t, "buz/manifests/events/abc.manifest", redshiftManifestKey("buz", "events", "abc"))
+}
+
+func TestRedshiftStatements(t *testing.T) {
+	assert.Equal(t,
+		`CREATE TABLE IF NOT EXISTS events (collector_tstamp TIMESTAMPTZ NOT NULL, "event" SUPER, "pipeline" SUPER, "device" SUPER, "user" SUPER, "session" SUPER, "web" SUPER, "annotations" SUPER, "enrichments" SUPER, "validation" SUPER, "contexts" SUPER, "payload" SUPER) SORTKEY (collector_tstamp)`,
+		redshiftCreateTableStatement("events"),
+	)
+
+	assert.Equal(t,
+		"COPY events FROM 's3://bucket/manifests/events/abc.manifest' IAM_ROLE 'arn:aws:iam::123:role/buz' FORMAT AS JSON 'auto' GZIP TIMEFORMAT 'auto' MANIFEST REGION 'us-east-1'",
+		redshiftCopyStatement("events", "s3://bucket/manifests/events/abc.manifest", "arn:aws:iam::123:role/buz", "us-east-1"),
+	)
+}
+
+func TestSqlTableName(t *testing.T) {
+	assert.True(t, sqlTableName.MatchString("buz_events"))
+	assert.True(t, sqlTableName.MatchString("analytics.buz_events"))
+	assert.False(t, sqlTableName.MatchString("events; DROP TABLE users"))
+}
+
+func TestRedshiftBufferBatchesByFlushSize(t *testing.T) {
+	b := redshiftBuffer{table: "events"}
+	envelopes := []envelope.Envelope{buildBigqueryTestEnvelope(), buildBigqueryTestEnvelope(), buildBigqueryTestEnvelope()}
+
+	batches, full := b.add(envelopes[:1], 2)
+	assert.Len(t, batches, 1)
+	assert.Empty(t, full)
+
+	// Envelopes spill over into the next batch once one is full
+	batches, full = b.add(envelopes[1:], 2)
+	assert.Len(t, batches, 2)
+	assert.Len(t, full, 1)
+	assert.Len(t, full[0].envelopes, 2)
+	assert.Len(t, b.take().envelopes, 1)
+	assert.Nil(t, b.take())
+}
+
+func TestRedshiftCopyOnce(t *testing.T) {
+	sqlDb, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	defer sqlDb.Close()
+	gormDb, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDb, PreferSimpleProtocol: true}), &gorm.Config{})
+	assert.Nil(t, err)
+	s := RedshiftSink{gormDb: gormDb, bucket: "bucket", iamRole: "arn:aws:iam::123:role/buz"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK " + REDSHIFT_LOAD_LEDGER).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT count").WithArgs("manifests/events/abc.manifest").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("COPY events FROM 's3://bucket/manifests/events/abc.manifest'").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO "+REDSHIFT_LOAD_LEDGER).WithArgs("manifests/events/abc.manifest", "events", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	copied, err := s.copyOnce(context.Background(), "events", "manifests/events/abc.manifest")
+	assert.Nil(t, err)
+	assert.True(t, copied)
+
+	// A manifest in the ledger is never copied again
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK " + REDSHIFT_LOAD_LEDGER).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT count").WithArgs("manifests/events/abc.manifest").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectCommit()
+	copied, err = s.copyOnce(context.Background(), "events", "manifests/events/abc.manifest")
+	assert.Nil(t, err)
+	assert.False(t, copied)
+
+	// A failed copy isn't recorded, so it is retried
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK " + REDSHIFT_LOAD_LEDGER).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT count").WithArgs("manifests/events/def.manifest").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("COPY events").WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	copied, err = s.copyOnce(context.Background(), "events", "manifests/events/def.manifest")
+	assert.NotNil(t, err)
+	assert.False(t, copied)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
+)
+
+const (
+	DEFAULT_RETRY_MAX_ATTEMPTS        int = 3
+	DEFAULT_RETRY_INITIAL_BACKOFF_MS  int = 100
+	DEFAULT_RETRY_MAX_BACKOFF_MS      int = 5000
+	DEFAULT_RETRY_BREAKER_COOLDOWN_MS int = 30000
+)
+
+// PublishError is returned once every publish attempt has failed.
+type PublishError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *PublishError) Error() string {
+	return "publish failed after " + strconv.Itoa(e.Attempts) + " attempt(s): " + e.Err.Error()
+}
+
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// RetrySink wraps a sink, retrying failed publishes with exponential
+// backoff and jitter, behind a consecutive-failure circuit breaker.
+//
+// NOTE! Retries happen within the publish call. With the simple manifold that is
+// the client's request, so retries should be used with the buffered manifold
+// (or the wal). Retries which would back off past the context's deadline are not attempted.
+type RetrySink struct {
+	Sink
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         float64
+	breaker        *circuitBreaker
+	stats          *stats.SinkStats
+}
+
+func NewRetrySink(s Sink, conf config.Retry, sinkStats *stats.SinkStats) *RetrySink {
+	maxAttempts, initialBackoffMs, maxBackoffMs, cooldownMs := conf.MaxAttempts, conf.InitialBackoffMs, conf.MaxBackoffMs, conf.BreakerCooldownMs
+	if maxAttempts <= 0 {
+		maxAttempts = DEFAULT_RETRY_MAX_ATTEMPTS
+	}
+	if initialBackoffMs <= 0 {
+		initialBackoffMs = DEFAULT_RETRY_INITIAL_BACKOFF_MS
+	}
+	if maxBackoffMs <= 0 {
+		maxBackoffMs = DEFAULT_RETRY_MAX_BACKOFF_MS
+	}
+	if cooldownMs <= 0 {
+		cooldownMs = DEFAULT_RETRY_BREAKER_COOLDOWN_MS
+	}
+	jitter := conf.Jitter
+	if jitter < 0 {
+		jitter = 0
+	} else if jitter > 1 {
+		jitter = 1
+	}
+	r := &RetrySink{
+		Sink:           s,
+		maxAttempts:    maxAttempts,
+		initialBackoff: time.Duration(initialBackoffMs) * time.Millisecond,
+		maxBackoff:     time.Duration(maxBackoffMs) * time.Millisecond,
+		jitter:         jitter,
+		breaker:        newCircuitBreaker(conf.BreakerThreshold, time.Duration(cooldownMs)*time.Millisecond),
+		stats:          sinkStats,
+	}
+	r.recordBreakerState()
+	return r
+}
+
+// The backoff before the given retry (1-indexed), with jitter applied.
+func (s *RetrySink) backoff(retry int) time.Duration {
+	d := s.initialBackoff << (retry - 1)
+	if d > s.maxBackoff || d <= 0 {
+		d = s.maxBackoff
+	}
+	if s.jitter > 0 {
+		d -= time.Duration(s.jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+func (s *RetrySink) recordBreakerState() {
+	state, consecutiveFailures := s.breaker.State()
+	s.stats.Update(s.Name(), func(st *stats.SinkStat) {
+		st.BreakerState = state
+		st.ConsecutiveFailures = int64(consecutiveFailures)
+	})
+}
+
+func (s *RetrySink) publish(ctx context.Context, fn func(context.Context, []envelope.Envelope) error, envelopes []envelope.Envelope) error {
+	var err error
+	attempt := 0
+	for attempt < s.maxAttempts {
+		if attempt > 0 {
+			backoff := s.backoff(attempt)
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+				log.Warn().Interface("sinkName", s.Name()).Int("attempt", attempt).Msg("🟡 not retrying publish to sink past the context deadline")
+				break
+			}
+			log.Debug().Interface("sinkName", s.Name()).Int("attempt", attempt+1).Dur("backoff", backoff).Msg("🟡 retrying publish to sink")
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return &PublishError{Attempts: attempt, Err: ctx.Err()}
+			}
+		}
+		if !s.breaker.Allow() {
+			log.Warn().Interface("sinkName", s.Name()).Interface("sinkType", s.Type()).Msg("🟡 sink circuit breaker is open - not publishing")
+			if err == nil {
+				err = ErrBreakerOpen
+			}
+			break
+		}
+		attempt++
+		err = fn(ctx, envelopes)
+		s.stats.Update(s.Name(), func(st *stats.SinkStat) {
+			st.Attempts++
+			if attempt > 1 {
+				st.Retries++
+			}
+		})
+		if err == nil {
+			s.breaker.RecordSuccess()
+			s.stats.Update(s.Name(), func(st *stats.SinkStat) { st.Successes++ })
+			s.recordBreakerState()
+			return nil
+		}
+		s.breaker.RecordFailure()
+		s.recordBreakerState()
+		log.Error().Err(err).Interface("sinkName", s.Name()).Interface("sinkType", s.Type()).Int("attempt", attempt).Msg("🔴 publish attempt failed")
+	}
+	s.stats.Update(s.Name(), func(st *stats.SinkStat) { st.Failures++ })
+	return &PublishError{Attempts: attempt, Err: err}
+}
+
+func (s *RetrySink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	return s.publish(ctx, s.Sink.BatchPublishValid, envelopes)
+}
+
+func (s *RetrySink) BatchPublishInvalid(ctx context.Context, envelopes []envelope.Envelope) error {
+	return s.publish(ctx, s.Sink.BatchPublishInvalid, envelopes)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+// A sink which fails the first `failures` publishes.
+type flakySink struct {
+	BlackholeSink
+	failures int
+	calls    int
+}
+
+func (s *flakySink) BatchPublishValid(ctx context.Context, envelopes []envelope.Envelope) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("flaky")
+	}
+	return nil
+}
+
+func buildFlakySink(failures int) *flakySink {
+	s := flakySink{failures: failures}
+	s.Initialize(config.Sink{Name: "flaky"})
+	return &s
+}
+
+func TestRetrySinkRetriesUntilSuccess(t *testing.T) {
+	ss := stats.BuildSinkStats()
+	inner := buildFlakySink(2)
+	s := NewRetrySink(inner, config.Retry{Enabled: true, MaxAttempts: 3, InitialBackoffMs: 1, MaxBackoffMs: 2}, ss)
+
+	err := s.BatchPublishValid(context.Background(), []envelope.Envelope{{}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, inner.calls)
+	st := ss.Get("flaky")
+	assert.Equal(t, int64(3), st.Attempts)
+	assert.Equal(t, int64(2), st.Retries)
+	assert.Equal(t, int64(1), st.Successes)
+	assert.Equal(t, BREAKER_CLOSED, st.BreakerState)
+}
+
+func TestRetrySinkGivesUp(t *testing.T) {
+	ss := stats.BuildSinkStats()
+	inner := buildFlakySink(10)
+	s := NewRetrySink(inner, config.Retry{Enabled: true, MaxAttempts: 2, InitialBackoffMs: 1}, ss)
+
+	err := s.BatchPublishValid(context.Background(), []envelope.Envelope{{}})
+	var publishErr *PublishError
+	assert.True(t, errors.As(err, &publishErr))
+	assert.Equal(t, 2, publishErr.Attempts)
+	assert.Equal(t, int64(1), ss.Get("flaky").Failures)
+}
+
+func TestRetrySinkStopsAtContextDeadline(t *testing.T) {
+	flaky := buildFlakySink(10)
+	s := NewRetrySink(flaky, config.Retry{MaxAttempts: 5, InitialBackoffMs: 1000}, stats.BuildSinkStats())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.BatchPublishValid(ctx, []envelope.Envelope{{}})
+	var pErr *PublishError
+	assert.ErrorAs(t, err, &pErr)
+	assert.Equal(t, 1, pErr.Attempts)
+	assert.Equal(t, 1, flaky.calls)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestRetrySinkBreakerOpens(t *testing.T) {
+	ss := stats.BuildSinkStats()
+	inner := buildFlakySink(10)
+	s := NewRetrySink(inner, config.Retry{Enabled: true, MaxAttempts: 5, InitialBackoffMs: 1, BreakerThreshold: 2, BreakerCooldownMs: 60000}, ss)
+
+	err := s.BatchPublishValid(context.Background(), []envelope.Envelope{{}})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, inner.calls)
+	assert.Equal(t, BREAKER_OPEN, ss.Get("flaky").BreakerState)
+
+	// Calls fail fast while the breaker is open
+	err = s.BatchPublishValid(context.Background(), []envelope.Envelope{{}})
+	assert.ErrorIs(t, err, ErrBreakerOpen)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(1, time.Second)
+	b.now = func() time.Time { return now }
+
+	b.RecordFailure()
+	assert.False(t, b.Allow())
+
+	now = now.Add(2 * time.Second)
+	assert.True(t, b.Allow()) // The probe
+	assert.False(t, b.Allow())
+	state, _ := b.State()
+	assert.Equal(t, BREAKER_HALF_OPEN, state)
+
+	b.RecordFailure()
+	state, _ = b.State()
+	assert.Equal(t, BREAKER_OPEN, state)
+
+	now = now.Add(2 * time.Second)
+	assert.True(t, b.Allow())
+	b.RecordSuccess()
+	state, failures := b.State()
+	assert.Equal(t, BREAKER_CLOSED, state)
+	assert.Equal(t, 0, failures)
+}
+
+func TestRetrySinkBackoff(t *testing.T) {
+	s := NewRetrySink(buildFlakySink(0), config.Retry{InitialBackoffMs: 100, MaxBackoffMs: 300}, stats.BuildSinkStats())
+	assert.Equal(t, 100*time.Millisecond, s.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, s.backoff(2))
+	assert.Equal(t, 300*time.Millisecond, s.backoff(3))
+}
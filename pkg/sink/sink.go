@@ -12,6 +12,7 @@ import (
 	"github.com/silverton-io/buz/pkg/config"
 	"github.com/silverton-io/buz/pkg/db"
 	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/stats"
 	"golang.org/x/net/context"
 )
 
@@ -31,6 +32,10 @@ const (
 	NATS_JETSTREAM   string = "nats-jetstream"
 	INDICATIVE       string = "indicative"
 	AMPLITUDE        string = "amplitude"
+	BIGQUERY         string = "bigquery"
+	REDSHIFT         string = "redshift"
+	PULSAR           string = "pulsar"
+	RABBITMQ         string = "rabbitmq"
 )
 
 type Sink interface {
@@ -109,6 +114,21 @@ func BuildSink(conf config.Sink) (sink Sink, err error) {
 	case AMPLITUDE:
 		sink := AmplitudeSink{}
 		return &sink, nil
+	case BIGQUERY:
+		sink := BigquerySink{}
+		return &sink, nil
+	case REDSHIFT:
+		sink := RedshiftSink{}
+		return &sink, nil
+	case PULSAR:
+		sink := PulsarSink{}
+		return &sink, nil
+	case RABBITMQ:
+		sink := RabbitmqSink{}
+		return &sink, nil
+	case db.AZUREDW:
+		sink := AzureDwSink{}
+		return &sink, nil
 	// case NATS_JETSTREAM: // FIXME - there's something weird with this - lots of timeouts. Will come back to it later.
 	// 	sink := NatsJetstreamSink{}
 	// 	return &sink, nil
@@ -129,9 +149,16 @@ func InitializeSink(conf config.Sink, s Sink) error {
 	return nil
 }
 
-func BuildAndInitializeSinks(conf []config.Sink) ([]Sink, error) {
+func BuildAndInitializeSinks(conf []config.Sink, sinkStats *stats.SinkStats) ([]Sink, error) {
 	var sinks []Sink
-	for _, sConf := range conf {
+	sinkIdx := make(map[string]int)
+	for i, sConf := range conf {
+		if _, exists := sinkIdx[sConf.Name]; exists {
+			e := errors.New("duplicate sink name: " + sConf.Name)
+			log.Error().Err(e).Msg("🔴 sink names must be unique")
+			return nil, e
+		}
+		sinkIdx[sConf.Name] = i
 		sink, err := BuildSink(sConf)
 		if err != nil {
 			log.Error().Err(err).Msg("🔴 could not build sink")
@@ -142,7 +169,37 @@ func BuildAndInitializeSinks(conf []config.Sink) ([]Sink, error) {
 			log.Error().Err(err).Msg("🔴 could not initialize sink")
 			return nil, err
 		}
+		// Metered innermost, so every attempt (including dead letters) is observed
+		sink = NewMeteredSink(sink)
+		if sConf.Retry.Enabled {
+			log.Info().Msg("🟢 wrapping " + sConf.Name + " sink with retries")
+			sink = NewRetrySink(sink, sConf.Retry, sinkStats)
+		}
 		sinks = append(sinks, sink)
 	}
-	return sinks, nil
+	// Dead letter targets are wired up once every sink exists.
+	// Targets are never themselves wrapped, so dead letters cannot cycle,
+	// and manifolds only publish dead letters (not routed envelopes) to them.
+	wrapped := make([]Sink, len(sinks))
+	copy(wrapped, sinks)
+	for i, sConf := range conf {
+		if sConf.DeadLetter == "" {
+			continue
+		}
+		idx, exists := sinkIdx[sConf.DeadLetter]
+		if !exists || idx == i {
+			e := errors.New("invalid dead letter sink for " + sConf.Name + ": " + sConf.DeadLetter)
+			log.Error().Err(e).Msg("🔴 dead letter sink must be another configured sink")
+			return nil, e
+		}
+		log.Info().Msg("🟢 dead-lettering " + sConf.Name + " sink failures to " + sConf.DeadLetter)
+		wrapped[i] = NewDeadLetterSink(sinks[i], sinks[idx])
+	}
+	return wrapped, nil
+}
+
+func CloseSinks(sinks []Sink) {
+	for _, s := range sinks {
+		s.Close()
+	}
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/db"
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -110,6 +111,46 @@ func TestBuildSink(t *testing.T) {
 		assert.Equal(t, nil, err)
 	})
 
+	t.Run(BIGQUERY, func(t *testing.T) {
+		c.Type = BIGQUERY
+		sink, err := BuildSink(c)
+		bigquerySink := BigquerySink{}
+		assert.IsType(t, &bigquerySink, sink)
+		assert.Equal(t, nil, err)
+	})
+
+	t.Run(REDSHIFT, func(t *testing.T) {
+		c.Type = REDSHIFT
+		sink, err := BuildSink(c)
+		redshiftSink := RedshiftSink{}
+		assert.IsType(t, &redshiftSink, sink)
+		assert.Equal(t, nil, err)
+	})
+
+	t.Run(PULSAR, func(t *testing.T) {
+		c.Type = PULSAR
+		sink, err := BuildSink(c)
+		pulsarSink := PulsarSink{}
+		assert.IsType(t, &pulsarSink, sink)
+		assert.Equal(t, nil, err)
+	})
+
+	t.Run(RABBITMQ, func(t *testing.T) {
+		c.Type = RABBITMQ
+		sink, err := BuildSink(c)
+		rabbitmqSink := RabbitmqSink{}
+		assert.IsType(t, &rabbitmqSink, sink)
+		assert.Equal(t, nil, err)
+	})
+
+	t.Run(db.AZUREDW, func(t *testing.T) {
+		c.Type = db.AZUREDW
+		sink, err := BuildSink(c)
+		azureDwSink := AzureDwSink{}
+		assert.IsType(t, &azureDwSink, sink)
+		assert.Equal(t, nil, err)
+	})
+
 	t.Run("unsupported", func(t *testing.T) {
 		c.Type = "unsupported-type"
 		wantedErr := errors.New("unsupported sink: " + c.Type)
@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/envelope"
+)
+
+const BUCKET_WIDTH = time.Minute
+
+type bucketKey struct {
+	minute    int64
+	outcome   string
+	protocol  string
+	namespace string
+}
+
+// The count of envelopes with an outcome, for a protocol and namespace, as recorded by an instance.
+type Count struct {
+	Instance  string    `json:"instance" gorm:"primaryKey;size:255"`
+	Outcome   string    `json:"outcome" gorm:"primaryKey;size:255"`
+	Protocol  string    `json:"protocol" gorm:"primaryKey;size:255"`
+	Namespace string    `json:"namespace" gorm:"primaryKey;size:255"`
+	Count     int64     `json:"count"`
+	UpdatedAt time.Time `json:"-" gorm:"index"` // When the instance's snapshot was taken
+}
+
+// A count within a single one-minute bucket.
+type BucketCount struct {
+	Count
+	Minute time.Time `json:"minute" gorm:"primaryKey"`
+}
+
+type Point struct {
+	Minute time.Time `json:"minute"`
+	Count  int64     `json:"count"`
+}
+
+// Start recording per-minute buckets. They are only recorded when persisted,
+// since buckets are otherwise never pruned.
+func (ps *ProtocolStats) recordBuckets() {
+	ps.bmu.Lock()
+	defer ps.bmu.Unlock()
+	if ps.buckets == nil {
+		ps.buckets = make(map[bucketKey]int64)
+	}
+}
+
+func (ps *ProtocolStats) bucket(outcome string, event *envelope.EventMeta, count int64) {
+	ps.bmu.Lock()
+	defer ps.bmu.Unlock()
+	if ps.buckets == nil {
+		return
+	}
+	minute := ps.now().Truncate(BUCKET_WIDTH).Unix()
+	ps.buckets[bucketKey{minute: minute, outcome: outcome, protocol: event.Protocol, namespace: event.Namespace}] += count
+}
+
+// Counts of every outcome since the collector started, or since it was seeded.
+func (ps *ProtocolStats) Counts(instance string) []Count {
+	var counts []Count
+	for outcome, protocols := range ps.Snapshot() {
+		for protocol, namespaces := range protocols {
+			for namespace, count := range namespaces {
+				counts = append(counts, Count{Instance: instance, Outcome: outcome, Protocol: protocol, Namespace: namespace, Count: count})
+			}
+		}
+	}
+	return counts
+}
+
+// Per-minute counts, dropping buckets from before the cutoff.
+func (ps *ProtocolStats) Buckets(instance string, cutoff time.Time) []BucketCount {
+	ps.bmu.Lock()
+	defer ps.bmu.Unlock()
+	var buckets []BucketCount
+	for k, count := range ps.buckets {
+		minute := time.Unix(k.minute, 0).UTC()
+		if minute.Before(cutoff) {
+			delete(ps.buckets, k)
+			continue
+		}
+		buckets = append(buckets, BucketCount{
+			Count:  Count{Instance: instance, Outcome: k.outcome, Protocol: k.protocol, Namespace: k.namespace, Count: count},
+			Minute: minute,
+		})
+	}
+	return buckets
+}
+
+func seed(mu *sync.Mutex, counts map[string]map[string]int64, c Count) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := counts[c.Protocol]; !ok {
+		counts[c.Protocol] = make(map[string]int64)
+	}
+	counts[c.Protocol][c.Namespace] += c.Count
+}
+
+// Seed counts and buckets from a previous snapshot, such as one taken before a restart.
+func (ps *ProtocolStats) Seed(counts []Count, buckets []BucketCount) {
+	for _, c := range counts {
+		switch c.Outcome {
+		case VALID:
+			seed(&ps.vmu, ps.Valid, c)
+		case INVALID:
+			seed(&ps.imu, ps.Invalid, c)
+		case SAMPLED_OUT:
+			seed(&ps.smu, ps.SampledOut, c)
+		case SUPPRESSED:
+			seed(&ps.xmu, ps.Suppressed, c)
+		case DUPLICATE:
+			seed(&ps.dmu, ps.Duplicates, c)
+		}
+	}
+	ps.bmu.Lock()
+	defer ps.bmu.Unlock()
+	if ps.buckets == nil && len(buckets) > 0 {
+		ps.buckets = make(map[bucketKey]int64)
+	}
+	for _, b := range buckets {
+		ps.buckets[bucketKey{minute: b.Minute.Unix(), outcome: b.Outcome, protocol: b.Protocol, namespace: b.Namespace}] += b.Count.Count
+	}
+}
+
+// Stats merged across every instance.
+type ClusterStats struct {
+	Instances []string                               `json:"instances"`
+	Stats     map[string]map[string]map[string]int64 `json:"stats"`   // outcome -> protocol -> namespace -> count
+	Buckets   map[string]map[string][]Point          `json:"buckets"` // namespace -> outcome -> per-minute counts
+}
+
+// Merge snapshots from every instance. Buckets are summed across protocols and
+// optionally filtered to a single namespace.
+func Aggregate(counts []Count, buckets []BucketCount, namespace string) ClusterStats {
+	cs := ClusterStats{
+		Instances: []string{},
+		Stats:     make(map[string]map[string]map[string]int64),
+		Buckets:   make(map[string]map[string][]Point),
+	}
+	instances := make(map[string]struct{})
+	for _, c := range counts {
+		instances[c.Instance] = struct{}{}
+		if _, ok := cs.Stats[c.Outcome]; !ok {
+			cs.Stats[c.Outcome] = make(map[string]map[string]int64)
+		}
+		if _, ok := cs.Stats[c.Outcome][c.Protocol]; !ok {
+			cs.Stats[c.Outcome][c.Protocol] = make(map[string]int64)
+		}
+		cs.Stats[c.Outcome][c.Protocol][c.Namespace] += c.Count
+	}
+	series := make(map[string]map[string]map[time.Time]int64)
+	for _, b := range buckets {
+		instances[b.Instance] = struct{}{}
+		if namespace != "" && b.Namespace != namespace {
+			continue
+		}
+		if _, ok := series[b.Namespace]; !ok {
+			series[b.Namespace] = make(map[string]map[time.Time]int64)
+		}
+		if _, ok := series[b.Namespace][b.Outcome]; !ok {
+			series[b.Namespace][b.Outcome] = make(map[time.Time]int64)
+		}
+		series[b.Namespace][b.Outcome][b.Minute.UTC()] += b.Count.Count
+	}
+	for ns, outcomes := range series {
+		cs.Buckets[ns] = make(map[string][]Point)
+		for outcome, minutes := range outcomes {
+			points := make([]Point, 0, len(minutes))
+			for minute, count := range minutes {
+				points = append(points, Point{Minute: minute, Count: count})
+			}
+			sort.Slice(points, func(i, j int) bool { return points[i].Minute.Before(points[j].Minute) })
+			cs.Buckets[ns][outcome] = points
+		}
+	}
+	for instance := range instances {
+		cs.Instances = append(cs.Instances, instance)
+	}
+	sort.Strings(cs.Instances)
+	return cs
+}
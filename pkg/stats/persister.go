@@ -0,0 +1,160 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	DEFAULT_SNAPSHOT_SECONDS int = 60
+	DEFAULT_RETENTION_HOURS  int = 24
+)
+
+var validInstance = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Periodically snapshots an instance's stats to a store shared by every
+// instance, so that stats survive restarts and can be merged cluster-wide.
+//
+// An instance with a stable name is seeded from its previous snapshot when it starts.
+// Snapshots which haven't been updated within the retention period, such as those
+// of instances without a stable name which have since restarted, are pruned.
+type Persister struct {
+	mu        sync.Mutex
+	store     Store
+	stats     *ProtocolStats
+	instance  string
+	retention time.Duration
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func BuildPersister(conf config.StatsPersistence, instanceId string, ps *ProtocolStats) (*Persister, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	store, err := BuildStore(conf)
+	if err != nil {
+		return nil, err
+	}
+	return newPersister(conf, instanceId, ps, store)
+}
+
+func newPersister(conf config.StatsPersistence, instanceId string, ps *ProtocolStats, store Store) (*Persister, error) {
+	p := Persister{
+		store:     store,
+		stats:     ps,
+		instance:  conf.Instance,
+		retention: time.Duration(conf.RetentionHours) * time.Hour,
+		done:      make(chan struct{}),
+	}
+	if p.instance == "" {
+		p.instance = instanceId
+	}
+	if !validInstance.MatchString(p.instance) {
+		return nil, errors.New("invalid stats instance name: " + p.instance)
+	}
+	if p.retention == 0 {
+		p.retention = time.Duration(DEFAULT_RETENTION_HOURS) * time.Hour
+	}
+	ps.recordBuckets()
+	if err := p.seed(); err != nil {
+		return nil, err
+	}
+	interval := conf.SnapshotSeconds
+	if interval == 0 {
+		interval = DEFAULT_SNAPSHOT_SECONDS
+	}
+	p.wg.Add(1)
+	go p.run(time.Duration(interval) * time.Second)
+	return &p, nil
+}
+
+func (p *Persister) cutoff() time.Time {
+	return p.stats.now().Add(-p.retention).Truncate(BUCKET_WIDTH)
+}
+
+// Seed stats from this instance's previous snapshot.
+func (p *Persister) seed() error {
+	counts, buckets, err := p.store.Load(p.cutoff())
+	if err != nil {
+		return err
+	}
+	var ownCounts []Count
+	var ownBuckets []BucketCount
+	for _, c := range counts {
+		if c.Instance == p.instance {
+			ownCounts = append(ownCounts, c)
+		}
+	}
+	for _, b := range buckets {
+		if b.Instance == p.instance {
+			ownBuckets = append(ownBuckets, b)
+		}
+	}
+	if len(ownCounts) > 0 || len(ownBuckets) > 0 {
+		log.Info().Str("instance", p.instance).Msg("🟢 seeding stats from previous snapshot")
+		p.stats.Seed(ownCounts, ownBuckets)
+	}
+	return nil
+}
+
+func (p *Persister) run(interval time.Duration) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Snapshot(); err != nil {
+				log.Error().Err(err).Msg("🔴 could not snapshot stats")
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Snapshot this instance's stats to the store.
+func (p *Persister) Snapshot() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cutoff := p.cutoff()
+	return p.store.Save(p.instance, p.stats.Counts(p.instance), p.stats.Buckets(p.instance, cutoff), cutoff)
+}
+
+// Stats merged across every instance, optionally limiting buckets to a namespace.
+// This instance is snapshotted first so that its own counts are current.
+func (p *Persister) Cluster(namespace string) (ClusterStats, error) {
+	if err := p.Snapshot(); err != nil {
+		return ClusterStats{}, err
+	}
+	counts, buckets, err := p.store.Load(p.cutoff())
+	if err != nil {
+		return ClusterStats{}, err
+	}
+	return Aggregate(counts, buckets, namespace), nil
+}
+
+// Take a final snapshot and close the store.
+func (p *Persister) Close() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+	if err := p.Snapshot(); err != nil {
+		log.Error().Err(err).Msg("🔴 could not take final stats snapshot")
+	}
+	if err := p.store.Close(); err != nil {
+		log.Error().Err(err).Msg("🔴 could not close stats store")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTestStats(now time.Time) *ProtocolStats {
+	ps := BuildProtocolStats()
+	ps.now = func() time.Time { return now }
+	return ps
+}
+
+func TestBuckets(t *testing.T) {
+	now := time.Date(2022, 11, 1, 12, 30, 15, 0, time.UTC)
+	ps := buildTestStats(now)
+	ps.recordBuckets()
+	event := envelope.EventMeta{Protocol: protocol.SNOWPLOW, Namespace: "page_view"}
+	ps.IncrementValid(&event, 2)
+	ps.now = func() time.Time { return now.Add(time.Minute) }
+	ps.IncrementValid(&event, 1)
+	ps.IncrementInvalid(&event, 1)
+
+	buckets := ps.Buckets("a", now.Add(-time.Hour))
+	assert.Equal(t, 3, len(buckets))
+	// Buckets before the cutoff are dropped
+	assert.Equal(t, 2, len(ps.Buckets("a", now.Add(time.Minute).Truncate(BUCKET_WIDTH))))
+	assert.Equal(t, 2, len(ps.Buckets("a", now.Add(-time.Hour))))
+}
+
+func TestPersisterMergesInstances(t *testing.T) {
+	now := time.Now().UTC()
+	conf := config.StatsPersistence{Enabled: true, Backend: FILE, Path: t.TempDir()}
+	event := envelope.EventMeta{Protocol: protocol.CLOUDEVENTS, Namespace: "order"}
+
+	psA, psB := buildTestStats(now), buildTestStats(now)
+	a, err := BuildPersister(conf, "a", psA)
+	assert.Nil(t, err)
+	b, err := BuildPersister(conf, "b", psB)
+	assert.Nil(t, err)
+	psA.IncrementValid(&event, 3)
+	psB.IncrementValid(&event, 4)
+	psB.IncrementDuplicate(&event, 1)
+	a.Close()
+
+	cluster, err := b.Cluster("")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, cluster.Instances)
+	assert.Equal(t, int64(7), cluster.Stats[VALID][protocol.CLOUDEVENTS]["order"])
+	assert.Equal(t, int64(1), cluster.Stats[DUPLICATE][protocol.CLOUDEVENTS]["order"])
+	minute := now.Truncate(BUCKET_WIDTH)
+	assert.Equal(t, []Point{{Minute: minute, Count: 7}}, cluster.Buckets["order"][VALID])
+
+	filtered, err := b.Cluster("other")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(filtered.Buckets))
+	b.Close()
+}
+
+func TestPersisterSeedsAfterRestart(t *testing.T) {
+	now := time.Now().UTC()
+	conf := config.StatsPersistence{Enabled: true, Backend: FILE, Path: t.TempDir(), Instance: "buz-0"}
+	event := envelope.EventMeta{Protocol: protocol.WEBHOOK, Namespace: "hook"}
+
+	ps := buildTestStats(now)
+	p, err := BuildPersister(conf, "ignored", ps)
+	assert.Nil(t, err)
+	ps.IncrementValid(&event, 5)
+	p.Close()
+
+	restarted := buildTestStats(now)
+	p, err = BuildPersister(conf, "ignored", restarted)
+	assert.Nil(t, err)
+	restarted.IncrementValid(&event, 1)
+	assert.Equal(t, int64(6), restarted.Valid[protocol.WEBHOOK]["hook"])
+	cluster, err := p.Cluster("hook")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"buz-0"}, cluster.Instances)
+	assert.Equal(t, int64(6), cluster.Stats[VALID][protocol.WEBHOOK]["hook"])
+	assert.Equal(t, int64(6), cluster.Buckets["hook"][VALID][0].Count)
+	p.Close()
+}
+
+func TestBuildPersister(t *testing.T) {
+	p, err := BuildPersister(config.StatsPersistence{}, "a", BuildProtocolStats())
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+	p.Close()
+
+	_, err = BuildPersister(config.StatsPersistence{Enabled: true, Backend: "carrier-pigeon"}, "a", BuildProtocolStats())
+	assert.NotNil(t, err)
+	_, err = BuildPersister(config.StatsPersistence{Enabled: true, Backend: FILE, Path: t.TempDir(), Instance: "../a"}, "a", BuildProtocolStats())
+	assert.NotNil(t, err)
+}
+
+func TestBucketsOnlyRecordedWhenPersisted(t *testing.T) {
+	ps := buildTestStats(time.Now())
+	event := envelope.EventMeta{Protocol: protocol.SNOWPLOW, Namespace: "page_view"}
+	ps.IncrementValid(&event, 1)
+	assert.Empty(t, ps.Buckets("a", time.Time{}))
+	assert.Equal(t, int64(1), ps.Valid[protocol.SNOWPLOW]["page_view"])
+}
+
+func TestStaleSnapshotsArePruned(t *testing.T) {
+	dir := t.TempDir()
+	store := FileStore{dir: dir}
+	counts := []Count{{Instance: "old", Outcome: VALID, Protocol: protocol.PIXEL, Namespace: "a", Count: 1}}
+	assert.Nil(t, store.Save("old", counts, nil, time.Time{}))
+
+	loaded, _, err := store.Load(time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, loaded, 1)
+	// A snapshot which hasn't been updated since the cutoff is no longer counted
+	loaded, _, err = store.Load(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Empty(t, loaded)
+	_, err = os.Stat(filepath.Join(dir, "old.json"))
+	assert.True(t, os.IsNotExist(err))
+}
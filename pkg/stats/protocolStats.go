@@ -6,43 +6,120 @@ package stats
 
 import (
 	"sync"
+	"time"
 
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/protocol"
 )
 
+// Outcomes
+const (
+	VALID       string = "valid"
+	INVALID     string = "invalid"
+	SAMPLED_OUT string = "sampledOut"
+	SUPPRESSED  string = "suppressed"
+	DUPLICATE   string = "duplicate"
+)
+
 type ProtocolStats struct {
-	vmu     sync.Mutex
-	imu     sync.Mutex
-	Invalid map[string]map[string]int64 `json:"invalid"`
-	Valid   map[string]map[string]int64 `json:"valid"`
+	vmu        sync.Mutex
+	imu        sync.Mutex
+	smu        sync.Mutex
+	xmu        sync.Mutex
+	dmu        sync.Mutex
+	Invalid    map[string]map[string]int64 `json:"invalid"`
+	Valid      map[string]map[string]int64 `json:"valid"`
+	SampledOut map[string]map[string]int64 `json:"sampledOut"` // Envelopes dropped by per-sink sampling or rate caps
+	Suppressed map[string]map[string]int64 `json:"suppressed"` // Envelopes dropped by the suppression list
+	Duplicates map[string]map[string]int64 `json:"duplicates"` // Envelopes dropped or flagged as duplicates
+	bmu        sync.Mutex
+	buckets    map[bucketKey]int64
+	now        func() time.Time
 }
 
 func (ps *ProtocolStats) Build() {
 	var vProtoStat = make(map[string]map[string]int64)
 	var invProtoStat = make(map[string]map[string]int64)
+	var sProtoStat = make(map[string]map[string]int64)
+	var xProtoStat = make(map[string]map[string]int64)
+	var dProtoStat = make(map[string]map[string]int64)
 	ps.Valid = vProtoStat
 	ps.Invalid = invProtoStat
+	ps.SampledOut = sProtoStat
+	ps.Suppressed = xProtoStat
+	ps.Duplicates = dProtoStat
+	ps.now = time.Now
 	for _, protocol := range protocol.GetIntputProtocols() {
 		var vEventStat = make(map[string]int64)
 		var invEventStat = make(map[string]int64)
+		var sEventStat = make(map[string]int64)
+		var xEventStat = make(map[string]int64)
+		var dEventStat = make(map[string]int64)
 		ps.Valid[protocol] = vEventStat
 		ps.Invalid[protocol] = invEventStat
+		ps.SampledOut[protocol] = sEventStat
+		ps.Suppressed[protocol] = xEventStat
+		ps.Duplicates[protocol] = dEventStat
 	}
 }
 
 func (ps *ProtocolStats) IncrementValid(event *envelope.EventMeta, count int64) {
-	i := ps.Valid[event.Protocol][event.Namespace]
 	ps.vmu.Lock()
 	defer ps.vmu.Unlock()
-	ps.Valid[event.Protocol][event.Namespace] = i + count
+	ps.Valid[event.Protocol][event.Namespace] += count
+	ps.bucket(VALID, event, count)
 }
 
 func (ps *ProtocolStats) IncrementInvalid(event *envelope.EventMeta, count int64) {
-	i := ps.Invalid[event.Protocol][event.Namespace]
 	ps.imu.Lock()
 	defer ps.imu.Unlock()
-	ps.Invalid[event.Protocol][event.Namespace] = i + count
+	ps.Invalid[event.Protocol][event.Namespace] += count
+	ps.bucket(INVALID, event, count)
+}
+
+func (ps *ProtocolStats) IncrementSampledOut(event *envelope.EventMeta, count int64) {
+	ps.smu.Lock()
+	defer ps.smu.Unlock()
+	ps.SampledOut[event.Protocol][event.Namespace] += count
+	ps.bucket(SAMPLED_OUT, event, count)
+}
+
+func (ps *ProtocolStats) IncrementSuppressed(event *envelope.EventMeta, count int64) {
+	ps.xmu.Lock()
+	defer ps.xmu.Unlock()
+	ps.Suppressed[event.Protocol][event.Namespace] += count
+	ps.bucket(SUPPRESSED, event, count)
+}
+
+func (ps *ProtocolStats) IncrementDuplicate(event *envelope.EventMeta, count int64) {
+	ps.dmu.Lock()
+	defer ps.dmu.Unlock()
+	ps.Duplicates[event.Protocol][event.Namespace] += count
+	ps.bucket(DUPLICATE, event, count)
+}
+
+func snapshot(mu *sync.Mutex, counts map[string]map[string]int64) map[string]map[string]int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	c := make(map[string]map[string]int64, len(counts))
+	for protocol, namespaces := range counts {
+		c[protocol] = make(map[string]int64, len(namespaces))
+		for namespace, count := range namespaces {
+			c[protocol][namespace] = count
+		}
+	}
+	return c
+}
+
+// Snapshot copies every counter, keyed by outcome, protocol, and namespace.
+func (ps *ProtocolStats) Snapshot() map[string]map[string]map[string]int64 {
+	return map[string]map[string]map[string]int64{
+		VALID:       snapshot(&ps.vmu, ps.Valid),
+		INVALID:     snapshot(&ps.imu, ps.Invalid),
+		SAMPLED_OUT: snapshot(&ps.smu, ps.SampledOut),
+		SUPPRESSED:  snapshot(&ps.xmu, ps.Suppressed),
+		DUPLICATE:   snapshot(&ps.dmu, ps.Duplicates),
+	}
 }
 
 func BuildProtocolStats() *ProtocolStats {
@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+type SinkStat struct {
+	Attempts            int64  `json:"attempts"`
+	Retries             int64  `json:"retries"`
+	Successes           int64  `json:"successes"`
+	Failures            int64  `json:"failures"`
+	ConsecutiveFailures int64  `json:"consecutiveFailures"`
+	BreakerState        string `json:"breakerState,omitempty"`
+}
+
+// Delivery stats, keyed by sink name.
+type SinkStats struct {
+	mu    sync.Mutex
+	sinks map[string]*SinkStat
+}
+
+func (ss *SinkStats) Build() {
+	ss.sinks = make(map[string]*SinkStat)
+}
+
+// Update applies fn to the named sink's stats while holding the lock.
+func (ss *SinkStats) Update(sinkName string, fn func(s *SinkStat)) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, ok := ss.sinks[sinkName]
+	if !ok {
+		s = &SinkStat{}
+		ss.sinks[sinkName] = s
+	}
+	fn(s)
+}
+
+// Get returns a copy of the named sink's stats.
+func (ss *SinkStats) Get(sinkName string) SinkStat {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if s, ok := ss.sinks[sinkName]; ok {
+		return *s
+	}
+	return SinkStat{}
+}
+
+func (ss *SinkStats) MarshalJSON() ([]byte, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return json.Marshal(ss.sinks)
+}
+
+func BuildSinkStats() *SinkStats {
+	ss := SinkStats{}
+	ss.Build()
+	return &ss
+}
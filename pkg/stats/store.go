@@ -0,0 +1,201 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/db"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	FILE string = "file"
+)
+
+const (
+	STATS_TABLE         string = "buz_stats"
+	STATS_BUCKETS_TABLE string = "buz_stats_buckets"
+)
+
+// A store of stats snapshots from every instance.
+// Saving replaces the instance's previous snapshot, so snapshots are idempotent.
+// Buckets and snapshots from before the cutoff are neither loaded nor kept.
+type Store interface {
+	Save(instance string, counts []Count, buckets []BucketCount, cutoff time.Time) error
+	Load(cutoff time.Time) ([]Count, []BucketCount, error)
+	Close() error
+}
+
+func BuildStore(conf config.StatsPersistence) (Store, error) {
+	connParams := db.ConnectionParams{
+		Host: conf.DbHost,
+		Port: conf.DbPort,
+		Db:   conf.DbName,
+		User: conf.DbUser,
+		Pass: conf.DbPass,
+	}
+	switch conf.Backend {
+	case FILE:
+		if conf.Path == "" {
+			return nil, errors.New("file stats backend requires a path")
+		}
+		if err := os.MkdirAll(conf.Path, 0755); err != nil {
+			return nil, err
+		}
+		return &FileStore{dir: conf.Path}, nil
+	case db.POSTGRES, db.TIMESCALE:
+		return openDbStore(postgres.Open(db.GeneratePostgresDsn(connParams)))
+	case db.MYSQL:
+		return openDbStore(mysql.Open(db.GenerateMysqlDsn(connParams)))
+	default:
+		return nil, errors.New("unsupported stats backend: " + conf.Backend)
+	}
+}
+
+type snapshotFile struct {
+	Instance  string        `json:"instance"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+	Counts    []Count       `json:"counts"`
+	Buckets   []BucketCount `json:"buckets"`
+}
+
+// Snapshots stored as one file per instance, in a directory shared by every instance.
+type FileStore struct {
+	dir string
+}
+
+func (s *FileStore) Save(instance string, counts []Count, buckets []BucketCount, cutoff time.Time) error {
+	b, err := json.Marshal(snapshotFile{Instance: instance, UpdatedAt: time.Now().UTC(), Counts: counts, Buckets: buckets})
+	if err != nil {
+		return err
+	}
+	// Write then rename, so readers never see a partial snapshot
+	tmp, err := os.CreateTemp(s.dir, "."+instance+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, instance+".json"))
+}
+
+func (s *FileStore) Load(cutoff time.Time) ([]Count, []BucketCount, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var counts []Count
+	var buckets []BucketCount
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		var f snapshotFile
+		if err := json.Unmarshal(b, &f); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("🟡 skipping unreadable stats snapshot")
+			continue
+		}
+		if f.UpdatedAt.Before(cutoff) {
+			log.Debug().Str("instance", f.Instance).Msg("🟡 removing stale stats snapshot")
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, nil, err
+			}
+			continue
+		}
+		counts = append(counts, f.Counts...)
+		for _, bucket := range f.Buckets {
+			if !bucket.Minute.Before(cutoff) {
+				buckets = append(buckets, bucket)
+			}
+		}
+	}
+	return counts, buckets, nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// Snapshots stored in database tables, using the same connection helpers as database sinks.
+type DbStore struct {
+	gormDb *gorm.DB
+}
+
+func openDbStore(dialector gorm.Dialector) (*DbStore, error) {
+	gormDb, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not open stats db connection")
+		return nil, err
+	}
+	if err := db.EnsureTable(gormDb, STATS_TABLE, &Count{}); err != nil {
+		return nil, err
+	}
+	if err := db.EnsureTable(gormDb, STATS_BUCKETS_TABLE, &BucketCount{}); err != nil {
+		return nil, err
+	}
+	return &DbStore{gormDb: gormDb}, nil
+}
+
+func (s *DbStore) Save(instance string, counts []Count, buckets []BucketCount, cutoff time.Time) error {
+	now := time.Now().UTC()
+	for i := range counts {
+		counts[i].UpdatedAt = now
+	}
+	return s.gormDb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(STATS_TABLE).Where("instance = ? OR updated_at < ?", instance, cutoff).Delete(&Count{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Table(STATS_BUCKETS_TABLE).Where("instance = ? OR minute < ?", instance, cutoff).Delete(&BucketCount{}).Error; err != nil {
+			return err
+		}
+		if len(counts) > 0 {
+			if err := tx.Table(STATS_TABLE).CreateInBatches(counts, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(buckets) > 0 {
+			if err := tx.Table(STATS_BUCKETS_TABLE).CreateInBatches(buckets, 500).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *DbStore) Load(cutoff time.Time) ([]Count, []BucketCount, error) {
+	var counts []Count
+	var buckets []BucketCount
+	if err := s.gormDb.Table(STATS_TABLE).Where("updated_at >= ?", cutoff).Find(&counts).Error; err != nil {
+		return nil, nil, err
+	}
+	if err := s.gormDb.Table(STATS_BUCKETS_TABLE).Where("minute >= ?", cutoff).Find(&buckets).Error; err != nil {
+		return nil, nil, err
+	}
+	return counts, buckets, nil
+}
+
+func (s *DbStore) Close() error {
+	sqlDb, err := s.gormDb.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.Close()
+}
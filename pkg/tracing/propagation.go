@@ -0,0 +1,99 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var traceContext = propagation.TraceContext{}
+
+// Extract the request's trace context, preferring the active span over the incoming
+// `traceparent` header so that it is propagated even when the request span is not sampled.
+func requestContext(req *http.Request) context.Context {
+	ctx := req.Context()
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// Propagate the request's trace context into every envelope's contexts.
+// Nothing is propagated when tracing is disabled.
+func Propagate(conf config.Tracing, req *http.Request, envelopes []envelope.Envelope) []envelope.Envelope {
+	if !conf.Enabled {
+		return envelopes
+	}
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(requestContext(req), carrier)
+	if len(carrier) == 0 {
+		return envelopes
+	}
+	for i := range envelopes {
+		tc := make(map[string]interface{}, len(carrier))
+		for k, v := range carrier {
+			tc[k] = v
+		}
+		if envelopes[i].Contexts == nil {
+			envelopes[i].Contexts = &map[string]interface{}{}
+		}
+		(*envelopes[i].Contexts)[envelope.TRACE_CONTEXT] = tc
+	}
+	return envelopes
+}
+
+// The trace context propagated into an envelope, if any.
+func SpanContext(e *envelope.Envelope) trace.SpanContext {
+	if e.Contexts == nil {
+		return trace.SpanContext{}
+	}
+	tc, ok := (*e.Contexts)[envelope.TRACE_CONTEXT].(map[string]interface{})
+	if !ok {
+		return trace.SpanContext{}
+	}
+	carrier := propagation.MapCarrier{}
+	for k, v := range tc {
+		if s, ok := v.(string); ok {
+			carrier[k] = s
+		}
+	}
+	return trace.SpanContextFromContext(traceContext.Extract(context.Background(), carrier))
+}
+
+// Start a span for work on a batch of envelopes.
+//
+// Batches are often published outside of the request which produced them, so
+// when ctx carries no span the batch's propagated trace contexts are used instead:
+// a batch from a single request continues that request's trace, and a batch
+// spanning many requests is linked to each of them.
+func StartBatch(ctx context.Context, name string, envelopes []envelope.Envelope, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		seen := make(map[trace.SpanID]struct{})
+		var links []trace.Link
+		for i := range envelopes {
+			sc := SpanContext(&envelopes[i])
+			if !sc.IsValid() {
+				continue
+			}
+			if _, ok := seen[sc.SpanID()]; ok {
+				continue
+			}
+			seen[sc.SpanID()] = struct{}{}
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+		if len(links) == 1 {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, links[0].SpanContext)
+		} else if len(links) > 1 {
+			opts = append(opts, trace.WithLinks(links...))
+		}
+	}
+	return Start(ctx, name, opts...)
+}
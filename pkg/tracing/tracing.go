@@ -0,0 +1,162 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/meta"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	OTLP string = "otlp"
+	FILE string = "file"
+)
+
+const (
+	INSTRUMENTATION_NAME string  = "github.com/silverton-io/buz"
+	DEFAULT_SERVICE_NAME string  = "buz"
+	DEFAULT_SAMPLE_RATIO float64 = 1
+)
+
+// Span names
+const (
+	BUILD_ENVELOPES string = "buz.envelopes.build"
+	SUPPRESS        string = "buz.privacy.suppress"
+	CONSENT         string = "buz.privacy.consent"
+	ANNOTATE        string = "buz.annotate"
+	VALIDATE        string = "buz.validate"
+	REGISTRY_FETCH  string = "buz.registry.fetch"
+	TRANSFORM       string = "buz.transform"
+	ENRICH          string = "buz.enrich"
+	ANONYMIZE       string = "buz.privacy.anonymize"
+	DEDUP           string = "buz.dedup"
+	DISTRIBUTE      string = "buz.manifold.distribute"
+	SINK_PUBLISH    string = "buz.sink.publish"
+)
+
+// A tracer provider exporting spans to an OTLP collector or a file.
+type Provider struct {
+	provider *sdktrace.TracerProvider
+	file     *os.File
+}
+
+func buildExporter(conf config.Tracing) (sdktrace.SpanExporter, *os.File, error) {
+	switch conf.Exporter {
+	case OTLP, "":
+		opts := []otlptracehttp.Option{}
+		if conf.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(conf.Endpoint))
+		}
+		if conf.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), opts...)
+		return exporter, nil, err
+	case FILE:
+		if conf.Path == "" {
+			return nil, nil, errors.New("file trace exporter requires a path")
+		}
+		f, err := os.OpenFile(conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	default:
+		return nil, nil, errors.New("unsupported trace exporter: " + conf.Exporter)
+	}
+}
+
+// The ratio of new traces to sample.
+// An explicit ratio of 0 samples no new traces, so only an unset ratio takes the default.
+func sampleRatio(conf config.Tracing) (float64, error) {
+	if conf.SampleRatio == nil {
+		return DEFAULT_SAMPLE_RATIO, nil
+	}
+	ratio := *conf.SampleRatio
+	if ratio < 0 || ratio > 1 {
+		return 0, errors.New("trace sampleRatio must be between 0 and 1")
+	}
+	return ratio, nil
+}
+
+// Build a tracer provider and install it, along with W3C trace context propagation, globally.
+func BuildProvider(conf config.Tracing, m *meta.CollectorMeta) (*Provider, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	ratio, err := sampleRatio(conf)
+	if err != nil {
+		return nil, err
+	}
+	exporter, f, err := buildExporter(conf)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := conf.ServiceName
+	if serviceName == "" {
+		serviceName = DEFAULT_SERVICE_NAME
+	}
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(serviceName),
+		semconv.ServiceVersionKey.String(m.Version),
+		semconv.ServiceInstanceIDKey.String(m.InstanceId.String()),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return &Provider{provider: provider, file: f}, nil
+}
+
+// Flush any buffered spans and stop exporting.
+func (p *Provider) Shutdown(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	if err := p.provider.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("🔴 could not cleanly shut down tracer provider")
+	}
+	if p.file != nil {
+		p.file.Close()
+	}
+}
+
+// Start a span using the global tracer provider.
+// When tracing is disabled the span is a no-op.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(INSTRUMENTATION_NAME).Start(ctx, name, opts...)
+}
+
+// Record an error on the span and mark it as failed.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
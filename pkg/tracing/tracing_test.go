@@ -0,0 +1,129 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/meta"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACE_ID    string = "4bf92f3577b34da6a3ce929d0e0e4736"
+	SPAN_ID     string = "00f067aa0ba902b7"
+	TRACEPARENT string = "00-" + TRACE_ID + "-" + SPAN_ID + "-01"
+)
+
+func TestPropagate(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("traceparent", TRACEPARENT)
+	envelopes := Propagate(config.Tracing{Enabled: true}, req, []envelope.Envelope{{}, {}})
+
+	for _, e := range envelopes {
+		tc := (*e.Contexts)[envelope.TRACE_CONTEXT].(map[string]interface{})
+		assert.Equal(t, TRACEPARENT, tc["traceparent"])
+		sc := SpanContext(&e)
+		assert.Equal(t, TRACE_ID, sc.TraceID().String())
+		assert.Equal(t, SPAN_ID, sc.SpanID().String())
+	}
+
+	untraced := Propagate(config.Tracing{Enabled: true}, httptest.NewRequest("POST", "/", nil), []envelope.Envelope{{}})
+	assert.Nil(t, untraced[0].Contexts)
+	assert.False(t, SpanContext(&untraced[0]).IsValid())
+
+	disabled := Propagate(config.Tracing{}, req, []envelope.Envelope{{}})
+	assert.Nil(t, disabled[0].Contexts)
+}
+
+func TestStartBatch(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	traced := func(traceparent string) envelope.Envelope {
+		return envelope.Envelope{Contexts: &map[string]interface{}{
+			envelope.TRACE_CONTEXT: map[string]interface{}{"traceparent": traceparent},
+		}}
+	}
+	other := "00-" + TRACE_ID + "-00f067aa0ba902b8-01"
+
+	_, span := StartBatch(context.Background(), SINK_PUBLISH, []envelope.Envelope{traced(TRACEPARENT), traced(TRACEPARENT)})
+	span.End()
+	_, span = StartBatch(context.Background(), SINK_PUBLISH, []envelope.Envelope{traced(TRACEPARENT), traced(other), {}})
+	span.End()
+
+	spans := recorder.Ended()
+	assert.Equal(t, 2, len(spans))
+	// A batch from a single request continues its trace
+	assert.Equal(t, SPAN_ID, spans[0].Parent().SpanID().String())
+	assert.Equal(t, 0, len(spans[0].Links()))
+	// A batch from many requests links to each
+	assert.False(t, spans[1].Parent().IsValid())
+	assert.Equal(t, 2, len(spans[1].Links()))
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	conf := config.Tracing{Enabled: true, Exporter: FILE, Path: path}
+	p, err := BuildProvider(conf, &meta.CollectorMeta{Version: "test"})
+	assert.Nil(t, err)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	_, span := Start(context.Background(), ANNOTATE)
+	span.End()
+	p.Shutdown(context.Background())
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	var spans []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s map[string]interface{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &s))
+		spans = append(spans, s)
+	}
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, ANNOTATE, spans[0]["Name"])
+}
+
+func TestBuildProvider(t *testing.T) {
+	p, err := BuildProvider(config.Tracing{}, &meta.CollectorMeta{})
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+	p.Shutdown(context.Background())
+
+	_, err = BuildProvider(config.Tracing{Enabled: true, Exporter: FILE}, &meta.CollectorMeta{})
+	assert.NotNil(t, err)
+	_, err = BuildProvider(config.Tracing{Enabled: true, Exporter: "carrier-pigeon"}, &meta.CollectorMeta{})
+	assert.NotNil(t, err)
+	invalid := 1.5
+	_, err = BuildProvider(config.Tracing{Enabled: true, SampleRatio: &invalid}, &meta.CollectorMeta{})
+	assert.NotNil(t, err)
+}
+
+func TestSampleRatio(t *testing.T) {
+	ratio, err := sampleRatio(config.Tracing{})
+	assert.Nil(t, err)
+	assert.Equal(t, DEFAULT_SAMPLE_RATIO, ratio)
+
+	zero := 0.0
+	ratio, err = sampleRatio(config.Tracing{SampleRatio: &zero})
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, ratio)
+}
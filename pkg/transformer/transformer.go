@@ -0,0 +1,239 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transformer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/util"
+)
+
+const (
+	RENAME string = "rename"
+	DROP   string = "drop"
+	COPY   string = "copy"
+	SET    string = "set"
+	COERCE string = "coerce"
+)
+
+const (
+	STRING string = "string"
+	INT    string = "int"
+	FLOAT  string = "float"
+	BOOL   string = "bool"
+)
+
+type rule struct {
+	op    string
+	path  string
+	to    string
+	value interface{}
+	typ   string
+}
+
+func buildRule(conf config.TransformRule) (*rule, error) {
+	if conf.Path == "" {
+		return nil, errors.New("transform rule requires a path")
+	}
+	r := rule{op: conf.Op, path: conf.Path, to: conf.To, value: conf.Value, typ: conf.Type}
+	switch conf.Op {
+	case RENAME, COPY:
+		if conf.To == "" {
+			return nil, errors.New(conf.Op + " transform rule requires a destination path")
+		}
+	case DROP, SET:
+	case COERCE:
+		switch conf.Type {
+		case STRING, INT, FLOAT, BOOL:
+		default:
+			return nil, errors.New("unsupported coerce type: " + conf.Type)
+		}
+	default:
+		return nil, errors.New("unsupported transform op: " + conf.Op)
+	}
+	return &r, nil
+}
+
+func coerce(v interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case STRING:
+		switch val := v.(type) {
+		case string:
+			return val, nil
+		case float64:
+			return strconv.FormatFloat(val, 'f', -1, 64), nil
+		default:
+			return fmt.Sprint(val), nil
+		}
+	case INT:
+		switch val := v.(type) {
+		case float64:
+			return int64(val), nil
+		case bool:
+			if val {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+				return i, nil
+			}
+			f, err := strconv.ParseFloat(val, 64)
+			return int64(f), err
+		}
+	case FLOAT:
+		switch val := v.(type) {
+		case float64:
+			return val, nil
+		case bool:
+			if val {
+				return float64(1), nil
+			}
+			return float64(0), nil
+		case string:
+			return strconv.ParseFloat(val, 64)
+		}
+	case BOOL:
+		switch val := v.(type) {
+		case bool:
+			return val, nil
+		case float64:
+			return val != 0, nil
+		case string:
+			return strconv.ParseBool(val)
+		}
+	}
+	return nil, fmt.Errorf("cannot coerce %T to %s", v, typ)
+}
+
+// Apply the rule to an envelope which has been converted to a map.
+// Rules referencing paths which do not exist are skipped.
+func (r *rule) apply(m map[string]interface{}) error {
+	switch r.op {
+	case RENAME, COPY:
+		v, ok := util.GetPath(m, r.path)
+		if !ok {
+			return nil
+		}
+		if !util.SetPath(m, r.to, v) {
+			return errors.New("could not set " + r.to)
+		}
+		if r.op == RENAME {
+			util.DeletePath(m, r.path)
+		}
+	case DROP:
+		util.DeletePath(m, r.path)
+	case SET:
+		if !util.SetPath(m, r.path, r.value) {
+			return errors.New("could not set " + r.path)
+		}
+	case COERCE:
+		v, ok := util.GetPath(m, r.path)
+		if !ok || v == nil {
+			return nil
+		}
+		coerced, err := coerce(v, r.typ)
+		if err != nil {
+			return err
+		}
+		util.SetPath(m, r.path, coerced)
+	}
+	return nil
+}
+
+type transform struct {
+	schema *regexp.Regexp
+	rules  []*rule
+}
+
+// Transformer reshapes envelopes using declarative rules,
+// keyed by a glob on the envelope's schema.
+//
+// Rule paths are relative to the envelope, such as `payload.price`,
+// `device.ip`, or `contexts.com\.acme/ctx/v1\.0\.json.id`.
+type Transformer struct {
+	transforms []transform
+}
+
+func BuildTransformer(conf []config.Transform) (*Transformer, error) {
+	t := Transformer{}
+	for _, c := range conf {
+		g, err := util.CompileGlob(c.Schema)
+		if err != nil {
+			return nil, err
+		}
+		tr := transform{schema: g}
+		for _, rc := range c.Rules {
+			r, err := buildRule(rc)
+			if err != nil {
+				log.Error().Err(err).Interface("rule", rc).Msg("🔴 invalid transform rule")
+				return nil, err
+			}
+			tr.rules = append(tr.rules, r)
+		}
+		t.transforms = append(t.transforms, tr)
+	}
+	return &t, nil
+}
+
+func (t *Transformer) rules(schema string) []*rule {
+	var rules []*rule
+	for _, tr := range t.transforms {
+		if tr.schema.MatchString(schema) {
+			rules = append(rules, tr.rules...)
+		}
+	}
+	return rules
+}
+
+func transformEnvelope(e envelope.Envelope, rules []*rule) (envelope.Envelope, error) {
+	m, err := e.AsMap()
+	if err != nil {
+		return e, err
+	}
+	for _, r := range rules {
+		if err := r.apply(m); err != nil {
+			return e, err
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return e, err
+	}
+	var transformed envelope.Envelope
+	if err := json.Unmarshal(b, &transformed); err != nil {
+		return e, err
+	}
+	return transformed, nil
+}
+
+// Transform applies every rule matching each envelope's schema.
+// Envelopes which cannot be transformed are passed through untouched.
+func (t *Transformer) Transform(envelopes []envelope.Envelope) []envelope.Envelope {
+	if t == nil || len(t.transforms) == 0 {
+		return envelopes
+	}
+	var envs []envelope.Envelope
+	for _, e := range envelopes {
+		rules := t.rules(e.EventMeta.Schema)
+		if len(rules) > 0 {
+			log.Debug().Msg("🟡 transforming event")
+			transformed, err := transformEnvelope(e, rules)
+			if err != nil {
+				log.Error().Err(err).Interface("schema", e.EventMeta.Schema).Msg("🔴 could not transform envelope")
+			}
+			e = transformed
+		}
+		envs = append(envs, e)
+	}
+	return envs
+}
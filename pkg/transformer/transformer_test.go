@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package transformer
+
+import (
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/silverton-io/buz/pkg/envelope"
+	"github.com/silverton-io/buz/pkg/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildEnvelope(schema string) envelope.Envelope {
+	valid := true
+	contexts := map[string]interface{}{
+		"com.acme/ctx/v1.0.json": map[string]interface{}{"id": "abc"},
+	}
+	return envelope.Envelope{
+		EventMeta:  envelope.EventMeta{Schema: schema},
+		Device:     envelope.Device{Ip: "10.0.0.1"},
+		Validation: envelope.Validation{IsValid: &valid},
+		Contexts:   &contexts,
+		Payload:    event.Payload{"price": "10.5", "qty": "2", "bad_name": "x", "secret": "shh"},
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tr, err := BuildTransformer([]config.Transform{{
+		Schema: "com.acme/checkout/*",
+		Rules: []config.TransformRule{
+			{Op: RENAME, Path: "payload.bad_name", To: "payload.good_name"},
+			{Op: DROP, Path: "payload.secret"},
+			{Op: COPY, Path: `contexts.com\.acme/ctx/v1\.0\.json.id`, To: "payload.ctx_id"},
+			{Op: SET, Path: "payload.source", Value: "web"},
+			{Op: COERCE, Path: "payload.price", Type: FLOAT},
+			{Op: COERCE, Path: "payload.qty", Type: INT},
+			{Op: SET, Path: "device.name", Value: "kiosk"},
+		},
+	}})
+	assert.Nil(t, err)
+
+	envelopes := tr.Transform([]envelope.Envelope{buildEnvelope("com.acme/checkout/purchase/v1.0.json"), buildEnvelope("com.acme/other/v1.0.json")})
+	p := envelopes[0].Payload
+	assert.Equal(t, "x", p["good_name"])
+	assert.NotContains(t, p, "bad_name")
+	assert.NotContains(t, p, "secret")
+	assert.Equal(t, "abc", p["ctx_id"])
+	assert.Equal(t, "web", p["source"])
+	assert.Equal(t, 10.5, p["price"])
+	assert.Equal(t, float64(2), p["qty"])
+	assert.Equal(t, "kiosk", *envelopes[0].Device.Name)
+	assert.Equal(t, "10.0.0.1", envelopes[0].Device.Ip)
+
+	// Envelopes with other schemas are untouched
+	assert.Equal(t, "shh", envelopes[1].Payload["secret"])
+}
+
+func TestTransformFailurePassesThrough(t *testing.T) {
+	tr, _ := BuildTransformer([]config.Transform{{
+		Schema: "*",
+		Rules:  []config.TransformRule{{Op: COERCE, Path: "payload.bad_name", Type: INT}},
+	}})
+	envelopes := tr.Transform([]envelope.Envelope{buildEnvelope("")})
+	assert.Equal(t, "x", envelopes[0].Payload["bad_name"])
+}
+
+func TestCoerce(t *testing.T) {
+	var tests = []struct {
+		in   interface{}
+		typ  string
+		want interface{}
+	}{
+		{float64(3), STRING, "3"},
+		{true, STRING, "true"},
+		{"7", INT, int64(7)},
+		{"7.9", INT, int64(7)},
+		{true, FLOAT, float64(1)},
+		{"true", BOOL, true},
+		{float64(0), BOOL, false},
+	}
+	for _, tt := range tests {
+		got, err := coerce(tt.in, tt.typ)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func TestInvalidTransformRules(t *testing.T) {
+	for _, r := range []config.TransformRule{
+		{Op: RENAME, Path: "payload.a"},
+		{Op: COERCE, Path: "payload.a", Type: "date"},
+		{Op: "explode", Path: "payload.a"},
+		{Op: DROP},
+	} {
+		_, err := BuildTransformer([]config.Transform{{Schema: "*", Rules: []config.TransformRule{r}}})
+		assert.NotNil(t, err)
+	}
+}
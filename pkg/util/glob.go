@@ -0,0 +1,19 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compile a glob into an anchored regexp.
+// `*` matches any sequence of characters (including `/`) and `?` matches a single character.
+func CompileGlob(glob string) (*regexp.Regexp, error) {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+	pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+	return regexp.Compile("^" + pattern + "$")
+}
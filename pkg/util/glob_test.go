@@ -0,0 +1,23 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileGlob(t *testing.T) {
+	g, err := CompileGlob("com.acme/billing/*")
+	assert.Nil(t, err)
+	assert.True(t, g.MatchString("com.acme/billing/invoice/v1.0.json"))
+	assert.False(t, g.MatchString("com.acme/shipping/invoice/v1.0.json"))
+	assert.False(t, g.MatchString("comXacme/billing/invoice/v1.0.json"))
+
+	g, _ = CompileGlob("1.?")
+	assert.True(t, g.MatchString("1.2"))
+	assert.False(t, g.MatchString("1.23"))
+}
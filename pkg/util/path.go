@@ -0,0 +1,106 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package util
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Split a dot-delimited path into its keys.
+// Dots which are part of a key (such as a schema name) are escaped with a backslash.
+func SplitPath(path string) []string {
+	var keys []string
+	var key strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path) && path[i+1] == '.':
+			key.WriteByte('.')
+			i++
+		case path[i] == '.':
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(path[i])
+		}
+	}
+	return append(keys, key.String())
+}
+
+// Get the value at the path of a nested map.
+func GetPath(m map[string]interface{}, path string) (interface{}, bool) {
+	keys := SplitPath(path)
+	current := m
+	for i, k := range keys {
+		v, ok := current[k]
+		if !ok {
+			return nil, false
+		}
+		if i == len(keys)-1 {
+			return v, true
+		}
+		if current, ok = v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Set the value at the path of a nested map, creating intermediate maps as needed.
+// Returns false if an intermediate key holds something other than a map.
+func SetPath(m map[string]interface{}, path string, value interface{}) bool {
+	keys := SplitPath(path)
+	current := m
+	for _, k := range keys[:len(keys)-1] {
+		v, ok := current[k]
+		if !ok || v == nil {
+			next := make(map[string]interface{})
+			current[k] = next
+			current = next
+			continue
+		}
+		if current, ok = v.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	current[keys[len(keys)-1]] = value
+	return true
+}
+
+// Delete the value at the path of a nested map.
+// Returns false if there was nothing to delete.
+func DeletePath(m map[string]interface{}, path string) bool {
+	keys := SplitPath(path)
+	current := m
+	for _, k := range keys[:len(keys)-1] {
+		v, ok := current[k].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = v
+	}
+	last := keys[len(keys)-1]
+	if _, ok := current[last]; !ok {
+		return false
+	}
+	delete(current, last)
+	return true
+}
+
+// The string form of a value found at a path.
+// Strings are returned as-is and any other value as its json encoding.
+func PathString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
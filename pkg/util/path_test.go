@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitPath(t *testing.T) {
+	assert.Equal(t, []string{"payload", "a", "b"}, SplitPath("payload.a.b"))
+	assert.Equal(t, []string{"contexts", "com.acme/ctx/v1.0.json", "id"}, SplitPath(`contexts.com\.acme/ctx/v1\.0\.json.id`))
+}
+
+func TestPaths(t *testing.T) {
+	m := map[string]interface{}{
+		"payload": map[string]interface{}{"a": "b"},
+	}
+	v, ok := GetPath(m, "payload.a")
+	assert.True(t, ok)
+	assert.Equal(t, "b", v)
+	_, ok = GetPath(m, "payload.a.c")
+	assert.False(t, ok)
+
+	assert.True(t, SetPath(m, "payload.nested.c", 1))
+	v, _ = GetPath(m, "payload.nested.c")
+	assert.Equal(t, 1, v)
+	assert.False(t, SetPath(m, "payload.a.c", 1))
+
+	assert.True(t, DeletePath(m, "payload.a"))
+	assert.False(t, DeletePath(m, "payload.a"))
+	_, ok = GetPath(m, "payload.a")
+	assert.False(t, ok)
+}
+
+func TestPathString(t *testing.T) {
+	assert.Equal(t, "abc", PathString("abc"))
+	assert.Equal(t, "", PathString(nil))
+	assert.Equal(t, "42", PathString(float64(42)))
+	assert.Equal(t, `{"a":"b"}`, PathString(map[string]interface{}{"a": "b"}))
+}
@@ -38,3 +38,8 @@ var NoSchemaInBackend = InvalidMessage{
 	Type:       "schema not published to cache backend",
 	Resolution: "publish schema to the cache backend",
 }
+
+var InvalidatedByEnricher = InvalidMessage{
+	Type:       "invalidated by enricher",
+	Resolution: "see the payload validation errors for the reason",
+}
@@ -5,13 +5,23 @@
 package validator
 
 import (
+	"context"
+
 	"github.com/rs/zerolog/log"
 	"github.com/silverton-io/buz/pkg/envelope"
 	"github.com/silverton-io/buz/pkg/event"
 	"github.com/silverton-io/buz/pkg/registry"
+	"github.com/silverton-io/buz/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ValidatePayload(schemaName string, payload event.Payload, registry *registry.Registry) (isValid bool, validationError envelope.ValidationError, schema []byte) {
+func ValidatePayload(ctx context.Context, schemaName string, payload event.Payload, registry *registry.Registry) (isValid bool, validationError envelope.ValidationError, schema []byte) {
+	ctx, span := tracing.Start(ctx, tracing.VALIDATE, trace.WithAttributes(attribute.String("buz.schema", schemaName)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("buz.valid", isValid))
+		span.End()
+	}()
 	// FIXME- Short-circuit if the event is an unknown event
 	if schemaName == "" {
 		validationError := envelope.ValidationError{
@@ -21,7 +31,7 @@ func ValidatePayload(schemaName string, payload event.Payload, registry *registr
 		}
 		return false, validationError, nil
 	}
-	schemaExists, schemaContents := registry.Get(schemaName)
+	schemaExists, schemaContents := registry.GetContext(ctx, schemaName)
 	if !schemaExists {
 		validationError := envelope.ValidationError{
 			ErrorType:       &NoSchemaInBackend.Type,
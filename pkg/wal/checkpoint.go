@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package wal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+const CHECKPOINT_FILE string = "checkpoint"
+
+// A position in the log - the segment id and the byte offset within it.
+type Position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// The position of the next record to replay, as of the last commit.
+// A log without a checkpoint is replayed from the beginning.
+func (l *Log) Checkpoint() (Position, error) {
+	var p Position
+	b, err := os.ReadFile(filepath.Join(l.dir, CHECKPOINT_FILE))
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(b, &p)
+	return p, err
+}
+
+// Commit records that everything before the position has been replayed,
+// then removes consumed segments according to the retention policy.
+func (l *Log) Commit(p Position) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.dir, CHECKPOINT_FILE)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if l.fsync != FSYNC_NEVER {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Error().Err(err).Msg("🔴 could not write wal checkpoint")
+		return err
+	}
+	return l.truncate(p.Segment)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNoRecord is returned when the reader has caught up with the writer.
+var ErrNoRecord = errors.New("no wal record available")
+
+// Reads records sequentially, starting from a position in the log.
+type Reader struct {
+	log      *Log
+	position Position
+	file     *os.File
+	fileId   uint64
+}
+
+func (l *Log) NewReader(from Position) *Reader {
+	ids, err := listSegments(l.dir)
+	if err == nil && len(ids) > 0 && from.Segment < ids[0] {
+		// Everything before the oldest remaining segment has been truncated
+		from = Position{Segment: ids[0]}
+	}
+	return &Reader{log: l, position: from}
+}
+
+// The position of the next record to be read.
+func (r *Reader) Position() Position {
+	return r.position
+}
+
+func (r *Reader) open(id uint64) error {
+	if r.file != nil && r.fileId == id {
+		return nil
+	}
+	r.Close()
+	f, err := os.Open(segmentPath(r.log.dir, id))
+	if err != nil {
+		return err
+	}
+	r.file, r.fileId = f, id
+	return nil
+}
+
+// Advance to the start of the next segment.
+func (r *Reader) skipSegment() {
+	r.Close()
+	r.position = Position{Segment: r.position.Segment + 1}
+}
+
+// Next returns the next record in the log, or ErrNoRecord once the
+// reader has caught up with the active segment.
+func (r *Reader) Next() ([]byte, error) {
+	for {
+		if r.position.Segment == 0 {
+			r.position.Segment = 1
+		}
+		sealed := r.position.Segment < r.log.activeSegment()
+		if err := r.open(r.position.Segment); err != nil {
+			if os.IsNotExist(err) && sealed {
+				r.skipSegment()
+				continue
+			}
+			if os.IsNotExist(err) {
+				return nil, ErrNoRecord
+			}
+			return nil, err
+		}
+		data, err := r.readAt(r.position.Offset)
+		if err == nil {
+			r.position.Offset += HEADER_SIZE + int64(len(data))
+			return data, nil
+		}
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, errCorruptRecord) {
+			return nil, err
+		}
+		if !sealed {
+			// The writer may still be appending to this segment.
+			return nil, ErrNoRecord
+		}
+		if !errors.Is(err, io.EOF) {
+			log.Warn().Err(err).Uint64("segment", r.position.Segment).Int64("offset", r.position.Offset).Msg("🟡 skipping torn or corrupt wal segment tail")
+		}
+		r.skipSegment()
+	}
+}
+
+var errCorruptRecord = errors.New("corrupt wal record")
+
+func (r *Reader) readAt(offset int64) ([]byte, error) {
+	header := make([]byte, HEADER_SIZE)
+	if _, err := r.file.ReadAt(header, offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			// A partially-written header is a torn write rather than a clean end of segment
+			stat, statErr := r.file.Stat()
+			if statErr == nil && stat.Size() > offset {
+				return nil, io.ErrUnexpectedEOF
+			}
+		}
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if size > MAX_RECORD_SIZE_BYTES {
+		return nil, errCorruptRecord
+	}
+	data := make([]byte, size)
+	if _, err := r.file.ReadAt(data, offset+HEADER_SIZE); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, errCorruptRecord
+	}
+	return data, nil
+}
+
+func (r *Reader) Close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/silverton-io/buz/pkg/config"
+)
+
+const (
+	FSYNC_ALWAYS   string = "always"
+	FSYNC_INTERVAL string = "interval"
+	FSYNC_NEVER    string = "never"
+
+	DEFAULT_SEGMENT_SIZE_BYTES int64  = 64 * 1024 * 1024
+	DEFAULT_FSYNC_INTERVAL_MS  int    = 1000
+	SEGMENT_EXTENSION          string = ".seg"
+	HEADER_SIZE                int64  = 8 // 4 byte length + 4 byte crc32
+	MAX_RECORD_SIZE_BYTES      uint32 = 256 * 1024 * 1024
+	segmentFilenameFormat      string = "%020d" + SEGMENT_EXTENSION
+)
+
+var (
+	ErrClosed = errors.New("wal is closed")
+	ErrFull   = errors.New("wal has reached its max size")
+)
+
+// A segmented, append-only log of opaque records.
+//
+// Records are framed as [length][crc32][payload] and appended to the
+// active segment, which is rolled once it exceeds the configured size.
+// A new segment is always started on open so a torn write at the tail
+// of a previous run never has data appended after it.
+//
+// If a max size is configured, appends are rejected with ErrFull once the
+// segments on disk would exceed it, until replay frees up space.
+type Log struct {
+	mu             sync.Mutex
+	dir            string
+	segmentSize    int64
+	fsync          string
+	retainSegments int
+	maxSize        int64
+	size           int64
+	active         *os.File
+	activeId       uint64
+	activeSize     int64
+	appended       chan struct{}
+	shutdown       chan struct{}
+	closed         bool
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf(segmentFilenameFormat, id))
+}
+
+// List the ids of all segments in the directory, in ascending order.
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, SEGMENT_EXTENSION) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, SEGMENT_EXTENSION), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func Open(conf config.Wal) (*Log, error) {
+	if conf.Path == "" {
+		return nil, errors.New("wal path must be set")
+	}
+	if err := os.MkdirAll(conf.Path, 0755); err != nil {
+		log.Error().Err(err).Msg("🔴 could not create wal directory")
+		return nil, err
+	}
+	l := &Log{
+		dir:            conf.Path,
+		segmentSize:    conf.SegmentSizeBytes,
+		fsync:          conf.Fsync,
+		retainSegments: conf.RetainSegments,
+		maxSize:        conf.MaxSizeBytes,
+		appended:       make(chan struct{}, 1),
+		shutdown:       make(chan struct{}),
+	}
+	if l.segmentSize <= 0 {
+		l.segmentSize = DEFAULT_SEGMENT_SIZE_BYTES
+	}
+	switch l.fsync {
+	case FSYNC_ALWAYS, FSYNC_INTERVAL, FSYNC_NEVER:
+	case "":
+		l.fsync = FSYNC_INTERVAL
+	default:
+		return nil, errors.New("unsupported wal fsync policy: " + l.fsync)
+	}
+	ids, err := listSegments(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		stat, err := os.Stat(segmentPath(l.dir, id))
+		if err != nil {
+			return nil, err
+		}
+		l.size += stat.Size()
+	}
+	var next uint64 = 1
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+	if err := l.openSegment(next); err != nil {
+		return nil, err
+	}
+	if l.fsync == FSYNC_INTERVAL {
+		interval := conf.FsyncIntervalMs
+		if interval <= 0 {
+			interval = DEFAULT_FSYNC_INTERVAL_MS
+		}
+		go l.syncEvery(time.Duration(interval) * time.Millisecond)
+	}
+	log.Debug().Str("path", l.dir).Uint64("segment", next).Msg("🟡 wal opened")
+	return l, nil
+}
+
+func (l *Log) openSegment(id uint64) error {
+	f, err := os.OpenFile(segmentPath(l.dir, id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not open wal segment")
+		return err
+	}
+	l.active, l.activeId, l.activeSize = f, id, 0
+	return nil
+}
+
+func (l *Log) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			if !l.closed {
+				if err := l.active.Sync(); err != nil {
+					log.Error().Err(err).Msg("🔴 could not sync wal segment")
+				}
+			}
+			l.mu.Unlock()
+		case <-l.shutdown:
+			return
+		}
+	}
+}
+
+// Append a record to the log.
+// When the fsync policy is `always` the record is on stable storage once Append returns.
+func (l *Log) Append(data []byte) error {
+	if uint32(len(data)) > MAX_RECORD_SIZE_BYTES {
+		return errors.New("wal record exceeds max record size")
+	}
+	record := make([]byte, HEADER_SIZE+int64(len(data)))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[HEADER_SIZE:], data)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.maxSize > 0 && l.size+int64(len(record)) > l.maxSize {
+		return ErrFull
+	}
+	if l.activeSize > 0 && l.activeSize+int64(len(record)) > l.segmentSize {
+		if err := l.roll(); err != nil {
+			return err
+		}
+	}
+	n, err := l.active.Write(record)
+	l.activeSize += int64(n)
+	l.size += int64(n)
+	if err != nil {
+		log.Error().Err(err).Msg("🔴 could not append to wal")
+		// Never append after a partial write - start a fresh segment instead.
+		if rollErr := l.roll(); rollErr != nil {
+			log.Error().Err(rollErr).Msg("🔴 could not roll wal segment")
+		}
+		return err
+	}
+	if l.fsync == FSYNC_ALWAYS {
+		if err := l.active.Sync(); err != nil {
+			log.Error().Err(err).Msg("🔴 could not sync wal segment")
+			return err
+		}
+	}
+	select {
+	case l.appended <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Seal the active segment and start a new one. Must be called with the lock held.
+func (l *Log) roll() error {
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	return l.openSegment(l.activeId + 1)
+}
+
+// Appended is signalled (at most once per pending read) after a record is appended.
+func (l *Log) Appended() <-chan struct{} {
+	return l.appended
+}
+
+func (l *Log) activeSegment() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.activeId
+}
+
+// Remove fully-consumed segments, keeping the most recent `retainSegments` of them.
+func (l *Log) truncate(consumedBefore uint64) error {
+	ids, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	var consumed []uint64
+	for _, id := range ids {
+		if id < consumedBefore {
+			consumed = append(consumed, id)
+		}
+	}
+	if len(consumed) <= l.retainSegments {
+		return nil
+	}
+	for _, id := range consumed[:len(consumed)-l.retainSegments] {
+		log.Debug().Uint64("segment", id).Msg("🟡 removing consumed wal segment")
+		path := segmentPath(l.dir, id)
+		stat, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l.mu.Lock()
+		l.size -= stat.Size()
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.shutdown)
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	return l.active.Close()
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2022 Silverton Data, Inc.
+// You may use, distribute, and modify this code under the terms of the Apache-2.0 license, a copy of
+// which may be found at https://github.com/silverton-io/buz/blob/main/LICENSE
+
+package wal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/silverton-io/buz/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAll(t *testing.T, r *Reader) []string {
+	var records []string
+	for {
+		data, err := r.Next()
+		if err == ErrNoRecord {
+			return records
+		}
+		assert.Nil(t, err)
+		records = append(records, string(data))
+	}
+}
+
+func TestAppendAndRead(t *testing.T) {
+	l, err := Open(config.Wal{Path: t.TempDir(), Fsync: FSYNC_ALWAYS})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	for _, r := range []string{"one", "two", "three"} {
+		assert.Nil(t, l.Append([]byte(r)))
+	}
+	reader := l.NewReader(Position{})
+	defer reader.Close()
+	assert.Equal(t, []string{"one", "two", "three"}, readAll(t, reader))
+
+	assert.Nil(t, l.Append([]byte("four")))
+	assert.Equal(t, []string{"four"}, readAll(t, reader))
+}
+
+func TestSegmentsRoll(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(config.Wal{Path: dir, SegmentSizeBytes: 20, Fsync: FSYNC_NEVER})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	for _, r := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc"} {
+		assert.Nil(t, l.Append([]byte(r)))
+	}
+	ids, _ := listSegments(dir)
+	assert.Equal(t, []uint64{1, 2, 3}, ids)
+
+	reader := l.NewReader(Position{})
+	defer reader.Close()
+	assert.Equal(t, []string{"aaaaaaaa", "bbbbbbbb", "cccccccc"}, readAll(t, reader))
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Wal{Path: dir, Fsync: FSYNC_ALWAYS}
+	l, err := Open(conf)
+	assert.Nil(t, err)
+	for _, r := range []string{"one", "two", "three"} {
+		assert.Nil(t, l.Append([]byte(r)))
+	}
+	reader := l.NewReader(Position{})
+	data, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "one", string(data))
+	assert.Nil(t, l.Commit(reader.Position()))
+	reader.Close()
+	assert.Nil(t, l.Close())
+
+	// Reopen - replay picks up after the last committed record
+	l, err = Open(conf)
+	assert.Nil(t, err)
+	defer l.Close()
+	assert.Nil(t, l.Append([]byte("four")))
+	checkpoint, err := l.Checkpoint()
+	assert.Nil(t, err)
+	reader = l.NewReader(checkpoint)
+	defer reader.Close()
+	assert.Equal(t, []string{"two", "three", "four"}, readAll(t, reader))
+}
+
+func TestTornTailIsSkipped(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.Wal{Path: dir, Fsync: FSYNC_ALWAYS}
+	l, err := Open(conf)
+	assert.Nil(t, err)
+	assert.Nil(t, l.Append([]byte("complete")))
+	assert.Nil(t, l.Close())
+
+	// Simulate a crash part-way through writing a record
+	f, err := os.OpenFile(segmentPath(dir, 1), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 42, 1, 2})
+	assert.Nil(t, err)
+	f.Close()
+
+	l, err = Open(conf)
+	assert.Nil(t, err)
+	defer l.Close()
+	assert.Nil(t, l.Append([]byte("after restart")))
+	reader := l.NewReader(Position{})
+	defer reader.Close()
+	assert.Equal(t, []string{"complete", "after restart"}, readAll(t, reader))
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(config.Wal{Path: dir, SegmentSizeBytes: 10, Fsync: FSYNC_NEVER, RetainSegments: 1})
+	assert.Nil(t, err)
+	defer l.Close()
+	for _, r := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		assert.Nil(t, l.Append([]byte(r)))
+	}
+	reader := l.NewReader(Position{})
+	defer reader.Close()
+	readAll(t, reader)
+	assert.Nil(t, l.Commit(reader.Position()))
+
+	ids, _ := listSegments(dir)
+	assert.Equal(t, []uint64{3, 4}, ids)
+}
+
+func TestUnsupportedFsyncPolicy(t *testing.T) {
+	_, err := Open(config.Wal{Path: t.TempDir(), Fsync: "sometimes"})
+	assert.NotNil(t, err)
+}
+
+func TestMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	// Each record takes 8 header bytes plus 4 payload bytes
+	l, err := Open(config.Wal{Path: dir, SegmentSizeBytes: 12, MaxSizeBytes: 24, Fsync: FSYNC_NEVER})
+	assert.Nil(t, err)
+	defer l.Close()
+	assert.Nil(t, l.Append([]byte("aaaa")))
+	assert.Nil(t, l.Append([]byte("bbbb")))
+	assert.ErrorIs(t, l.Append([]byte("cccc")), ErrFull)
+
+	// Replaying and committing frees up space
+	reader := l.NewReader(Position{})
+	defer reader.Close()
+	assert.Equal(t, []string{"aaaa", "bbbb"}, readAll(t, reader))
+	assert.Nil(t, l.Commit(reader.Position()))
+	assert.Nil(t, l.Append([]byte("cccc")))
+}